@@ -6,6 +6,9 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
 	appconfig "github.com/j4y_funabashi/inari/apps/api/pkg/app_config"
@@ -20,8 +23,32 @@ func main() {
 	////////////////////
 
 	importMedia := app.ImportDir(appconfig.NewMediaImporter(baseDir), logger)
+	importSource := appconfig.NewSourceImporter(baseDir, logger)
 	listCollections := appconfig.NewListCollections(baseDir)
 	importGPX := app.ImportDir(appconfig.NewImportGPX(baseDir), logger)
+	searchMedia := appconfig.NewSearchMedia(baseDir)
+	listMedia := appconfig.NewMediaLister(baseDir)
+	createAlbum := appconfig.NewCreateAlbum(baseDir)
+	addMediaToAlbum := appconfig.NewAddMediaToAlbum(baseDir)
+	removeMediaFromAlbum := appconfig.NewRemoveMediaFromAlbum(baseDir)
+	reorderAlbum := appconfig.NewReorderAlbum(baseDir)
+	updateAlbum := appconfig.NewUpdateAlbum(baseDir)
+	setTriageState := appconfig.NewSetTriageState(baseDir)
+	addMediaHashtag := appconfig.NewUpdateMediaHashtag(baseDir)
+	removeMediaHashtag := appconfig.NewRemoveMediaHashtag(baseDir)
+	listHashtags := appconfig.NewListHashtags(baseDir)
+	renameHashtag := appconfig.NewRenameHashtag(baseDir)
+	normaliseHashtags := appconfig.NewNormaliseHashtags(baseDir)
+	importKeywords := appconfig.NewImportKeywords(baseDir)
+	listTrash := appconfig.NewListTrash(baseDir)
+	restoreMedia := appconfig.NewRestoreMedia(baseDir)
+	purgeTrash := appconfig.NewPurgeTrash(baseDir, logger)
+	regenerateThumbnails := appconfig.NewThumbnailRegenerator(baseDir, logger)
+	thumbnailWorker := appconfig.NewThumbnailWorker(baseDir, logger)
+	backfillPlaceholders := appconfig.NewPlaceholderBackfiller(baseDir, logger)
+	fsck := appconfig.NewFsck(baseDir, logger)
+	migrateDB := appconfig.NewMigrateDB(baseDir)
+	schemaStatus := appconfig.NewSchemaStatus(baseDir)
 
 	// app commands
 	app := &cli.App{
@@ -29,11 +56,18 @@ func main() {
 		Usage: "photo organiser",
 		Commands: []*cli.Command{
 			{
-				Name:    "import",
-				Aliases: []string{"i"},
-				Usage:   "import media",
+				Name:      "import",
+				Aliases:   []string{"i"},
+				Usage:     "import media from a file, dir or remote source",
+				ArgsUsage: "<path | s3://bucket/prefix/ | webdav://host/path/>",
 				Action: func(cCtx *cli.Context) error {
 					inputFilename := cCtx.Args().First()
+					if strings.Contains(inputFilename, "://") {
+						result, err := importSource(inputFilename)
+						out, _ := json.Marshal(result)
+						fmt.Printf("%s", string(out))
+						return err
+					}
 					err := importMedia(inputFilename)
 					return err
 				},
@@ -60,9 +94,361 @@ func main() {
 				},
 			},
 			{
-				Name:        "media",
-				Usage:       "list media",
-				Subcommands: []*cli.Command{},
+				Name:  "album",
+				Usage: "manage albums",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "create",
+						Usage:     "create an album",
+						ArgsUsage: "<title>",
+						Action: func(cCtx *cli.Context) error {
+							album, err := createAlbum(cCtx.Args().First())
+							out, _ := json.Marshal(album)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+					{
+						Name:      "add",
+						Usage:     "add media to an album",
+						ArgsUsage: "<collection id> <media id>...",
+						Action: func(cCtx *cli.Context) error {
+							for _, mediaID := range cCtx.Args().Tail() {
+								err := addMediaToAlbum(cCtx.Args().First(), mediaID)
+								if err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "remove media from an album",
+						ArgsUsage: "<collection id> <media id>...",
+						Action: func(cCtx *cli.Context) error {
+							for _, mediaID := range cCtx.Args().Tail() {
+								err := removeMediaFromAlbum(cCtx.Args().First(), mediaID)
+								if err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "order",
+						Usage:     "move media to the start of an album in the given order",
+						ArgsUsage: "<collection id> <media id>...",
+						Action: func(cCtx *cli.Context) error {
+							return reorderAlbum(cCtx.Args().First(), cCtx.Args().Tail())
+						},
+					},
+					{
+						Name:      "update",
+						Usage:     "set album title, description or cover",
+						ArgsUsage: "<collection id>",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "title"},
+							&cli.StringFlag{Name: "description"},
+							&cli.StringFlag{Name: "cover", Usage: "media id of the cover"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							update := app.AlbumUpdate{}
+							if cCtx.IsSet("title") {
+								title := cCtx.String("title")
+								update.Title = &title
+							}
+							if cCtx.IsSet("description") {
+								description := cCtx.String("description")
+								update.Description = &description
+							}
+							if cCtx.IsSet("cover") {
+								cover := cCtx.String("cover")
+								update.CoverMediaID = &cover
+							}
+							album, err := updateAlbum(cCtx.Args().First(), update)
+							out, _ := json.Marshal(album)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "tag",
+				Usage: "manage hashtags",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "ls",
+						Usage: "list hashtags with usage counts",
+						Action: func(cCtx *cli.Context) error {
+							hashtags, err := listHashtags()
+							out, _ := json.Marshal(hashtags)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+					{
+						Name:      "add",
+						Usage:     "tag media",
+						ArgsUsage: "<tag> <media id>...",
+						Action: func(cCtx *cli.Context) error {
+							for _, mediaID := range cCtx.Args().Tail() {
+								err := addMediaHashtag(mediaID, cCtx.Args().First())
+								if err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "rm",
+						Usage:     "remove a tag from media",
+						ArgsUsage: "<tag> <media id>...",
+						Action: func(cCtx *cli.Context) error {
+							for _, mediaID := range cCtx.Args().Tail() {
+								err := removeMediaHashtag(mediaID, cCtx.Args().First())
+								if err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					},
+					{
+						Name:      "rename",
+						Usage:     "rename a tag across the library, renaming to an existing tag merges them",
+						ArgsUsage: "<from> <to>",
+						Action: func(cCtx *cli.Context) error {
+							hashtag, err := renameHashtag(cCtx.Args().Get(0), cCtx.Args().Get(1))
+							out, _ := json.Marshal(hashtag)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+					{
+						Name:  "normalise",
+						Usage: "lowercase all tags, merging tags that only differ by case",
+						Action: func(cCtx *cli.Context) error {
+							hashtags, err := normaliseHashtags()
+							out, _ := json.Marshal(hashtags)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+					{
+						Name:  "import-keywords",
+						Usage: "tag existing media with hashtags from their embedded keywords, using keyword-rules.json",
+						Action: func(cCtx *cli.Context) error {
+							tagged, err := importKeywords()
+							if err != nil {
+								return err
+							}
+							logger.Info("imported keywords as hashtags", "media", tagged)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "trash",
+				Usage: "list, restore and purge deleted media",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "ls",
+						Usage: "list deleted media",
+						Action: func(cCtx *cli.Context) error {
+							trash, err := listTrash()
+							out, _ := json.Marshal(trash)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+					{
+						Name:      "restore",
+						Usage:     "restore deleted media",
+						ArgsUsage: "<media id>...",
+						Action: func(cCtx *cli.Context) error {
+							for _, mediaID := range cCtx.Args().Slice() {
+								err := restoreMedia(mediaID)
+								if err != nil {
+									return err
+								}
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "permanently remove originals, thumbnails and index entries of media deleted before the retention window",
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "older-than", Value: "30d", Usage: "retention window eg: 30d, 12h"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							olderThan, err := parseRetention(cCtx.String("older-than"))
+							if err != nil {
+								return err
+							}
+							purged, err := purgeTrash(olderThan)
+							logger.Info("purged trash", "media", len(purged))
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "thumbnails",
+				Usage: "manage thumbnails",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "regenerate",
+						Usage: "recreate thumbnails of all media using thumbnail-profiles.json",
+						Action: func(cCtx *cli.Context) error {
+							regenerated, err := regenerateThumbnails()
+							logger.Info("regenerated thumbnails", "media", regenerated)
+							return err
+						},
+					},
+					{
+						Name:  "work",
+						Usage: "create thumbnails of imported media that are queued and due",
+						Action: func(cCtx *cli.Context) error {
+							processed, err := thumbnailWorker(time.Now())
+							logger.Info("created thumbnails", "media", processed)
+							return err
+						},
+					},
+					{
+						Name:  "placeholders",
+						Usage: "compute blurhash and dominant colour placeholders of media that has none from its lg thumbnail",
+						Action: func(cCtx *cli.Context) error {
+							backfilled, err := backfillPlaceholders()
+							logger.Info("computed placeholders", "media", backfilled)
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "fsck",
+				Usage: "check the index, media store and thumbnails agree",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "repair", Usage: "trash media with missing originals, queue missing thumbnails, import orphaned originals and remove orphaned thumbnails and dangling rows"},
+					&cli.BoolFlag{Name: "quick", Usage: "do not re-hash originals"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					report, err := fsck(app.FsckOptions{
+						Repair: cCtx.Bool("repair"),
+						Quick:  cCtx.Bool("quick"),
+					})
+					out, _ := json.Marshal(report)
+					fmt.Printf("%s", string(out))
+					if err != nil {
+						return err
+					}
+					if unrepaired := report.Unrepaired(); unrepaired > 0 {
+						return fmt.Errorf("fsck found %d unrepaired issues", unrepaired)
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "reindex",
+				Usage: "rebuild the index, the current index is kept in backups",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "from-store", Usage: "rebuild from the originals in the media store and their sidecars"},
+				},
+				Action: func(cCtx *cli.Context) error {
+					if !cCtx.Bool("from-store") {
+						return fmt.Errorf("reindex needs a source, use --from-store")
+					}
+					result, err := appconfig.NewReindexer(baseDir, logger)()
+					out, _ := json.Marshal(result)
+					fmt.Printf("%s", string(out))
+					return err
+				},
+			},
+			{
+				Name:      "triage",
+				Usage:     "mark media as new, reviewed or archived, reviewed and archived media leaves the inbox",
+				ArgsUsage: "<media id>...",
+				Flags: []cli.Flag{
+					&cli.StringFlag{Name: "state", Value: string(app.TriageStateReviewed)},
+				},
+				Action: func(cCtx *cli.Context) error {
+					return setTriageState(cCtx.Args().Slice(), app.TriageState(cCtx.String("state")))
+				},
+			},
+			{
+				Name:  "search",
+				Usage: "full text search media captions, titles, keywords, tags and places",
+				Action: func(cCtx *cli.Context) error {
+					query := strings.Join(cCtx.Args().Slice(), " ")
+					results, err := searchMedia(query)
+					out, _ := json.Marshal(results)
+					fmt.Printf("%s", string(out))
+					return err
+				},
+			},
+			{
+				Name:  "db",
+				Usage: "manage the media index db",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "migrate",
+						Usage: "backup the db and apply pending schema migrations",
+						Action: func(cCtx *cli.Context) error {
+							applied, err := migrateDB()
+							if err != nil {
+								return err
+							}
+							for _, m := range applied {
+								logger.Info("applied migration", "version", m.Version, "description", m.Description)
+							}
+							logger.Info("db schema is up to date", "applied", len(applied))
+							return nil
+						},
+					},
+					{
+						Name:  "status",
+						Usage: "list schema migrations and when they were applied",
+						Action: func(cCtx *cli.Context) error {
+							status, err := schemaStatus()
+							out, _ := json.Marshal(status)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+				},
+			},
+			{
+				Name:  "media",
+				Usage: "list media",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "ls",
+						Usage: `list media matching a query eg: --query 'camera:"Apple iPhone 12" after:2023-04 has:gps -exported tag:hiking'`,
+						Flags: []cli.Flag{
+							&cli.StringFlag{Name: "query", Aliases: []string{"q"}, Usage: "media query"},
+							&cli.StringFlag{Name: "sort", Value: string(app.MediaSortDateDesc), Usage: "date_desc, date_asc or imported"},
+							&cli.IntFlag{Name: "limit", Value: 100},
+							&cli.IntFlag{Name: "offset"},
+						},
+						Action: func(cCtx *cli.Context) error {
+							page, err := listMedia(app.MediaQuery{
+								Query:  cCtx.String("query"),
+								Sort:   app.MediaSort(cCtx.String("sort")),
+								Limit:  cCtx.Int("limit"),
+								Offset: cCtx.Int("offset"),
+							})
+							out, _ := json.Marshal(page)
+							fmt.Printf("%s", string(out))
+							return err
+						},
+					},
+				},
 			},
 		},
 	}
@@ -71,3 +457,16 @@ func main() {
 		logger.Error("failed to run cli app", "err", err)
 	}
 }
+
+// parseRetention parses a duration, days can be given as eg: 30d
+func parseRetention(retention string) (time.Duration, error) {
+	if days, isDays := strings.CutSuffix(retention, "d"); isDays {
+		d, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention %s: %w", retention, err)
+		}
+		return time.Duration(d) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(retention)
+}
@@ -3,14 +3,36 @@ package main
 import (
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	appconfig "github.com/j4y_funabashi/inari/apps/api/pkg/app_config"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/webhandler"
 )
 
 func main() {
 	logger := slog.Default()
+	baseDir := filepath.Join(os.TempDir(), "inari")
 	router := webhandler.NewWebHandler()
+	go runThumbnailWorker(appconfig.NewThumbnailWorker(baseDir, logger), logger)
 	port := ":8080"
 	logger.Info("inari web server running on port", "port", port)
 	http.ListenAndServe(port, router)
 }
+
+// runThumbnailWorker processes queued thumbnail jobs every 10 seconds
+func runThumbnailWorker(worker app.ThumbnailWorker, logger *slog.Logger) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		processed, err := worker(now)
+		if err != nil {
+			logger.Error("thumbnail worker failed", "err", err)
+		}
+		if processed > 0 {
+			logger.Info("created thumbnails", "media", processed)
+		}
+	}
+}
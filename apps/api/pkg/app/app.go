@@ -1,15 +1,24 @@
 package app
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/gosimple/slug"
 )
 
 type CollectionType string
@@ -22,11 +31,58 @@ const (
 	CollectionTypePlacesCountry CollectionType = "places_country"
 	CollectionTypePlacesRegion  CollectionType = "places_region"
 	CollectionTypeHashTag       CollectionType = "hashtag"
+	// CollectionTypeSmart has no stored membership, its media is whatever
+	// currently matches the collection's Query
+	CollectionTypeSmart CollectionType = "smart"
+	CollectionTypeAlbum CollectionType = "album"
+)
+
+// TriageState tracks whether media has been looked at since it was imported,
+// new media is in the inbox
+type TriageState string
+
+const (
+	TriageStateNew      TriageState = "new"
+	TriageStateReviewed TriageState = "reviewed"
+	TriageStateArchived TriageState = "archived"
+)
+
+type MediaSort string
+
+const (
+	MediaSortDateDesc MediaSort = "date_desc"
+	MediaSortDateAsc  MediaSort = "date_asc"
+	MediaSortImported MediaSort = "imported"
+)
+
+// BulkOperation is applied to many media at once by a BulkMediaOperation
+type BulkOperation string
+
+const (
+	BulkOperationDelete      BulkOperation = "delete"
+	BulkOperationRestore     BulkOperation = "restore"
+	BulkOperationAddTag      BulkOperation = "add_tag"
+	BulkOperationRemoveTag   BulkOperation = "remove_tag"
+	BulkOperationSetCaption  BulkOperation = "set_caption"
+	BulkOperationExport      BulkOperation = "export"
+	BulkOperationSetLocation BulkOperation = "set_location"
+)
+
+var (
+	// ErrInvalidQuery is returned when a media query can not be parsed
+	ErrInvalidQuery = errors.New("invalid query")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrNotFound     = errors.New("not found")
+	// ErrConflict is returned when a file already exists with different content
+	ErrConflict = errors.New("conflict")
+	// ErrNotSupported is returned when a backend can not do an operation
+	ErrNotSupported = errors.New("not supported")
 )
 
 type App struct {
-	CreateCollection CreateCollection
-	ListCollections  CollectionLister
+	CreateCollection      CreateCollection
+	CreateSmartCollection CreateSmartCollection
+	ListCollections       CollectionLister
 }
 
 type Logger interface {
@@ -51,21 +107,69 @@ type (
 	ExportMedia             = func(mediaID string) error
 	UpdateMediaTextProperty = func(mediaID, caption string) error
 	QueryNearestGPX         = func(cTime time.Time) (GPXPoint, error)
+	SearchMedia             = func(query string) ([]SearchResult, error)
+	MediaLister             = func(query MediaQuery) (MediaPage, error)
 )
 
 type (
 	CollectionLister      = func(collectionType CollectionType) ([]Collection, error)
 	CreateCollection      = func(collectionName string, collectionType CollectionType) (Collection, error)
+	CreateSmartCollection = func(collectionName, query string) (Collection, error)
+	AlbumMediaUpdater     = func(collectionID, mediaID string) error
+	ReorderAlbum          = func(collectionID string, mediaIDs []string) error
+	UpdateAlbum           = func(collectionID string, update AlbumUpdate) (Collection, error)
+	SetTriageState        = func(mediaIDs []string, state TriageState) error
+	NextUnreviewedQuery   = func(collectionID, fromMediaID string) (Media, error)
+	HashtagLister         = func() ([]Collection, error)
+	RenameHashtag         = func(from, to string) (Collection, error)
+	NormaliseHashtags     = func() ([]Collection, error)
+	ImportKeywords        = func() (int, error)
+	BulkMediaOperation    = func(request BulkRequest) (BulkResult, error)
+	TrashLister           = func() ([]Media, error)
+	RestoreMedia          = func(mediaID string) error
+	PurgeMedia            = func(mediaID string) error
+	TrashMediaAt          = func(mediaID string, deletedAt time.Time) error
+	PurgeTrash            = func(olderThan time.Duration) ([]Media, error)
+	Remover               = func(mediaStoreFilename string) error
+	FileOpener            = func(mediaStoreFilename string) (StoredFile, error)
 	CollectionDetailQuery = func(collectionID string) (CollectionDetail, error)
-	Resizer               = func(in, out string) (MediaSrc, error)
+	CollectionPageQuery   = func(collectionID string, page CollectionPage) (CollectionDetail, error)
+	Resizer               = func(in, out string, options ThumbnailOptions) (MediaSrc, error)
+	ThumbnailResizer      = func(in, thumbnailKey string, options ThumbnailOptions) error
+	ThumbnailGenerator    = func(thumbnailKey string) error
+	ThumbnailRegenerator  = func() (int, error)
+	QueueThumbnails       = func(mediaID string) error
+	NextThumbnailJob      = func(now time.Time) (ThumbnailJob, error)
+	RetryThumbnailJob     = func(job ThumbnailJob) error
+	FailThumbnails        = func(mediaID string, reason string) error
+	ThumbnailWorker       = func(now time.Time) (int, error)
+	PlaceholderExtractor  = func(thumbnail io.Reader) (ThumbnailPlaceholder, error)
+	PlaceholderBackfiller = func() (int, error)
+	UpdateFocusPoint      = func(mediaID string, focus *FocusPoint) (MediaSrc, error)
+	EditMedia             = func(mediaID string, edits []Edit) (MediaSrc, error)
+	RevertEdits           = func(mediaID string) (MediaSrc, error)
+	SaveThumbnailOptions  = func(mediaID string, options ThumbnailOptions, thumbnails MediaSrc) error
+	UpdateThumbnails      = func(mediaID string, thumbnails MediaSrc) error
+	SaveJobThumbnails     = func(mediaID string, options ThumbnailOptions, thumbnails MediaSrc) error
+	AllMediaLister        = func() ([]Media, error)
 	Downloader            = func(backupFilename string) (string, error)
+	SourceImporter        = func(sourceURL string) (SourceImportResult, error)
+	ImportSourceOpener    = func(sourceURL string) (source ImportSource, prefix string, err error)
+	ImportedObjectQuery   = func(source, key string) (ImportedObject, error)
+	SaveImportedObject    = func(object ImportedObject) error
+	Fsck                  = func(options FsckOptions) (FsckReport, error)
+	EmptyCollectionLister = func() ([]Collection, error)
+	DeleteCollection      = func(collectionID string) error
+	MembershipLister      = func() ([]CollectionMembership, error)
+	MembershipRemover     = func(membership CollectionMembership) error
+	Reindexer             = func() (ReindexResult, error)
+	SaveSidecar           = func(mediaID string) error
 	Uploader              = func(localFilename, mediaStoreFilename string) error
-	UploaderB             = func(sourceData []byte, mediaStoreFilename, contentType string) error
 	Indexer               = func(media Media) (Media, error)
 	Notifier              = func(mediaMeta Media) error
 	FileLister            = func() ([]string, error)
 	MetadataExtractor     = func(mediaFile string) (MediaMetadata, error)
-	MediaDetailQuery      = func(mediaID string) (MediaDetailView, error)
+	MediaDetailQuery      = func(mediaID string, context MediaContext) (MediaDetailView, error)
 	Geocoder              = func(lat, lng float64, cTime time.Time) (Location, error)
 	LookupTimezone        = func(lat, lng float64, cTime time.Time) (string, error)
 	MediaGeocoder         = func(mediaID string) (Location, error)
@@ -82,7 +186,12 @@ type Media struct {
 	Collections   []Collection `json:"collections,omitempty"`
 	FormattedDate string       `json:"date,omitempty"`
 	Caption       string       `json:"caption,omitempty"`
-	IsExported    bool         `json:"is_exported,omitempty"`
+	ThumbnailOptions
+	ThumbnailsPending bool        `json:"thumbnails_pending,omitempty"`
+	ThumbnailsFailed  string      `json:"thumbnails_failed,omitempty"`
+	IsExported        bool        `json:"is_exported,omitempty"`
+	TriageState       TriageState `json:"triage_state,omitempty"`
+	DeletedAt         *time.Time  `json:"deleted_at,omitempty"`
 }
 
 func (m Media) ToMicroformat() Microformat {
@@ -136,8 +245,69 @@ type Collection struct {
 	ID            string         `json:"id,omitempty"`
 	Title         string         `json:"title,omitempty"`
 	Type          CollectionType `json:"type,omitempty"`
+	Query         string         `json:"query,omitempty"`
+	Description   string         `json:"description,omitempty"`
+	CoverMediaID  string         `json:"cover_media_id,omitempty"`
 	MediaCount    int            `json:"media_count,omitempty"`
 	ExportedCount int            `json:"exported_count,omitempty"`
+	InboxCount    int            `json:"inbox_count,omitempty"`
+}
+
+// SearchResult is a media item matching a full text search, Snippet
+// contains the matching text with matched terms wrapped in <mark> tags
+type SearchResult struct {
+	Media   Media  `json:"media"`
+	Snippet string `json:"snippet"`
+}
+
+// MediaQuery filters, sorts and paginates media, Query uses the
+// media query language eg: camera:"Apple iPhone 12" after:2023-04 has:gps -exported tag:hiking
+// Dates are local to where media was taken, after:2023-04 includes April and
+// before:2023-04 is up to the end of March
+type MediaQuery struct {
+	Query  string
+	Sort   MediaSort
+	Limit  int
+	Offset int
+}
+
+type MediaPage struct {
+	Media  []Media `json:"media"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
+
+// AlbumUpdate changes album properties, nil fields are left unchanged
+type AlbumUpdate struct {
+	Title        *string `json:"title,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	CoverMediaID *string `json:"cover_media_id,omitempty"`
+}
+
+// BulkRequest applies Operation to the media selected by exactly one of
+// MediaIDs, CollectionID or Query, Value is the tag or caption and Location
+// is used by set_location
+type BulkRequest struct {
+	Operation    BulkOperation `json:"operation"`
+	MediaIDs     []string      `json:"media_ids,omitempty"`
+	CollectionID string        `json:"collection_id,omitempty"`
+	Query        string        `json:"query,omitempty"`
+	Value        string        `json:"value,omitempty"`
+	Location     *Location     `json:"location,omitempty"`
+}
+
+// BulkResult reports the outcome for each media, Applied is false when
+// any item failed and the whole operation was rolled back
+type BulkResult struct {
+	Operation BulkOperation    `json:"operation"`
+	Applied   bool             `json:"applied"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+type BulkItemResult struct {
+	MediaID string `json:"media_id"`
+	Error   string `json:"error,omitempty"`
 }
 
 type GPXPoint struct {
@@ -145,28 +315,303 @@ type GPXPoint struct {
 	Location
 }
 
+// CollectionDetail is a collection and a page of its media, NextCursor is
+// empty on the last page. Thumbnails is used instead of Media when only
+// thumbnails were requested
 type CollectionDetail struct {
-	CollectionMeta Collection `json:"collection_meta"`
-	Media          []Media    `json:"media"`
+	CollectionMeta Collection       `json:"collection_meta"`
+	Media          []Media          `json:"media"`
+	Thumbnails     []MediaThumbnail `json:"thumbnails,omitempty"`
+	NextCursor     string           `json:"next_cursor,omitempty"`
+}
+
+// CollectionPage selects a page of a collection's media, Cursor is the
+// NextCursor of the previous page and must be used with the same Sort.
+// An empty Sort is the collection's natural order, albums are in album
+// order and everything else is newest first
+type CollectionPage struct {
+	Cursor         string
+	Limit          int
+	Sort           MediaSort
+	ThumbnailsOnly bool
+}
+
+type MediaThumbnail struct {
+	ID         string   `json:"id"`
+	Date       string   `json:"date"`
+	Thumbnails MediaSrc `json:"thumbnails"`
+}
+
+// MediaContext is the collection a media is being viewed in, it decides
+// the previous and next media. An empty CollectionID has no neighbours
+type MediaContext struct {
+	CollectionID string
+	Sort         MediaSort
 }
 
+// MediaDetailView is a media with its stored metadata, collections, location
+// and export status, PreviousID and NextID are empty at either end of the collection
 type MediaDetailView struct {
-	Media MediaCollectionItem `json:"media"`
+	Media        Media  `json:"media"`
+	CollectionID string `json:"collection_id,omitempty"`
+	PreviousID   string `json:"previous_id,omitempty"`
+	NextID       string `json:"next_id,omitempty"`
+}
+
+// StoredFile is a file opened from a media store, it must be closed
+type StoredFile struct {
+	io.ReadSeekCloser
+	ModTime time.Time
+}
+
+// Store keeps files by key on a local disk or in an S3 compatible bucket,
+// originals, thumbnails and exports are all written through a Store.
+// Get and Stat return ErrNotFound for missing keys, deleting a missing key
+// is not an error and backends that can not presign return ErrNotSupported
+type Store interface {
+	Put(key string, r io.Reader, contentType string) error
+	Get(key string) (StoredFile, error)
+	Stat(key string) (StoredObject, error)
+	List(prefix string) ([]StoredObject, error)
+	Delete(key string) error
+	PresignURL(key string, expires time.Duration) (string, error)
+}
+
+// ImportSource is remote backup storage that media can be imported from,
+// every Store is an ImportSource. List returns every object under prefix
+type ImportSource interface {
+	List(prefix string) ([]StoredObject, error)
+	Get(key string) (StoredFile, error)
+}
+
+// ImportedObject records an object imported from an ImportSource so it is
+// skipped by later imports until it changes
+type ImportedObject struct {
+	Source     string    `json:"source"`
+	Key        string    `json:"key"`
+	ETag       string    `json:"etag,omitempty"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	MediaID    string    `json:"media_id,omitempty"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// Unchanged reports whether object is the same as the one that was
+// imported, objects are compared by ETag when both have one and otherwise
+// by modification time to the second
+func (imported ImportedObject) Unchanged(object StoredObject) bool {
+	if imported.Size != object.Size {
+		return false
+	}
+	if imported.ETag != "" && object.ETag != "" {
+		return imported.ETag == object.ETag
+	}
+	return imported.ModTime.Unix() == object.ModTime.Unix()
+}
+
+// SourceImportResult counts what happened to each listed object
+type SourceImportResult struct {
+	Listed   int `json:"listed"`
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// StoredObject describes a file in a Store, ETag is only set by S3
+type StoredObject struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
 }
 
+// MediaSrc holds the thumbnail keys of a media, Large, Medium and Small are
+// the JPEGs of the lg, sqmd and sqsm profiles. Sizes holds every profile
 type MediaSrc struct {
-	Key    string `json:"key"`
-	Large  string `json:"large"`
-	Medium string `json:"medium"`
-	Small  string `json:"small"`
+	Key    string                   `json:"key"`
+	Large  string                   `json:"large"`
+	Medium string                   `json:"medium"`
+	Small  string                   `json:"small"`
+	Sizes  map[string]ThumbnailSize `json:"sizes,omitempty"`
+	ThumbnailPlaceholder
+}
+
+// ThumbnailPlaceholder is shown while thumbnails load, BlurHash is a
+// https://blurha.sh string and DominantColour a #rrggbb colour
+type ThumbnailPlaceholder struct {
+	BlurHash       string `json:"blurhash,omitempty"`
+	DominantColour string `json:"dominant_colour,omitempty"`
+}
+
+// Keys returns every thumbnail key
+func (src MediaSrc) Keys() []string {
+	out := []string{}
+	seen := map[string]bool{}
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			out = append(out, key)
+		}
+	}
+	for _, key := range []string{src.Small, src.Medium, src.Large} {
+		add(key)
+	}
+	names := []string{}
+	for name := range src.Sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, format := range thumbnailFormats {
+			add(src.Sizes[name].Sources[format])
+		}
+	}
+	return out
+}
+
+// ThumbnailOptions change how thumbnails are created from the original
+type ThumbnailOptions struct {
+	FocusPoint *FocusPoint `json:"focus_point,omitempty"`
+	Edits      []Edit      `json:"edits,omitempty"`
+}
+
+type EditOperation string
+
+const (
+	EditRotate EditOperation = "rotate"
+	EditFlip   EditOperation = "flip"
+	EditCrop   EditOperation = "crop"
+)
+
+// Edit is a single non-destructive change, edits are applied in order to the
+// auto oriented original. Rotate turns Degrees clockwise, flip mirrors in
+// Direction and crop keeps Rect
+type Edit struct {
+	Operation EditOperation `json:"op"`
+	Degrees   int           `json:"degrees,omitempty"`
+	Direction string        `json:"direction,omitempty"`
+	Rect      *EditRect     `json:"rect,omitempty"`
+}
+
+// EditRect is a region of the image, all values are fractions of the width and height
+type EditRect struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (e Edit) Validate() error {
+	switch e.Operation {
+	case EditRotate:
+		if e.Degrees != 90 && e.Degrees != 180 && e.Degrees != 270 {
+			return fmt.Errorf("%w: rotate by 90, 180 or 270 degrees", ErrInvalidInput)
+		}
+	case EditFlip:
+		if e.Direction != "horizontal" && e.Direction != "vertical" {
+			return fmt.Errorf("%w: flip horizontal or vertical", ErrInvalidInput)
+		}
+	case EditCrop:
+		r := e.Rect
+		if r == nil || r.X < 0 || r.Y < 0 || r.Width <= 0 || r.Height <= 0 ||
+			r.X+r.Width > 1+1e-9 || r.Y+r.Height > 1+1e-9 {
+			return fmt.Errorf("%w: crop rect must be inside the image", ErrInvalidInput)
+		}
+	default:
+		return fmt.Errorf("%w: unknown edit %s", ErrInvalidInput, e.Operation)
+	}
+	return nil
+}
+
+// FocusPoint is where square thumbnails are centred, X and Y are fractions of
+// the width and height from the top left. Without one the most detailed
+// region is kept
+type FocusPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+func (f FocusPoint) Validate() error {
+	if f.X < 0 || f.X > 1 || f.Y < 0 || f.Y > 1 {
+		return fmt.Errorf("%w: focus point must be between 0 and 1", ErrInvalidInput)
+	}
+	return nil
+}
+
+// ThumbnailSize is one profile's thumbnail with its actual dimensions, in each format
+type ThumbnailSize struct {
+	Width   int                        `json:"width"`
+	Height  int                        `json:"height"`
+	Sources map[ThumbnailFormat]string `json:"sources"`
+}
+
+type ThumbnailFormat string
+
+const (
+	ThumbnailFormatJPEG ThumbnailFormat = "jpeg"
+	ThumbnailFormatWebP ThumbnailFormat = "webp"
+	ThumbnailFormatAVIF ThumbnailFormat = "avif"
+)
+
+var thumbnailFormats = []ThumbnailFormat{ThumbnailFormatJPEG, ThumbnailFormatWebP, ThumbnailFormatAVIF}
+
+type ThumbnailCrop string
+
+const (
+	// ThumbnailCropFit scales the image to fit inside width x height
+	ThumbnailCropFit ThumbnailCrop = "fit"
+	// ThumbnailCropFill scales and crops the image to exactly width x height,
+	// keeping the focus point or the most detailed region
+	ThumbnailCropFill ThumbnailCrop = "fill"
+)
+
+// ThumbnailProfile is a named thumbnail size, its name prefixes the thumbnail keys
+type ThumbnailProfile struct {
+	Name    string            `json:"name"`
+	Width   int               `json:"width"`
+	Height  int               `json:"height"`
+	Crop    ThumbnailCrop     `json:"crop"`
+	Formats []ThumbnailFormat `json:"formats"`
+	Quality int               `json:"quality"`
+}
+
+// DefaultThumbnailProfiles are the sizes thumbnails have always been created at
+var DefaultThumbnailProfiles = []ThumbnailProfile{
+	{Name: "lg", Width: 1080, Height: 1080, Crop: ThumbnailCropFit, Formats: []ThumbnailFormat{ThumbnailFormatJPEG}, Quality: 95},
+	{Name: "sqmd", Width: 420, Height: 420, Crop: ThumbnailCropFill, Formats: []ThumbnailFormat{ThumbnailFormatJPEG}, Quality: 95},
+	{Name: "sqsm", Width: 92, Height: 92, Crop: ThumbnailCropFill, Formats: []ThumbnailFormat{ThumbnailFormatJPEG}, Quality: 95},
 }
 
-type MediaCollectionItem struct {
-	ID       string   `json:"id"`
-	Date     string   `json:"date"`
-	MediaSrc MediaSrc `json:"media_src"`
-	Caption  string   `json:"caption"`
-	MediaMetadata
+// ValidateThumbnailProfiles checks profiles can be used to name and create thumbnails
+func ValidateThumbnailProfiles(profiles []ThumbnailProfile) error {
+	if len(profiles) == 0 {
+		return fmt.Errorf("%w: no thumbnail profiles", ErrInvalidInput)
+	}
+	seen := map[string]bool{}
+	for _, p := range profiles {
+		if p.Name == "" || strings.ContainsAny(p.Name, "_./") || seen[p.Name] {
+			return fmt.Errorf("%w: thumbnail profile name %q must be unique and not contain _ . or /", ErrInvalidInput, p.Name)
+		}
+		seen[p.Name] = true
+		if p.Width <= 0 || p.Height <= 0 {
+			return fmt.Errorf("%w: thumbnail profile %s needs a width and height", ErrInvalidInput, p.Name)
+		}
+		if p.Crop != ThumbnailCropFit && p.Crop != ThumbnailCropFill {
+			return fmt.Errorf("%w: thumbnail profile %s has unknown crop %q", ErrInvalidInput, p.Name, p.Crop)
+		}
+		if len(p.Formats) == 0 {
+			return fmt.Errorf("%w: thumbnail profile %s has no formats", ErrInvalidInput, p.Name)
+		}
+		for _, format := range p.Formats {
+			if !slices.Contains(thumbnailFormats, format) {
+				return fmt.Errorf("%w: thumbnail profile %s has unknown format %q", ErrInvalidInput, p.Name, format)
+			}
+		}
+		if p.Quality < 0 || p.Quality > 100 {
+			return fmt.Errorf("%w: thumbnail profile %s quality must be 0-100", ErrInvalidInput, p.Name)
+		}
+	}
+	return nil
 }
 
 type Coordinates struct {
@@ -196,9 +641,73 @@ type MediaMetadata struct {
 	CameraMake  string      `json:"camera_make"`
 	CameraModel string      `json:"camera_model"`
 	Keywords    string      `json:"keywords"`
+	KeywordList []string    `json:"keyword_list,omitempty"`
 	Title       string      `json:"title"`
 }
 
+// KeywordRules control which embedded keywords become hashtags, keywords are
+// matched case insensitively against either the full keyword or the last part
+// of a hierarchical keyword eg: "Places|Japan|Tokyo" matches "places|japan|tokyo"
+// or "tokyo". Map renames a keyword, mapping to "" or listing it in Ignore drops it
+type KeywordRules struct {
+	Map    map[string]string `json:"map"`
+	Ignore []string          `json:"ignore"`
+}
+
+// Hashtags applies the rules to keywords, hierarchical keywords become
+// their last part unless mapped
+func (r KeywordRules) Hashtags(keywords []string) []string {
+	ignore := map[string]bool{}
+	for _, k := range r.Ignore {
+		ignore[strings.ToLower(strings.TrimSpace(k))] = true
+	}
+	mapping := map[string]string{}
+	for k, v := range r.Map {
+		mapping[strings.ToLower(strings.TrimSpace(k))] = v
+	}
+
+	out := []string{}
+	seen := map[string]bool{}
+	for _, keyword := range keywords {
+		full := strings.ToLower(strings.TrimSpace(keyword))
+		parts := strings.Split(full, "|")
+		leaf := strings.TrimSpace(parts[len(parts)-1])
+		if ignore[full] || ignore[leaf] {
+			continue
+		}
+
+		tag := leaf
+		if mapped, ok := mapping[full]; ok {
+			tag = mapped
+		} else if mapped, ok := mapping[leaf]; ok {
+			tag = mapped
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+
+	return out
+}
+
+// ParsedKeywords returns KeywordList, media imported before keywords were
+// extracted as a list only has the comma separated Keywords string
+func (mm MediaMetadata) ParsedKeywords() []string {
+	if len(mm.KeywordList) > 0 {
+		return mm.KeywordList
+	}
+	out := []string{}
+	for _, k := range strings.Split(mm.Keywords, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
 // file extensions inari will import
 var mediaExtensions = map[string]bool{
 	".jpg": true,
@@ -208,13 +717,79 @@ var mediaExtensions = map[string]bool{
 }
 
 func (mm MediaMetadata) NewFilename() string {
-	return fmt.Sprintf(
-		"%s/%s_%s.%s",
-		mm.Date.Format("2006"),
-		mm.Date.Format("20060102_150405"),
-		mm.Hash,
-		mm.Ext,
-	)
+	return mm.StorePath(DefaultStoreLayout)
+}
+
+// StoreLayout is a template for the path of media in the media store,
+// {year}, {month}, {day}, {date} (YYYYMMDD), {time} (HHMMSS), {camera},
+// {hash} and {ext} are replaced with the media's values
+type StoreLayout string
+
+const DefaultStoreLayout StoreLayout = "{year}/{date}_{time}_{hash}.{ext}"
+
+// StoreConfig configures the media store, it is read from store.json.
+// Without S3 originals and thumbnails are kept on the local disk
+type StoreConfig struct {
+	Layout StoreLayout    `json:"layout"`
+	S3     *S3StoreConfig `json:"s3,omitempty"`
+}
+
+// S3StoreConfig keeps originals and thumbnails in Bucket, Endpoint and
+// PathStyle are for S3 compatible services such as MinIO
+type S3StoreConfig struct {
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	PathStyle bool   `json:"path_style,omitempty"`
+}
+
+var storeLayoutPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// Validate checks every placeholder is known and that filenames end with
+// {hash}.{ext}, after a / or _, so the hash can be read back from thumbnail keys
+func (l StoreLayout) Validate() error {
+	layout := string(l)
+	if strings.HasPrefix(layout, "/") || slices.Contains(strings.Split(layout, "/"), "..") {
+		return fmt.Errorf("%w: store layout %q must be a relative path", ErrInvalidInput, layout)
+	}
+	for _, placeholder := range storeLayoutPlaceholder.FindAllString(layout, -1) {
+		if _, ok := storeLayoutValues[placeholder]; !ok {
+			return fmt.Errorf("%w: store layout %q has unknown placeholder %s", ErrInvalidInput, layout, placeholder)
+		}
+	}
+	filename := layout[strings.LastIndex(layout, "/")+1:]
+	if filename != "{hash}.{ext}" && !strings.HasSuffix(filename, "_{hash}.{ext}") {
+		return fmt.Errorf("%w: store layout %q must end with {hash}.{ext}", ErrInvalidInput, layout)
+	}
+	return nil
+}
+
+var storeLayoutValues = map[string]func(mm MediaMetadata) string{
+	"{year}":  func(mm MediaMetadata) string { return mm.Date.Format("2006") },
+	"{month}": func(mm MediaMetadata) string { return mm.Date.Format("01") },
+	"{day}":   func(mm MediaMetadata) string { return mm.Date.Format("02") },
+	"{date}":  func(mm MediaMetadata) string { return mm.Date.Format("20060102") },
+	"{time}":  func(mm MediaMetadata) string { return mm.Date.Format("150405") },
+	"{hash}":  func(mm MediaMetadata) string { return mm.Hash },
+	"{ext}":   func(mm MediaMetadata) string { return mm.Ext },
+	"{camera}": func(mm MediaMetadata) string {
+		camera := slug.Make(mm.CameraMake + " " + mm.CameraModel)
+		if camera == "" {
+			return "unknown-camera"
+		}
+		return camera
+	},
+}
+
+// StorePath is the path of the media in a media store using layout
+func (mm MediaMetadata) StorePath(layout StoreLayout) string {
+	return storeLayoutPlaceholder.ReplaceAllStringFunc(string(layout), func(placeholder string) string {
+		value, ok := storeLayoutValues[placeholder]
+		if !ok {
+			return placeholder
+		}
+		return value(mm)
+	})
 }
 
 func (mm MediaMetadata) ThumbnailKey() string {
@@ -281,14 +856,141 @@ func ImportDir(importFile Importer, logger Logger) func(backupFilename string) e
 	}
 }
 
+type SourceImporterConfig struct {
+	OpenSource       ImportSourceOpener
+	FetchMediaDetail QueryMediaDetail
+	FetchImported    ImportedObjectQuery
+	SaveImported     SaveImportedObject
+	ImportFile       Importer
+	Logger           Logger
+}
+
+// md5ETag matches S3 ETags of objects uploaded in a single part, these are
+// the md5 of the content and so the hash of the media
+var md5ETag = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// NewSourceImporter imports every media object listed by a remote source
+// such as s3://bucket/prefix/. Objects imported before are skipped unless
+// they have changed, as are objects whose ETag is the hash of indexed media.
+// Each object is streamed to a temp file and imported with ImportFile,
+// failures are logged and counted and do not stop the import
+func NewSourceImporter(config SourceImporterConfig) SourceImporter {
+	return func(sourceURL string) (SourceImportResult, error) {
+		result := SourceImportResult{}
+		source, prefix, err := config.OpenSource(sourceURL)
+		if err != nil {
+			return result, fmt.Errorf("failed to open import source: %w", err)
+		}
+		objects, err := source.List(prefix)
+		if err != nil {
+			return result, fmt.Errorf("failed to list import source: %w", err)
+		}
+		config.Logger.Info("importing objects",
+			"source", sourceURL,
+			"count", len(objects),
+		)
+
+		for _, object := range objects {
+			if _, extValid := mediaExtensions[strings.ToLower(path.Ext(object.Key))]; !extValid {
+				continue
+			}
+			result.Listed++
+
+			imported, err := importSourceObject(config, sourceURL, source, object)
+			if err != nil {
+				result.Failed++
+				config.Logger.Error("failed to import object",
+					"err", err,
+					"source", sourceURL,
+					"key", object.Key)
+				continue
+			}
+			if imported {
+				result.Imported++
+			} else {
+				result.Skipped++
+			}
+		}
+
+		return result, nil
+	}
+}
+
+// importSourceObject returns false when the object was skipped
+func importSourceObject(config SourceImporterConfig, sourceURL string, source ImportSource, object StoredObject) (bool, error) {
+	previous, err := config.FetchImported(sourceURL, object.Key)
+	if err == nil && previous.Unchanged(object) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+
+	record := ImportedObject{
+		Source:  sourceURL,
+		Key:     object.Key,
+		ETag:    object.ETag,
+		Size:    object.Size,
+		ModTime: object.ModTime,
+	}
+	if md5ETag.MatchString(object.ETag) {
+		existingMedia, _ := config.FetchMediaDetail(object.ETag)
+		if existingMedia.Hash == object.ETag {
+			record.MediaID = existingMedia.ID
+			record.ImportedAt = time.Now().UTC()
+			return false, config.SaveImported(record)
+		}
+	}
+
+	tmpFilename, err := downloadSourceObject(source, object.Key)
+	if err != nil {
+		return false, fmt.Errorf("failed to download object: %w", err)
+	}
+	defer os.Remove(tmpFilename)
+
+	media, err := config.ImportFile(tmpFilename)
+	if err != nil {
+		return false, err
+	}
+	record.MediaID = media.ID
+	record.ImportedAt = time.Now().UTC()
+
+	return true, config.SaveImported(record)
+}
+
+// downloadSourceObject copies an object to a temp file with the same extension
+func downloadSourceObject(source ImportSource, key string) (string, error) {
+	src, err := source.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "inari-import-*"+strings.ToLower(path.Ext(key)))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if cErr := dst.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+
+	return dst.Name(), nil
+}
+
 type MediaImporterConfig struct {
 	FetchMediaDetail   QueryMediaDetail
 	Logger             Logger
 	DownloadFromBackup Downloader
 	ExtractMetadata    MetadataExtractor
 	UploadToMediaStore Uploader
+	StoreLayout        StoreLayout
 	IndexMedia         Indexer
-	CreateThumbnails   Resizer
+	QueueThumbnails    QueueThumbnails
 	Geocode            Geocoder
 	NotifyDownstream   Notifier
 }
@@ -329,18 +1031,12 @@ func NewImporter(config MediaImporterConfig) Importer {
 		media.Caption = mediaMeta.Title
 
 		// upload renamed file to media storage
-		err = config.UploadToMediaStore(tmpFilename, media.NewFilename())
+		storePath := media.StorePath(config.StoreLayout)
+		err = config.UploadToMediaStore(tmpFilename, storePath)
 		if err != nil {
 			return media, fmt.Errorf("failed to upload to media store: %w", err)
 		}
-		media.FilePath = media.NewFilename()
-
-		// create thumbnails
-		thumbnails, err := config.CreateThumbnails(tmpFilename, media.NewFilename())
-		if err != nil {
-			return media, fmt.Errorf("failed to create thumbnails: %w", err)
-		}
-		media.Thumbnails = thumbnails
+		media.FilePath = storePath
 
 		// geocode
 		loc, err := config.Geocode(media.Coordinates.Lat, media.Coordinates.Lng, media.Date)
@@ -349,12 +1045,19 @@ func NewImporter(config MediaImporterConfig) Importer {
 		}
 		media.Location = loc
 
-		// index metadata in datastore
+		// index metadata in datastore, thumbnails are created later by the thumbnail worker
+		media.ThumbnailsPending = true
 		media, err = config.IndexMedia(media)
 		if err != nil {
 			return media, fmt.Errorf("failed to index media metadata: %w", err)
 		}
 
+		// queue thumbnails
+		err = config.QueueThumbnails(media.ID)
+		if err != nil {
+			return media, fmt.Errorf("failed to queue thumbnails: %w", err)
+		}
+
 		// add to queue
 		err = config.NotifyDownstream(media)
 		if err != nil {
@@ -373,6 +1076,985 @@ func NewImporter(config MediaImporterConfig) Importer {
 	}
 }
 
+type ThumbnailGeneratorConfig struct {
+	FetchMediaDetail QueryMediaDetail
+	DownloadOriginal Downloader
+	CreateThumbnail  ThumbnailResizer
+}
+
+// NewThumbnailGenerator recreates a missing thumbnail from its original, the
+// media is found by the hash in the thumbnail key
+func NewThumbnailGenerator(config ThumbnailGeneratorConfig) ThumbnailGenerator {
+	return func(thumbnailKey string) error {
+		media, err := config.FetchMediaDetail(thumbnailKeyHash(thumbnailKey))
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(media.Thumbnails.Keys(), thumbnailKey) {
+			return fmt.Errorf("%w: thumbnail %s", ErrNotFound, thumbnailKey)
+		}
+
+		tmpFilename, err := config.DownloadOriginal(media.FilePath)
+		if err != nil {
+			return fmt.Errorf("failed to download original: %w", err)
+		}
+		defer os.Remove(tmpFilename)
+
+		return config.CreateThumbnail(tmpFilename, thumbnailKey, media.ThumbnailOptions)
+	}
+}
+
+// thumbnailKeyHash returns the media hash of a thumbnail key, keys end with
+// _<hash>.<ext> or _<hash>-<options>.<ext>
+func thumbnailKeyHash(thumbnailKey string) string {
+	ext := path.Ext(thumbnailKey)
+	hash := strings.TrimSuffix(thumbnailKey[strings.LastIndex(thumbnailKey, "_")+1:], ext)
+	hash, _, _ = strings.Cut(hash, "-")
+	return hash
+}
+
+type ThumbnailRegeneratorConfig struct {
+	ListMedia        AllMediaLister
+	DownloadOriginal Downloader
+	CreateThumbnails Resizer
+	SaveThumbnails   UpdateThumbnails
+	Logger           Logger
+}
+
+// NewThumbnailRegenerator recreates the thumbnails of all media with the current
+// profiles, media that can not be resized are logged and skipped
+func NewThumbnailRegenerator(config ThumbnailRegeneratorConfig) ThumbnailRegenerator {
+	return func() (int, error) {
+		regenerated := 0
+		allMedia, err := config.ListMedia()
+		if err != nil {
+			return regenerated, fmt.Errorf("failed to list media: %w", err)
+		}
+
+		for _, media := range allMedia {
+			thumbnails, err := regenerateThumbnails(config, media)
+			if err != nil {
+				config.Logger.Error("failed to regenerate thumbnails",
+					"err", err,
+					"mediaID", media.ID)
+				continue
+			}
+			err = config.SaveThumbnails(media.ID, thumbnails)
+			if err != nil {
+				return regenerated, fmt.Errorf("failed to save thumbnails of %s: %w", media.ID, err)
+			}
+			regenerated++
+		}
+
+		return regenerated, nil
+	}
+}
+
+func regenerateThumbnails(config ThumbnailRegeneratorConfig, media Media) (MediaSrc, error) {
+	tmpFilename, err := config.DownloadOriginal(media.FilePath)
+	if err != nil {
+		return MediaSrc{}, fmt.Errorf("failed to download original: %w", err)
+	}
+	defer os.Remove(tmpFilename)
+
+	return config.CreateThumbnails(tmpFilename, media.FilePath, media.ThumbnailOptions)
+}
+
+type PlaceholderBackfillerConfig struct {
+	ListMedia          AllMediaLister
+	OpenThumbnail      FileOpener
+	ExtractPlaceholder PlaceholderExtractor
+	SaveThumbnails     UpdateThumbnails
+	Logger             Logger
+}
+
+// NewPlaceholderBackfiller computes the placeholder of media imported before
+// placeholders existed from their large thumbnail, media that already has a
+// placeholder or is waiting for thumbnails is skipped
+func NewPlaceholderBackfiller(config PlaceholderBackfillerConfig) PlaceholderBackfiller {
+	return func() (int, error) {
+		backfilled := 0
+		allMedia, err := config.ListMedia()
+		if err != nil {
+			return backfilled, fmt.Errorf("failed to list media: %w", err)
+		}
+
+		for _, media := range allMedia {
+			if media.Thumbnails.BlurHash != "" || media.Thumbnails.Large == "" {
+				continue
+			}
+			placeholder, err := extractPlaceholder(config, media.Thumbnails.Large)
+			if err != nil {
+				config.Logger.Error("failed to compute placeholder",
+					"err", err,
+					"mediaID", media.ID)
+				continue
+			}
+			media.Thumbnails.ThumbnailPlaceholder = placeholder
+			err = config.SaveThumbnails(media.ID, media.Thumbnails)
+			if err != nil {
+				return backfilled, fmt.Errorf("failed to save placeholder of %s: %w", media.ID, err)
+			}
+			backfilled++
+		}
+
+		return backfilled, nil
+	}
+}
+
+func extractPlaceholder(config PlaceholderBackfillerConfig, thumbnailKey string) (ThumbnailPlaceholder, error) {
+	thumbnail, err := config.OpenThumbnail(thumbnailKey)
+	if err != nil {
+		return ThumbnailPlaceholder{}, err
+	}
+	defer thumbnail.Close()
+
+	return config.ExtractPlaceholder(thumbnail)
+}
+
+// ThumbnailJobStatus tracks a queued thumbnail job, jobs that fail too many
+// times are marked failed and are no longer retried
+type ThumbnailJobStatus string
+
+const (
+	ThumbnailJobPending ThumbnailJobStatus = "pending"
+	ThumbnailJobFailed  ThumbnailJobStatus = "failed"
+)
+
+// MaxThumbnailJobAttempts is how many times a thumbnail job is tried before it is failed
+const MaxThumbnailJobAttempts = 8
+
+type ThumbnailJob struct {
+	MediaID       string             `json:"media_id"`
+	Status        ThumbnailJobStatus `json:"status"`
+	Attempts      int                `json:"attempts"`
+	NextAttemptAt time.Time          `json:"next_attempt_at"`
+	LastError     string             `json:"last_error,omitempty"`
+}
+
+// ThumbnailJobBackoff is how long to wait before retrying a job that has
+// failed attempts times, it doubles from 30 seconds up to an hour
+func ThumbnailJobBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < time.Hour; i++ {
+		backoff *= 2
+	}
+	return min(backoff, time.Hour)
+}
+
+type ThumbnailWorkerConfig struct {
+	NextJob          NextThumbnailJob
+	FetchMediaDetail QueryMediaDetail
+	DownloadOriginal Downloader
+	CreateThumbnails Resizer
+	SaveThumbnails   SaveJobThumbnails
+	RetryJob         RetryThumbnailJob
+	FailThumbnails   FailThumbnails
+	Logger           Logger
+}
+
+// NewThumbnailWorker creates thumbnails for every queued job that is due at
+// now. Saving the thumbnails completes the job, failed jobs are retried later
+// until MaxThumbnailJobAttempts when the media is marked as failed. When the
+// thumbnail options of a media change while its thumbnails are created they
+// are not saved, the job is tried again if it is still queued
+func NewThumbnailWorker(config ThumbnailWorkerConfig) ThumbnailWorker {
+	return func(now time.Time) (int, error) {
+		processed := 0
+		for {
+			job, err := config.NextJob(now)
+			if errors.Is(err, ErrNotFound) {
+				return processed, nil
+			}
+			if err != nil {
+				return processed, fmt.Errorf("failed to fetch thumbnail job: %w", err)
+			}
+
+			err = processThumbnailJob(config, job)
+			if errors.Is(err, ErrConflict) {
+				config.Logger.Info("skipped stale thumbnails",
+					"err", err,
+					"mediaID", job.MediaID)
+				continue
+			}
+			if err != nil {
+				job.Attempts++
+				job.LastError = err.Error()
+				job.NextAttemptAt = now.Add(ThumbnailJobBackoff(job.Attempts))
+				if job.Attempts >= MaxThumbnailJobAttempts {
+					job.Status = ThumbnailJobFailed
+				}
+				config.Logger.Error("failed to create thumbnails",
+					"err", err,
+					"mediaID", job.MediaID,
+					"attempts", job.Attempts,
+					"status", job.Status)
+				err = config.RetryJob(job)
+				if err != nil {
+					return processed, fmt.Errorf("failed to save thumbnail job %s: %w", job.MediaID, err)
+				}
+				if job.Status == ThumbnailJobFailed {
+					err = config.FailThumbnails(job.MediaID, job.LastError)
+					if err != nil {
+						return processed, fmt.Errorf("failed to mark thumbnails of %s as failed: %w", job.MediaID, err)
+					}
+				}
+				continue
+			}
+			processed++
+		}
+	}
+}
+
+func processThumbnailJob(config ThumbnailWorkerConfig, job ThumbnailJob) error {
+	media, err := config.FetchMediaDetail(job.MediaID)
+	if err != nil {
+		return err
+	}
+	thumbnails, err := regenerateThumbnails(ThumbnailRegeneratorConfig{
+		DownloadOriginal: config.DownloadOriginal,
+		CreateThumbnails: config.CreateThumbnails,
+	}, media)
+	if err != nil {
+		return err
+	}
+	return config.SaveThumbnails(media.ID, media.ThumbnailOptions, thumbnails)
+}
+
+type ThumbnailOptionsUpdaterConfig struct {
+	FetchMediaDetail     QueryMediaDetail
+	DownloadOriginal     Downloader
+	CreateThumbnails     Resizer
+	SaveThumbnailOptions SaveThumbnailOptions
+	RemoveThumbnail      Remover
+}
+
+// NewFocusPointUpdater sets or, when focus is nil, clears the focus point of
+// a media and recreates its thumbnails
+func NewFocusPointUpdater(config ThumbnailOptionsUpdaterConfig) UpdateFocusPoint {
+	return func(mediaID string, focus *FocusPoint) (MediaSrc, error) {
+		if focus != nil {
+			err := focus.Validate()
+			if err != nil {
+				return MediaSrc{}, err
+			}
+		}
+		return updateThumbnailOptions(config, mediaID, func(options *ThumbnailOptions) {
+			options.FocusPoint = focus
+		})
+	}
+}
+
+// NewMediaEditor adds edits to the end of a media's edit list and recreates
+// its thumbnails, the original is never changed
+func NewMediaEditor(config ThumbnailOptionsUpdaterConfig) EditMedia {
+	return func(mediaID string, edits []Edit) (MediaSrc, error) {
+		if len(edits) == 0 {
+			return MediaSrc{}, fmt.Errorf("%w: no edits", ErrInvalidInput)
+		}
+		for _, edit := range edits {
+			err := edit.Validate()
+			if err != nil {
+				return MediaSrc{}, err
+			}
+		}
+		return updateThumbnailOptions(config, mediaID, func(options *ThumbnailOptions) {
+			options.Edits = append(options.Edits, edits...)
+		})
+	}
+}
+
+// NewEditReverter removes all edits of a media, its thumbnails are recreated
+// from the original as it was imported
+func NewEditReverter(config ThumbnailOptionsUpdaterConfig) RevertEdits {
+	return func(mediaID string) (MediaSrc, error) {
+		return updateThumbnailOptions(config, mediaID, func(options *ThumbnailOptions) {
+			options.Edits = nil
+		})
+	}
+}
+
+// updateThumbnailOptions recreates a media's thumbnails with updated options.
+// Changed thumbnails get new keys so cached copies are not reused, the
+// replaced thumbnails are removed
+func updateThumbnailOptions(config ThumbnailOptionsUpdaterConfig, mediaID string, update func(options *ThumbnailOptions)) (MediaSrc, error) {
+	media, err := config.FetchMediaDetail(mediaID)
+	if err != nil {
+		return MediaSrc{}, err
+	}
+	update(&media.ThumbnailOptions)
+
+	thumbnails, err := regenerateThumbnails(ThumbnailRegeneratorConfig{
+		DownloadOriginal: config.DownloadOriginal,
+		CreateThumbnails: config.CreateThumbnails,
+	}, media)
+	if err != nil {
+		return MediaSrc{}, fmt.Errorf("failed to create thumbnails: %w", err)
+	}
+	err = config.SaveThumbnailOptions(mediaID, media.ThumbnailOptions, thumbnails)
+	if err != nil {
+		return MediaSrc{}, err
+	}
+
+	for _, key := range media.Thumbnails.Keys() {
+		if slices.Contains(thumbnails.Keys(), key) {
+			continue
+		}
+		err = config.RemoveThumbnail(key)
+		if err != nil {
+			return thumbnails, fmt.Errorf("failed to remove thumbnail %s: %w", key, err)
+		}
+	}
+
+	return thumbnails, nil
+}
+
+type TrashPurgerConfig struct {
+	ListTrash       TrashLister
+	RemoveOriginal  Remover
+	RemoveSidecar   Remover
+	RemoveThumbnail Remover
+	PurgeMedia      PurgeMedia
+	Logger          Logger
+}
+
+// NewTrashPurger permanently removes media deleted more than olderThan ago,
+// files are removed before the index so a failed purge can be retried. Media
+// without a sidecar is purged as usual
+func NewTrashPurger(config TrashPurgerConfig) PurgeTrash {
+	return func(olderThan time.Duration) ([]Media, error) {
+		purged := []Media{}
+		cutoff := time.Now().Add(-olderThan)
+
+		trash, err := config.ListTrash()
+		if err != nil {
+			return purged, fmt.Errorf("failed to list trash: %w", err)
+		}
+
+		for _, media := range trash {
+			if media.DeletedAt == nil || media.DeletedAt.After(cutoff) {
+				continue
+			}
+
+			if media.FilePath != "" {
+				err = config.RemoveOriginal(media.FilePath)
+				if err != nil {
+					return purged, fmt.Errorf("failed to remove original %s: %w", media.FilePath, err)
+				}
+				err = config.RemoveSidecar(media.FilePath + SidecarExt)
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					return purged, fmt.Errorf("failed to remove sidecar of %s: %w", media.FilePath, err)
+				}
+			}
+			for _, thumbnail := range media.Thumbnails.Keys() {
+				err = config.RemoveThumbnail(thumbnail)
+				if err != nil {
+					return purged, fmt.Errorf("failed to remove thumbnail %s: %w", thumbnail, err)
+				}
+			}
+
+			err = config.PurgeMedia(media.ID)
+			if err != nil {
+				return purged, fmt.Errorf("failed to purge media %s: %w", media.ID, err)
+			}
+
+			config.Logger.Info("purged media",
+				"mediaID", media.ID,
+				"deletedAt", media.DeletedAt)
+			purged = append(purged, media)
+		}
+
+		return purged, nil
+	}
+}
+
+// CollectionMembership is a media_collection row
+type CollectionMembership struct {
+	CollectionID string `json:"collection_id"`
+	MediaID      string `json:"media_id"`
+}
+
+type FsckIssueType string
+
+const (
+	FsckMissingOriginal  FsckIssueType = "missing_original"
+	FsckHashMismatch     FsckIssueType = "hash_mismatch"
+	FsckMissingThumbnail FsckIssueType = "missing_thumbnail"
+	FsckFailedThumbnail  FsckIssueType = "failed_thumbnail"
+	FsckOrphanOriginal   FsckIssueType = "orphan_original"
+	FsckOrphanThumbnail  FsckIssueType = "orphan_thumbnail"
+	FsckDanglingMember   FsckIssueType = "dangling_membership"
+	FsckEmptyCollection  FsckIssueType = "empty_collection"
+)
+
+// FsckIssue is a disagreement between the index, the media store and the
+// thumbnail store, Repaired is set when fsck fixed it
+type FsckIssue struct {
+	Type         FsckIssueType `json:"type"`
+	MediaID      string        `json:"media_id,omitempty"`
+	CollectionID string        `json:"collection_id,omitempty"`
+	Key          string        `json:"key,omitempty"`
+	Detail       string        `json:"detail,omitempty"`
+	Repaired     bool          `json:"repaired,omitempty"`
+}
+
+// FsckOptions, Quick skips re-hashing originals and Repair fixes what it can
+type FsckOptions struct {
+	Repair bool
+	Quick  bool
+}
+
+type FsckReport struct {
+	Media      int         `json:"media"`
+	Originals  int         `json:"originals"`
+	Thumbnails int         `json:"thumbnails"`
+	Issues     []FsckIssue `json:"issues"`
+}
+
+// Unrepaired counts the issues that still need attention
+func (r FsckReport) Unrepaired() int {
+	count := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			count++
+		}
+	}
+	return count
+}
+
+type FsckConfig struct {
+	ListMedia               AllMediaLister
+	ListTrash               TrashLister
+	MediaStore              Store
+	ThumbnailStore          Store
+	DownloadOriginal        Downloader
+	ImportFile              Importer
+	QueueThumbnails         QueueThumbnails
+	TrashMedia              DeleteMedia
+	ListDanglingMemberships MembershipLister
+	RemoveMembership        MembershipRemover
+	ListEmptyCollections    EmptyCollectionLister
+	DeleteCollection        DeleteCollection
+	Logger                  Logger
+}
+
+// NewFsck checks the index, media store and thumbnail store agree. Repairs
+// never lose data that can not be recreated: media whose original is missing
+// is moved to the trash, missing thumbnails are queued, orphaned originals
+// are imported, orphaned thumbnails and dangling memberships are removed and
+// collections are dropped once nothing, not even trashed media, is in them
+func NewFsck(config FsckConfig) Fsck {
+	return func(options FsckOptions) (FsckReport, error) {
+		report := FsckReport{Issues: []FsckIssue{}}
+		addIssue := func(issue FsckIssue) {
+			config.Logger.Info("fsck issue",
+				"type", issue.Type,
+				"mediaID", issue.MediaID,
+				"collectionID", issue.CollectionID,
+				"key", issue.Key,
+				"repaired", issue.Repaired)
+			report.Issues = append(report.Issues, issue)
+		}
+
+		live, err := config.ListMedia()
+		if err != nil {
+			return report, fmt.Errorf("failed to list media: %w", err)
+		}
+		trash, err := config.ListTrash()
+		if err != nil {
+			return report, fmt.Errorf("failed to list trash: %w", err)
+		}
+		report.Media = len(live) + len(trash)
+
+		originals := map[string]bool{}
+		thumbnails := map[string]bool{}
+		pending := map[string]bool{}
+		for _, media := range append(slices.Clone(live), trash...) {
+			originals[media.FilePath] = true
+			for _, key := range media.Thumbnails.Keys() {
+				thumbnails[key] = true
+			}
+			if media.ThumbnailsPending {
+				pending[media.Hash] = true
+			}
+		}
+
+		for _, media := range live {
+			issues, err := fsckOriginal(config, options, media)
+			if err != nil {
+				return report, err
+			}
+			for _, issue := range issues {
+				addIssue(issue)
+			}
+			// thumbnails can not be recreated without the original
+			if slices.ContainsFunc(issues, func(issue FsckIssue) bool { return issue.Type == FsckMissingOriginal }) {
+				continue
+			}
+			issues, err = fsckThumbnails(config, options, media)
+			if err != nil {
+				return report, err
+			}
+			for _, issue := range issues {
+				addIssue(issue)
+			}
+		}
+
+		// orphaned files
+		storedOriginals, err := config.MediaStore.List("")
+		if err != nil {
+			return report, fmt.Errorf("failed to list media store: %w", err)
+		}
+		for _, object := range storedOriginals {
+			// sidecars and other files kept alongside originals are not media
+			if _, extValid := mediaExtensions[strings.ToLower(path.Ext(object.Key))]; !extValid {
+				continue
+			}
+			report.Originals++
+			if originals[object.Key] {
+				continue
+			}
+			issue := FsckIssue{Type: FsckOrphanOriginal, Key: object.Key}
+			if options.Repair {
+				issue.MediaID, issue.Repaired, err = reindexOrphan(config, object.Key)
+				if err != nil {
+					issue.Detail = err.Error()
+				}
+			}
+			addIssue(issue)
+		}
+
+		storedThumbnails, err := config.ThumbnailStore.List("")
+		if err != nil {
+			return report, fmt.Errorf("failed to list thumbnail store: %w", err)
+		}
+		report.Thumbnails = len(storedThumbnails)
+		for _, object := range storedThumbnails {
+			// thumbnails of pending media may be saved at any moment
+			if thumbnails[object.Key] || pending[thumbnailKeyHash(object.Key)] {
+				continue
+			}
+			issue := FsckIssue{Type: FsckOrphanThumbnail, Key: object.Key}
+			if options.Repair {
+				err = config.ThumbnailStore.Delete(object.Key)
+				if err != nil {
+					return report, fmt.Errorf("failed to remove thumbnail %s: %w", object.Key, err)
+				}
+				issue.Repaired = true
+			}
+			addIssue(issue)
+		}
+
+		// dangling rows
+		memberships, err := config.ListDanglingMemberships()
+		if err != nil {
+			return report, fmt.Errorf("failed to list dangling memberships: %w", err)
+		}
+		for _, membership := range memberships {
+			issue := FsckIssue{Type: FsckDanglingMember, MediaID: membership.MediaID, CollectionID: membership.CollectionID}
+			if options.Repair {
+				err = config.RemoveMembership(membership)
+				if err != nil {
+					return report, fmt.Errorf("failed to remove membership: %w", err)
+				}
+				issue.Repaired = true
+			}
+			addIssue(issue)
+		}
+
+		collections, err := config.ListEmptyCollections()
+		if err != nil {
+			return report, fmt.Errorf("failed to list empty collections: %w", err)
+		}
+		for _, c := range collections {
+			issue := FsckIssue{Type: FsckEmptyCollection, CollectionID: c.ID}
+			if c.MediaCount > 0 {
+				issue.Detail = fmt.Sprintf("%d trashed media", c.MediaCount)
+			}
+			if options.Repair && c.MediaCount == 0 {
+				err = config.DeleteCollection(c.ID)
+				if err != nil {
+					return report, fmt.Errorf("failed to delete collection %s: %w", c.ID, err)
+				}
+				issue.Repaired = true
+			}
+			addIssue(issue)
+		}
+
+		return report, nil
+	}
+}
+
+func fsckOriginal(config FsckConfig, options FsckOptions, media Media) ([]FsckIssue, error) {
+	_, err := config.MediaStore.Stat(media.FilePath)
+	if errors.Is(err, ErrNotFound) || media.FilePath == "" {
+		issue := FsckIssue{Type: FsckMissingOriginal, MediaID: media.ID, Key: media.FilePath}
+		if options.Repair {
+			err = config.TrashMedia(media.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to trash media %s: %w", media.ID, err)
+			}
+			issue.Repaired = true
+			issue.Detail = "moved to trash"
+		}
+		return []FsckIssue{issue}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat original %s: %w", media.FilePath, err)
+	}
+	if options.Quick {
+		return nil, nil
+	}
+
+	hash, err := storedHash(config.MediaStore, media.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash original %s: %w", media.FilePath, err)
+	}
+	if hash != media.Hash {
+		return []FsckIssue{{
+			Type:    FsckHashMismatch,
+			MediaID: media.ID,
+			Key:     media.FilePath,
+			Detail:  "hashes to " + hash,
+		}}, nil
+	}
+
+	return nil, nil
+}
+
+func fsckThumbnails(config FsckConfig, options FsckOptions, media Media) ([]FsckIssue, error) {
+	if media.ThumbnailsPending {
+		return nil, nil
+	}
+
+	issues := []FsckIssue{}
+	if media.ThumbnailsFailed != "" {
+		issues = append(issues, FsckIssue{Type: FsckFailedThumbnail, MediaID: media.ID, Detail: media.ThumbnailsFailed})
+	}
+	keys := media.Thumbnails.Keys()
+	if len(keys) == 0 {
+		issues = append(issues, FsckIssue{Type: FsckMissingThumbnail, MediaID: media.ID, Detail: "no thumbnails"})
+	}
+	for _, key := range keys {
+		_, err := config.ThumbnailStore.Stat(key)
+		if errors.Is(err, ErrNotFound) {
+			issues = append(issues, FsckIssue{Type: FsckMissingThumbnail, MediaID: media.ID, Key: key})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat thumbnail %s: %w", key, err)
+		}
+	}
+
+	if options.Repair && len(issues) > 0 {
+		err := config.QueueThumbnails(media.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to queue thumbnails of %s: %w", media.ID, err)
+		}
+		for i := range issues {
+			issues[i].Repaired = true
+			issues[i].Detail = strings.TrimSpace(issues[i].Detail + " queued")
+		}
+	}
+
+	return issues, nil
+}
+
+// reindexOrphan imports an original that is not in the index, once the
+// import has stored it at another key the orphan is removed
+func reindexOrphan(config FsckConfig, key string) (string, bool, error) {
+	tmpFilename, err := config.DownloadOriginal(key)
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmpFilename)
+
+	media, err := config.ImportFile(tmpFilename)
+	if err != nil {
+		return "", false, err
+	}
+	if media.ID == "" {
+		return "", false, fmt.Errorf("%w: not a media file", ErrNotSupported)
+	}
+	if media.FilePath != key {
+		_, err = config.MediaStore.Stat(media.FilePath)
+		if err != nil {
+			return media.ID, false, fmt.Errorf("failed to stat %s: %w", media.FilePath, err)
+		}
+		err = config.MediaStore.Delete(key)
+		if err != nil {
+			return media.ID, false, err
+		}
+	}
+
+	return media.ID, true, nil
+}
+
+// SidecarExt is appended to the key of an original for its sidecar
+const SidecarExt = ".json"
+
+// MediaSidecar holds what can not be read from an original, it is kept next
+// to the original in the media store as <key>.json
+type MediaSidecar struct {
+	Caption     string      `json:"caption,omitempty"`
+	Hashtags    []string    `json:"hashtags,omitempty"`
+	TriageState TriageState `json:"triage_state,omitempty"`
+	DeletedAt   *time.Time  `json:"deleted_at,omitempty"`
+}
+
+// NewSidecarWriter writes the sidecar of a media from the index, media
+// without an original in the store has no sidecar
+func NewSidecarWriter(fetchMedia QueryMediaDetail, mediaStore Store) SaveSidecar {
+	return func(mediaID string) error {
+		media, err := fetchMedia(mediaID)
+		if err != nil {
+			return err
+		}
+		if media.FilePath == "" {
+			return nil
+		}
+
+		sidecar := MediaSidecar{
+			Caption:     media.Caption,
+			Hashtags:    []string{},
+			TriageState: media.TriageState,
+			DeletedAt:   media.DeletedAt,
+		}
+		for _, c := range media.Collections {
+			if c.Type == CollectionTypeHashTag {
+				sidecar.Hashtags = append(sidecar.Hashtags, c.Title)
+			}
+		}
+		sidecarJSON, err := json.Marshal(sidecar)
+		if err != nil {
+			return err
+		}
+
+		return mediaStore.Put(media.FilePath+SidecarExt, bytes.NewReader(sidecarJSON), "application/json")
+	}
+}
+
+// WithSidecar saves the sidecar of a media once update succeeds
+func WithSidecar(update UpdateMediaTextProperty, saveSidecar SaveSidecar) UpdateMediaTextProperty {
+	return func(mediaID, value string) error {
+		err := update(mediaID, value)
+		if err != nil {
+			return err
+		}
+		return saveSidecar(mediaID)
+	}
+}
+
+// WithTrashSidecar saves the sidecar of a media once it is moved into or out
+// of the trash
+func WithTrashSidecar(trash DeleteMedia, saveSidecar SaveSidecar) DeleteMedia {
+	return func(mediaID string) error {
+		err := trash(mediaID)
+		if err != nil {
+			return err
+		}
+		return saveSidecar(mediaID)
+	}
+}
+
+// WithTriageSidecar saves the sidecars of media once their state is set
+func WithTriageSidecar(setTriageState SetTriageState, saveSidecar SaveSidecar) SetTriageState {
+	return func(mediaIDs []string, state TriageState) error {
+		err := setTriageState(mediaIDs, state)
+		if err != nil {
+			return err
+		}
+		for _, mediaID := range mediaIDs {
+			err = saveSidecar(mediaID)
+			if err != nil {
+				return fmt.Errorf("failed to save sidecar of %s: %w", mediaID, err)
+			}
+		}
+		return nil
+	}
+}
+
+// WithBulkSidecar saves the sidecars of media changed by an applied caption,
+// hashtag, delete or restore bulk operation
+func WithBulkSidecar(bulkOperation BulkMediaOperation, saveSidecar SaveSidecar) BulkMediaOperation {
+	return func(request BulkRequest) (BulkResult, error) {
+		result, err := bulkOperation(request)
+		if err != nil || !result.Applied {
+			return result, err
+		}
+		switch request.Operation {
+		case BulkOperationSetCaption, BulkOperationAddTag, BulkOperationRemoveTag,
+			BulkOperationDelete, BulkOperationRestore:
+		default:
+			return result, nil
+		}
+		for _, item := range result.Results {
+			err = saveSidecar(item.MediaID)
+			if err != nil {
+				return result, fmt.Errorf("failed to save sidecar of %s: %w", item.MediaID, err)
+			}
+		}
+		return result, nil
+	}
+}
+
+type ReindexResult struct {
+	Listed  int `json:"listed"`
+	Indexed int `json:"indexed"`
+	Failed  int `json:"failed"`
+}
+
+type ReindexerConfig struct {
+	MediaStore       Store
+	DownloadOriginal Downloader
+	ExtractMetadata  MetadataExtractor
+	Geocode          Geocoder
+	IndexMedia       Indexer
+	AddHashtag       UpdateMediaTextProperty
+	SetTriageState   SetTriageState
+	TrashMedia       TrashMediaAt
+	QueueThumbnails  QueueThumbnails
+	Logger           Logger
+}
+
+// NewReindexer rebuilds the index from the originals in the media store,
+// originals are only read. Metadata is extracted again, captions, hashtags,
+// triage states and trashed media come from sidecars when present and
+// thumbnails are queued.
+// Failures are logged and counted and do not stop the reindex
+func NewReindexer(config ReindexerConfig) Reindexer {
+	return func() (ReindexResult, error) {
+		result := ReindexResult{}
+		objects, err := config.MediaStore.List("")
+		if err != nil {
+			return result, fmt.Errorf("failed to list media store: %w", err)
+		}
+
+		for _, object := range objects {
+			if _, extValid := mediaExtensions[strings.ToLower(path.Ext(object.Key))]; !extValid {
+				continue
+			}
+			result.Listed++
+
+			media, err := reindexOriginal(config, object.Key)
+			if err != nil {
+				result.Failed++
+				config.Logger.Error("failed to reindex original",
+					"err", err,
+					"key", object.Key)
+				continue
+			}
+			result.Indexed++
+			config.Logger.Info("reindexed media",
+				"mediaID", media.ID,
+				"key", object.Key)
+		}
+
+		return result, nil
+	}
+}
+
+func reindexOriginal(config ReindexerConfig, key string) (Media, error) {
+	media := Media{}
+	sidecar, err := loadSidecar(config.MediaStore, key)
+	if err != nil {
+		return media, fmt.Errorf("failed to load sidecar: %w", err)
+	}
+
+	tmpFilename, err := config.DownloadOriginal(key)
+	if err != nil {
+		return media, fmt.Errorf("failed to download original: %w", err)
+	}
+	defer os.Remove(tmpFilename)
+
+	mediaMeta, err := config.ExtractMetadata(tmpFilename)
+	if err != nil {
+		return media, fmt.Errorf("failed to extract media metadata: %w", err)
+	}
+	media.MediaMetadata = mediaMeta
+	media.FilePath = key
+	media.Caption = mediaMeta.Title
+	if sidecar.Caption != "" {
+		media.Caption = sidecar.Caption
+	}
+
+	// a failed geocode leaves media out of the places collections
+	loc, err := config.Geocode(media.Coordinates.Lat, media.Coordinates.Lng, media.Date)
+	if err != nil {
+		config.Logger.Error("failed to geocode",
+			"err", err,
+			"key", key)
+	}
+	media.Location = loc
+
+	media.ThumbnailsPending = true
+	media, err = config.IndexMedia(media)
+	if err != nil {
+		return media, fmt.Errorf("failed to index media metadata: %w", err)
+	}
+	for _, hashtag := range sidecar.Hashtags {
+		err = config.AddHashtag(media.ID, hashtag)
+		if err != nil {
+			return media, fmt.Errorf("failed to add hashtag %q: %w", hashtag, err)
+		}
+	}
+	if sidecar.TriageState != "" && sidecar.TriageState != TriageStateNew {
+		err = config.SetTriageState([]string{media.ID}, sidecar.TriageState)
+		if err != nil {
+			return media, fmt.Errorf("failed to set triage state: %w", err)
+		}
+	}
+	if sidecar.DeletedAt != nil {
+		err = config.TrashMedia(media.ID, *sidecar.DeletedAt)
+		if err != nil {
+			return media, fmt.Errorf("failed to trash media: %w", err)
+		}
+		media.DeletedAt = sidecar.DeletedAt
+	}
+
+	err = config.QueueThumbnails(media.ID)
+	if err != nil {
+		return media, fmt.Errorf("failed to queue thumbnails: %w", err)
+	}
+
+	return media, nil
+}
+
+// loadSidecar returns an empty sidecar when the original has none
+func loadSidecar(store Store, key string) (MediaSidecar, error) {
+	sidecar := MediaSidecar{}
+	f, err := store.Get(key + SidecarExt)
+	if errors.Is(err, ErrNotFound) {
+		return sidecar, nil
+	}
+	if err != nil {
+		return sidecar, err
+	}
+	defer f.Close()
+
+	err = json.NewDecoder(f).Decode(&sidecar)
+	if err != nil {
+		return sidecar, fmt.Errorf("%w: sidecar %s: %s", ErrInvalidInput, key+SidecarExt, err.Error())
+	}
+	return sidecar, nil
+}
+
+// storedHash is the md5 of a stored file, the same hash as parseHash
+func storedHash(store Store, key string) (string, error) {
+	f, err := store.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
 func parseHash(filePath string) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -1,21 +1,30 @@
 package app_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
 	"github.com/google/uuid"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
 	appconfig "github.com/j4y_funabashi/inari/apps/api/pkg/app_config"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/google"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
 	"gotest.tools/v3/assert"
 )
@@ -42,15 +51,12 @@ func TestImport(t *testing.T) {
 					CameraMake:  "Samsung",
 					CameraModel: "GT-I9100",
 					Keywords:    "holiday",
+					KeywordList: []string{"holiday"},
 					Title:       "Ferry to Rotterdam",
 					Date:        time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
 				},
-				FilePath: "2014/20140321_080118_caf73e9785fa54300a051df95cfa2db9.jpg",
-				Thumbnails: app.MediaSrc{
-					Small:  "sqsm_20140321_080118_caf73e9785fa54300a051df95cfa2db9.jpg",
-					Medium: "sqmd_20140321_080118_caf73e9785fa54300a051df95cfa2db9.jpg",
-					Large:  "lg_20140321_080118_caf73e9785fa54300a051df95cfa2db9.jpg",
-				},
+				FilePath:          "2014/20140321_080118_caf73e9785fa54300a051df95cfa2db9.jpg",
+				ThumbnailsPending: true,
 				Collections: []app.Collection{
 					{
 						ID:    "inbox__2014-03",
@@ -72,6 +78,11 @@ func TestImport(t *testing.T) {
 						Title: "Fri, 21 Mar 2014",
 						Type:  app.CollectionTypeTimelineDay,
 					},
+					{
+						ID:    "hashtag__holiday",
+						Title: "holiday",
+						Type:  app.CollectionTypeHashTag,
+					},
 				},
 				Caption: "Ferry to Rotterdam",
 			},
@@ -207,17 +218,16 @@ func TestExport(t *testing.T) {
 			exportMedia := appconfig.NewExportMedia(testDir)
 			updateHashtag := appconfig.NewUpdateMediaHashtag(testDir)
 
-			// uploader
+			// export stores
 			micropubBucket := "micropub.funabashi.co.uk"
 			mediaBucket := "media.funabashi.co.uk"
 			cfg, _ := config.LoadDefaultConfig(context.TODO())
 			s3Client := s3.NewFromConfig(cfg)
-			s3Uploader := manager.NewUploader(s3Client)
 
-			micropubUploader := storage.NewUploader(micropubBucket, s3Uploader, s3Client)
-			mediaUploader := storage.NewUploader(mediaBucket, s3Uploader, s3Client)
+			micropubStore := storage.NewS3Store(s3Client, micropubBucket, "")
+			mediaStore := storage.NewS3Store(s3Client, mediaBucket, "")
 
-			export := appconfig.NewExporter(logger, queryMediaDetail, mediaUploader, micropubUploader, testDir, exportMedia)
+			export := appconfig.NewExporter(logger, queryMediaDetail, mediaStore, micropubStore, testDir, exportMedia)
 
 			// act
 			iMedia, err := importMedia(path.Join("./test_data", tC.filePath))
@@ -233,3 +243,1077 @@ func TestExport(t *testing.T) {
 		})
 	}
 }
+
+func TestKeywordRules(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		rules    app.KeywordRules
+		keywords []string
+		expected []string
+	}{
+		{
+			desc:     "keywords are lowercased and deduplicated",
+			keywords: []string{"Holiday", " holiday ", "Ferry"},
+			expected: []string{"holiday", "ferry"},
+		},
+		{
+			desc:     "hierarchical keywords use their last part",
+			keywords: []string{"Places|Japan|Tokyo", "Tokyo"},
+			expected: []string{"tokyo"},
+		},
+		{
+			desc: "keywords can be mapped and ignored",
+			rules: app.KeywordRules{
+				Map: map[string]string{
+					"Places|Japan|Tokyo": "japan",
+					"hols":               "Holiday",
+					"DSC":                "",
+				},
+				Ignore: []string{"lightroom export", "People|Jay"},
+			},
+			keywords: []string{"Places|Japan|Tokyo", "Places|UK|Leeds", "hols", "DSC", "Lightroom Export", "People|Jay"},
+			expected: []string{"japan", "leeds", "holiday"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.DeepEqual(t, tC.rules.Hashtags(tC.keywords), tC.expected)
+		})
+	}
+}
+
+func TestTrashPurger(t *testing.T) {
+	// arrange
+	longAgo := time.Now().Add(-40 * 24 * time.Hour)
+	recently := time.Now().Add(-2 * 24 * time.Hour)
+	removed := []string{}
+	purged := []string{}
+	purgeTrash := app.NewTrashPurger(app.TrashPurgerConfig{
+		ListTrash: func() ([]app.Media, error) {
+			return []app.Media{
+				{
+					ID:         "old",
+					FilePath:   "2014/old.jpg",
+					Thumbnails: app.MediaSrc{Small: "sqsm_old.jpg", Medium: "sqmd_old.jpg", Large: "lg_old.jpg"},
+					DeletedAt:  &longAgo,
+				},
+				{
+					ID:        "recent",
+					FilePath:  "2014/recent.jpg",
+					DeletedAt: &recently,
+				},
+				{
+					ID:        "nosidecar",
+					FilePath:  "2014/nosidecar.jpg",
+					DeletedAt: &longAgo,
+				},
+			}, nil
+		},
+		RemoveOriginal: func(filename string) error {
+			removed = append(removed, "media/"+filename)
+			return nil
+		},
+		RemoveSidecar: func(filename string) error {
+			if filename == "2014/nosidecar.jpg.json" {
+				return app.ErrNotFound
+			}
+			removed = append(removed, "media/"+filename)
+			return nil
+		},
+		RemoveThumbnail: func(filename string) error {
+			removed = append(removed, "thumbnails/"+filename)
+			return nil
+		},
+		PurgeMedia: func(mediaID string) error {
+			purged = append(purged, mediaID)
+			return nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	result, err := purgeTrash(30 * 24 * time.Hour)
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, len(result), 2)
+	assert.DeepEqual(t, purged, []string{"old", "nosidecar"})
+	assert.DeepEqual(t, removed, []string{
+		"media/2014/old.jpg",
+		"media/2014/old.jpg.json",
+		"thumbnails/sqsm_old.jpg",
+		"thumbnails/sqmd_old.jpg",
+		"thumbnails/lg_old.jpg",
+		"media/2014/nosidecar.jpg",
+	})
+}
+
+func TestTrashPurgerRemovesSidecars(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	original := filepath.Join(t.TempDir(), "tokyo.jpg")
+	assert.NilError(t, os.WriteFile(original, []byte("the bytes of a photo"), 0o600))
+	imported, err := appconfig.NewMediaImporter(baseDir,
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))),
+	)(original)
+	assert.NilError(t, err)
+	assert.NilError(t, appconfig.NewDeleteMedia(baseDir)(imported.ID))
+	mediaStore := appconfig.NewMediaStore(baseDir)
+	_, err = mediaStore.Stat(imported.FilePath + app.SidecarExt)
+	assert.NilError(t, err)
+
+	// act
+	purged, err := appconfig.NewPurgeTrash(baseDir, app.NewNullLogger())(0)
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, len(purged), 1)
+	objects, err := mediaStore.List("")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, objects, []app.StoredObject{})
+}
+
+func TestThumbnailGenerator(t *testing.T) {
+	// arrange
+	created := []string{}
+	generateThumbnail := app.NewThumbnailGenerator(app.ThumbnailGeneratorConfig{
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			if mediaID != "caf73e" {
+				return app.Media{}, app.ErrNotFound
+			}
+			return app.Media{
+				ID:         "caf73e",
+				FilePath:   "2014/20140321_080118_caf73e.jpg",
+				Thumbnails: app.MediaSrc{Small: "sqsm_20140321_080118_caf73e.jpg", Large: "lg_20140321_080118_caf73e.jpg"},
+			}, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnail: func(in, thumbnailKey string, options app.ThumbnailOptions) error {
+			created = append(created, in+" > "+thumbnailKey)
+			return nil
+		},
+	})
+
+	// act
+	err := generateThumbnail("lg_20140321_080118_caf73e.jpg")
+	assert.NilError(t, err)
+	unknownSize := generateThumbnail("xl_20140321_080118_caf73e.jpg")
+	unknownMedia := generateThumbnail("lg_20140321_080118_missing.jpg")
+
+	// assert
+	assert.DeepEqual(t, created, []string{"/tmp/2014/20140321_080118_caf73e.jpg > lg_20140321_080118_caf73e.jpg"})
+	assert.Assert(t, errors.Is(unknownSize, app.ErrNotFound))
+	assert.Assert(t, errors.Is(unknownMedia, app.ErrNotFound))
+}
+
+func TestValidateThumbnailProfiles(t *testing.T) {
+	valid := app.ThumbnailProfile{Name: "xl", Width: 2048, Height: 2048, Crop: app.ThumbnailCropFit, Formats: []app.ThumbnailFormat{app.ThumbnailFormatAVIF}}
+	testCases := []struct {
+		desc    string
+		profile func(p app.ThumbnailProfile) app.ThumbnailProfile
+	}{
+		{"names can not contain underscores", func(p app.ThumbnailProfile) app.ThumbnailProfile { p.Name = "x_l"; return p }},
+		{"sizes must be set", func(p app.ThumbnailProfile) app.ThumbnailProfile { p.Height = 0; return p }},
+		{"crop must be known", func(p app.ThumbnailProfile) app.ThumbnailProfile { p.Crop = "smart"; return p }},
+		{"formats must be known", func(p app.ThumbnailProfile) app.ThumbnailProfile { p.Formats = []app.ThumbnailFormat{"gif"}; return p }},
+		{"quality is a percentage", func(p app.ThumbnailProfile) app.ThumbnailProfile { p.Quality = 101; return p }},
+	}
+	assert.NilError(t, app.ValidateThumbnailProfiles(append(app.DefaultThumbnailProfiles, valid)))
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := app.ValidateThumbnailProfiles([]app.ThumbnailProfile{tC.profile(valid)})
+			assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+		})
+	}
+}
+
+func TestThumbnailRegenerator(t *testing.T) {
+	// arrange
+	saved := map[string]app.MediaSrc{}
+	regenerate := app.NewThumbnailRegenerator(app.ThumbnailRegeneratorConfig{
+		ListMedia: func() ([]app.Media, error) {
+			return []app.Media{
+				{ID: "photo", FilePath: "2014/photo.jpg"},
+				{ID: "video", FilePath: "2014/video.mp4"},
+			}, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnails: func(in, out string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+			if filepath.Ext(out) == ".mp4" {
+				return app.MediaSrc{}, errors.New("unsupported format")
+			}
+			return app.MediaSrc{Large: "lg_" + filepath.Base(out)}, nil
+		},
+		SaveThumbnails: func(mediaID string, thumbnails app.MediaSrc) error {
+			saved[mediaID] = thumbnails
+			return nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	regenerated, err := regenerate()
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, regenerated, 1)
+	assert.DeepEqual(t, saved, map[string]app.MediaSrc{"photo": {Large: "lg_photo.jpg"}})
+}
+
+func TestFocusPointUpdater(t *testing.T) {
+	// arrange
+	removed := []string{}
+	var saved *app.FocusPoint
+	updateFocusPoint := app.NewFocusPointUpdater(app.ThumbnailOptionsUpdaterConfig{
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			return app.Media{
+				ID:         mediaID,
+				FilePath:   "2014/photo.jpg",
+				Thumbnails: app.MediaSrc{Small: "sqsm_photo.jpg", Large: "lg_photo.jpg"},
+			}, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnails: func(in, out string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+			return app.MediaSrc{Small: "sqsm_photo-f20x30.jpg", Large: "lg_photo.jpg"}, nil
+		},
+		SaveThumbnailOptions: func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+			saved = options.FocusPoint
+			return nil
+		},
+		RemoveThumbnail: func(key string) error {
+			removed = append(removed, key)
+			return nil
+		},
+	})
+
+	// act
+	thumbnails, err := updateFocusPoint("photo", &app.FocusPoint{X: 0.2, Y: 0.3})
+	_, invalid := updateFocusPoint("photo", &app.FocusPoint{X: 1.2})
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, thumbnails.Small, "sqsm_photo-f20x30.jpg")
+	assert.DeepEqual(t, saved, &app.FocusPoint{X: 0.2, Y: 0.3})
+	assert.DeepEqual(t, removed, []string{"sqsm_photo.jpg"})
+	assert.Assert(t, errors.Is(invalid, app.ErrInvalidInput))
+}
+
+func TestMediaEditor(t *testing.T) {
+	// arrange
+	media := app.Media{ID: "photo", FilePath: "2014/photo.jpg"}
+	config := app.ThumbnailOptionsUpdaterConfig{
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			return media, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnails: func(in, out string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+			return app.MediaSrc{Large: fmt.Sprintf("lg_photo-%d.jpg", len(options.Edits))}, nil
+		},
+		SaveThumbnailOptions: func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+			media.ThumbnailOptions = options
+			media.Thumbnails = thumbnails
+			return nil
+		},
+		RemoveThumbnail: func(key string) error { return nil },
+	}
+	editMedia := app.NewMediaEditor(config)
+	revertEdits := app.NewEditReverter(config)
+	rotate := app.Edit{Operation: app.EditRotate, Degrees: 90}
+	flip := app.Edit{Operation: app.EditFlip, Direction: "vertical"}
+
+	// act
+	_, err := editMedia("photo", []app.Edit{rotate})
+	assert.NilError(t, err)
+	thumbnails, err := editMedia("photo", []app.Edit{flip})
+	assert.NilError(t, err)
+	edited := media.Edits
+	_, invalid := editMedia("photo", []app.Edit{{Operation: app.EditRotate, Degrees: 45}})
+	reverted, err := revertEdits("photo")
+	assert.NilError(t, err)
+
+	// assert
+	assert.DeepEqual(t, edited, []app.Edit{rotate, flip})
+	assert.Equal(t, thumbnails.Large, "lg_photo-2.jpg")
+	assert.Assert(t, errors.Is(invalid, app.ErrInvalidInput))
+	assert.Equal(t, reverted.Large, "lg_photo-0.jpg")
+	assert.Equal(t, len(media.Edits), 0)
+}
+
+func TestThumbnailWorker(t *testing.T) {
+	// arrange
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	jobs := []app.ThumbnailJob{
+		{MediaID: "photo", Status: app.ThumbnailJobPending, NextAttemptAt: now},
+		{MediaID: "video", Status: app.ThumbnailJobPending, NextAttemptAt: now, Attempts: 2},
+		{MediaID: "broken", Status: app.ThumbnailJobPending, NextAttemptAt: now, Attempts: app.MaxThumbnailJobAttempts - 1},
+	}
+	saved := map[string]app.MediaSrc{}
+	retried := map[string]app.ThumbnailJob{}
+	failed := map[string]string{}
+	work := app.NewThumbnailWorker(app.ThumbnailWorkerConfig{
+		NextJob: func(now time.Time) (app.ThumbnailJob, error) {
+			for _, job := range jobs {
+				_, isSaved := saved[job.MediaID]
+				_, isRetried := retried[job.MediaID]
+				if !isSaved && !isRetried {
+					return job, nil
+				}
+			}
+			return app.ThumbnailJob{}, app.ErrNotFound
+		},
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			ext := map[string]string{"photo": ".jpg", "video": ".mp4", "broken": ".mp4"}[mediaID]
+			return app.Media{ID: mediaID, FilePath: "2014/" + mediaID + ext}, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnails: func(in, out string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+			if filepath.Ext(out) == ".mp4" {
+				return app.MediaSrc{}, errors.New("unsupported format")
+			}
+			return app.MediaSrc{Large: "lg_" + filepath.Base(out)}, nil
+		},
+		SaveThumbnails: func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+			saved[mediaID] = thumbnails
+			return nil
+		},
+		RetryJob: func(job app.ThumbnailJob) error {
+			retried[job.MediaID] = job
+			return nil
+		},
+		FailThumbnails: func(mediaID string, reason string) error {
+			failed[mediaID] = reason
+			return nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	processed, err := work(now)
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, processed, 1)
+	assert.DeepEqual(t, saved, map[string]app.MediaSrc{"photo": {Large: "lg_photo.jpg"}})
+	assert.DeepEqual(t, retried["video"], app.ThumbnailJob{
+		MediaID:       "video",
+		Status:        app.ThumbnailJobPending,
+		Attempts:      3,
+		NextAttemptAt: now.Add(2 * time.Minute),
+		LastError:     "unsupported format",
+	})
+	assert.Equal(t, retried["broken"].Status, app.ThumbnailJobFailed)
+	assert.DeepEqual(t, failed, map[string]string{"broken": "unsupported format"})
+}
+
+func TestThumbnailWorkerSkipsStaleThumbnails(t *testing.T) {
+	// arrange
+	now := time.Date(2024, time.May, 1, 9, 0, 0, 0, time.UTC)
+	focus := &app.FocusPoint{X: 0.2, Y: 0.8}
+	jobs := []app.ThumbnailJob{{MediaID: "photo", Status: app.ThumbnailJobPending, NextAttemptAt: now}}
+	current := app.ThumbnailOptions{}
+	saved := []app.ThumbnailOptions{}
+	retried := []app.ThumbnailJob{}
+	work := app.NewThumbnailWorker(app.ThumbnailWorkerConfig{
+		NextJob: func(now time.Time) (app.ThumbnailJob, error) {
+			if len(jobs) == 0 {
+				return app.ThumbnailJob{}, app.ErrNotFound
+			}
+			return jobs[0], nil
+		},
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			return app.Media{ID: mediaID, FilePath: "2014/photo.jpg", ThumbnailOptions: current}, nil
+		},
+		DownloadOriginal: func(filePath string) (string, error) {
+			return "/tmp/" + filePath, nil
+		},
+		CreateThumbnails: func(in, out string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+			// a focus point is saved while the first thumbnails are created
+			current.FocusPoint = focus
+			return app.MediaSrc{Large: "lg_photo.jpg"}, nil
+		},
+		SaveThumbnails: func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+			if !reflect.DeepEqual(options, current) {
+				return fmt.Errorf("%w: stale", app.ErrConflict)
+			}
+			saved = append(saved, options)
+			jobs = nil
+			return nil
+		},
+		RetryJob: func(job app.ThumbnailJob) error {
+			retried = append(retried, job)
+			return nil
+		},
+		FailThumbnails: func(mediaID string, reason string) error {
+			return errors.New("not failed")
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	processed, err := work(now)
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, processed, 1)
+	assert.DeepEqual(t, saved, []app.ThumbnailOptions{{FocusPoint: focus}})
+	assert.Equal(t, len(retried), 0)
+}
+
+func TestThumbnailWorkerFailsExhaustedJobs(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	original := filepath.Join(t.TempDir(), "broken.jpg")
+	assert.NilError(t, os.WriteFile(original, []byte("not really a jpeg"), 0o600))
+	imported, err := appconfig.NewMediaImporter(baseDir,
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))),
+	)(original)
+	assert.NilError(t, err)
+	work := appconfig.NewThumbnailWorker(baseDir, app.NewNullLogger())
+	now := time.Now()
+
+	// act
+	for range app.MaxThumbnailJobAttempts {
+		processed, err := work(now)
+		assert.NilError(t, err)
+		assert.Equal(t, processed, 0)
+		now = now.Add(time.Hour)
+	}
+
+	// assert
+	media, err := appconfig.NewMediaDetail(baseDir)(imported.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, media.ThumbnailsPending, false)
+	assert.Assert(t, media.ThumbnailsFailed != "")
+	report, err := appconfig.NewFsck(baseDir, app.NewNullLogger())(app.FsckOptions{Quick: true})
+	assert.NilError(t, err)
+	assert.Assert(t, slices.ContainsFunc(report.Issues, func(issue app.FsckIssue) bool {
+		return issue.Type == app.FsckFailedThumbnail && issue.MediaID == imported.ID
+	}))
+	processed, err := work(now.Add(24 * time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, processed, 0)
+}
+
+func TestThumbnailJobBackoff(t *testing.T) {
+	assert.Equal(t, app.ThumbnailJobBackoff(1), 30*time.Second)
+	assert.Equal(t, app.ThumbnailJobBackoff(2), time.Minute)
+	assert.Equal(t, app.ThumbnailJobBackoff(20), time.Hour)
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestPlaceholderBackfiller(t *testing.T) {
+	// arrange
+	saved := map[string]app.MediaSrc{}
+	backfill := app.NewPlaceholderBackfiller(app.PlaceholderBackfillerConfig{
+		ListMedia: func() ([]app.Media, error) {
+			return []app.Media{
+				{ID: "old", Thumbnails: app.MediaSrc{Large: "lg_old.jpg"}},
+				{ID: "done", Thumbnails: app.MediaSrc{Large: "lg_done.jpg", ThumbnailPlaceholder: app.ThumbnailPlaceholder{BlurHash: "L00000"}}},
+				{ID: "pending", ThumbnailsPending: true},
+				{ID: "missing", Thumbnails: app.MediaSrc{Large: "lg_missing.jpg"}},
+			}, nil
+		},
+		OpenThumbnail: func(key string) (app.StoredFile, error) {
+			if key == "lg_missing.jpg" {
+				return app.StoredFile{}, app.ErrNotFound
+			}
+			return app.StoredFile{ReadSeekCloser: nopCloser{strings.NewReader(key)}}, nil
+		},
+		ExtractPlaceholder: func(thumbnail io.Reader) (app.ThumbnailPlaceholder, error) {
+			key, err := io.ReadAll(thumbnail)
+			return app.ThumbnailPlaceholder{BlurHash: "hash-of-" + string(key), DominantColour: "#000000"}, err
+		},
+		SaveThumbnails: func(mediaID string, thumbnails app.MediaSrc) error {
+			saved[mediaID] = thumbnails
+			return nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	backfilled, err := backfill()
+
+	// assert
+	assert.NilError(t, err)
+	assert.Equal(t, backfilled, 1)
+	assert.DeepEqual(t, saved, map[string]app.MediaSrc{"old": {
+		Large:                "lg_old.jpg",
+		ThumbnailPlaceholder: app.ThumbnailPlaceholder{BlurHash: "hash-of-lg_old.jpg", DominantColour: "#000000"},
+	}})
+}
+
+func TestStoreLayout(t *testing.T) {
+	media := app.MediaMetadata{
+		Hash:        "caf73e",
+		Ext:         "jpg",
+		CameraMake:  "Samsung",
+		CameraModel: "GT-I9100",
+		Date:        time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
+	}
+
+	t.Run("it builds paths from the layout", func(t *testing.T) {
+		testCases := []struct {
+			layout   app.StoreLayout
+			expected string
+		}{
+			{app.DefaultStoreLayout, "2014/20140321_080118_caf73e.jpg"},
+			{"{year}/{month}/{camera}/{hash}.{ext}", "2014/03/samsung-gt-i9100/caf73e.jpg"},
+			{"{year}/{month}/{day}/{time}_{hash}.{ext}", "2014/03/21/080118_caf73e.jpg"},
+		}
+		for _, tC := range testCases {
+			assert.NilError(t, tC.layout.Validate())
+			assert.Equal(t, media.StorePath(tC.layout), tC.expected)
+		}
+		assert.Equal(t, media.NewFilename(), "2014/20140321_080118_caf73e.jpg")
+		assert.Equal(t, app.MediaMetadata{Hash: "x", Ext: "jpg"}.StorePath("{camera}/{hash}.{ext}"), "unknown-camera/x.jpg")
+	})
+
+	t.Run("it rejects layouts that break thumbnail keys", func(t *testing.T) {
+		for _, layout := range []app.StoreLayout{
+			"",
+			"/{year}/{hash}.{ext}",
+			"../{hash}.{ext}",
+			"{year}/{hash}",
+			"{year}/{camera}-{hash}.{ext}",
+			"{year}/{hash}.{ext}/{day}",
+			"{yyyy}/{hash}.{ext}",
+		} {
+			err := layout.Validate()
+			assert.Assert(t, errors.Is(err, app.ErrInvalidInput), layout)
+		}
+	})
+}
+
+// fakeImportSource is an in memory S3 like source, ETags are the md5 of the content
+type fakeImportSource map[string]string
+
+func (s fakeImportSource) List(prefix string) ([]app.StoredObject, error) {
+	out := []app.StoredObject{}
+	for key, content := range s {
+		if strings.HasPrefix(key, prefix) {
+			out = append(out, app.StoredObject{Key: key, Size: int64(len(content)), ETag: fmt.Sprintf("%x", md5.Sum([]byte(content)))})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+func (s fakeImportSource) Get(key string) (app.StoredFile, error) {
+	content, ok := s[key]
+	if !ok {
+		return app.StoredFile{}, app.ErrNotFound
+	}
+	return app.StoredFile{ReadSeekCloser: nopCloser{strings.NewReader(content)}}, nil
+}
+
+func TestSourceImporter(t *testing.T) {
+	// arrange
+	source := fakeImportSource{
+		"backup/2023/tokyo.jpg":   "tokyo",
+		"backup/2023/kyoto.JPG":   "kyoto",
+		"backup/2023/notes.txt":   "notes",
+		"backup/2023/corrupt.jpg": "corrupt",
+		"backup/2023/indexed.mov": "indexed",
+		"other/leeds.jpg":         "leeds",
+	}
+	indexedHash := fmt.Sprintf("%x", md5.Sum([]byte("indexed")))
+	records := map[string]app.ImportedObject{}
+	importedFiles := []string{}
+	importSource := app.NewSourceImporter(app.SourceImporterConfig{
+		OpenSource: func(sourceURL string) (app.ImportSource, string, error) {
+			assert.Equal(t, sourceURL, "s3://photos/backup/")
+			return source, "backup/", nil
+		},
+		FetchMediaDetail: func(mediaID string) (app.Media, error) {
+			if mediaID == indexedHash {
+				return app.Media{ID: indexedHash, MediaMetadata: app.MediaMetadata{Hash: indexedHash}}, nil
+			}
+			return app.Media{}, app.ErrNotFound
+		},
+		FetchImported: func(sourceURL, key string) (app.ImportedObject, error) {
+			record, ok := records[key]
+			if !ok {
+				return record, app.ErrNotFound
+			}
+			return record, nil
+		},
+		SaveImported: func(object app.ImportedObject) error {
+			records[object.Key] = object
+			return nil
+		},
+		ImportFile: func(tmpFilename string) (app.Media, error) {
+			assert.Equal(t, filepath.Ext(tmpFilename), ".jpg")
+			content, err := os.ReadFile(tmpFilename)
+			assert.NilError(t, err)
+			if string(content) == "corrupt" {
+				return app.Media{}, fmt.Errorf("failed to extract media metadata")
+			}
+			importedFiles = append(importedFiles, string(content))
+			return app.Media{ID: "id-" + string(content)}, nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	t.Run("it imports new objects", func(t *testing.T) {
+		// act
+		result, err := importSource("s3://photos/backup/")
+
+		// assert
+		assert.NilError(t, err)
+		assert.DeepEqual(t, result, app.SourceImportResult{Listed: 4, Imported: 2, Skipped: 1, Failed: 1})
+		assert.DeepEqual(t, importedFiles, []string{"kyoto", "tokyo"})
+		assert.Equal(t, records["backup/2023/tokyo.jpg"].MediaID, "id-tokyo")
+		assert.Equal(t, records["backup/2023/indexed.mov"].MediaID, indexedHash)
+		_, failedRecorded := records["backup/2023/corrupt.jpg"]
+		assert.Assert(t, !failedRecorded)
+	})
+
+	t.Run("unchanged objects are skipped", func(t *testing.T) {
+		// arrange
+		importedFiles = []string{}
+
+		// act
+		result, err := importSource("s3://photos/backup/")
+
+		// assert
+		assert.NilError(t, err)
+		assert.DeepEqual(t, result, app.SourceImportResult{Listed: 4, Imported: 0, Skipped: 3, Failed: 1})
+		assert.Equal(t, len(importedFiles), 0)
+	})
+
+	t.Run("changed objects are imported again", func(t *testing.T) {
+		// arrange
+		importedFiles = []string{}
+		source["backup/2023/tokyo.jpg"] = "tokyo tower"
+
+		// act
+		result, err := importSource("s3://photos/backup/")
+
+		// assert
+		assert.NilError(t, err)
+		assert.Equal(t, result.Imported, 1)
+		assert.DeepEqual(t, importedFiles, []string{"tokyo tower"})
+	})
+}
+
+func TestFsck(t *testing.T) {
+	hashOf := func(content string) string { return fmt.Sprintf("%x", md5.Sum([]byte(content))) }
+	put := func(t *testing.T, store app.Store, key, content string) {
+		t.Helper()
+		assert.NilError(t, store.Put(key, strings.NewReader(content), ""))
+	}
+	thumbnailKey := func(hash string) string { return "lg_20230410_090000_" + hash + ".jpg" }
+
+	type calls struct {
+		trashed     []string
+		queued      []string
+		removed     []app.CollectionMembership
+		deleted     []string
+		importedTmp int
+	}
+	newFsck := func(t *testing.T) (app.Fsck, app.Store, app.Store, *calls) {
+		mediaStore := storage.NewLocalFSStore(t.TempDir())
+		thumbnailStore := storage.NewLocalFSStore(t.TempDir())
+		called := &calls{}
+		newMedia := func(id, content string) app.Media {
+			return app.Media{
+				ID:            id,
+				FilePath:      "2023/" + id + ".jpg",
+				MediaMetadata: app.MediaMetadata{Hash: hashOf(content)},
+				Thumbnails:    app.MediaSrc{Large: thumbnailKey(hashOf(content))},
+			}
+		}
+
+		good := newMedia("good", "good")
+		put(t, mediaStore, good.FilePath, "good")
+		put(t, thumbnailStore, good.Thumbnails.Large, "thumbnail")
+		missing := newMedia("missing", "missing")
+		put(t, thumbnailStore, missing.Thumbnails.Large, "thumbnail")
+		corrupt := newMedia("corrupt", "original")
+		put(t, mediaStore, corrupt.FilePath, "bit rot")
+		put(t, thumbnailStore, corrupt.Thumbnails.Large, "thumbnail")
+		noThumbnail := newMedia("nothumbnail", "nothumbnail")
+		put(t, mediaStore, noThumbnail.FilePath, "nothumbnail")
+		pending := newMedia("pending", "pending")
+		pending.Thumbnails = app.MediaSrc{}
+		pending.ThumbnailsPending = true
+		put(t, mediaStore, pending.FilePath, "pending")
+		put(t, thumbnailStore, thumbnailKey(hashOf("pending")), "being saved")
+		trashed := newMedia("trashed", "trashed")
+		put(t, mediaStore, trashed.FilePath, "trashed")
+		put(t, thumbnailStore, trashed.Thumbnails.Large, "thumbnail")
+
+		put(t, mediaStore, "2023/orphan.jpg", "orphan")
+		put(t, mediaStore, "2023/notes.txt", "notes")
+		put(t, mediaStore, good.FilePath+app.SidecarExt, `{"caption": "good"}`)
+		put(t, thumbnailStore, thumbnailKey(hashOf("gone")), "thumbnail")
+
+		fsck := app.NewFsck(app.FsckConfig{
+			ListMedia: func() ([]app.Media, error) {
+				return []app.Media{good, missing, corrupt, noThumbnail, pending}, nil
+			},
+			ListTrash: func() ([]app.Media, error) {
+				return []app.Media{trashed}, nil
+			},
+			MediaStore:       mediaStore,
+			ThumbnailStore:   thumbnailStore,
+			DownloadOriginal: storage.NewStoreDownloader(mediaStore),
+			ImportFile: func(tmpFilename string) (app.Media, error) {
+				called.importedTmp++
+				if filepath.Ext(tmpFilename) != ".jpg" {
+					return app.Media{}, nil
+				}
+				put(t, mediaStore, "2020/20200101_000000_orphan.jpg", "orphan")
+				return app.Media{ID: "orphan", FilePath: "2020/20200101_000000_orphan.jpg"}, nil
+			},
+			QueueThumbnails: func(mediaID string) error {
+				called.queued = append(called.queued, mediaID)
+				return nil
+			},
+			TrashMedia: func(mediaID string) error {
+				called.trashed = append(called.trashed, mediaID)
+				return nil
+			},
+			ListDanglingMemberships: func() ([]app.CollectionMembership, error) {
+				return []app.CollectionMembership{{CollectionID: "hashtag_tokyo", MediaID: "purged"}}, nil
+			},
+			RemoveMembership: func(membership app.CollectionMembership) error {
+				called.removed = append(called.removed, membership)
+				return nil
+			},
+			ListEmptyCollections: func() ([]app.Collection, error) {
+				return []app.Collection{{ID: "hashtag_old"}, {ID: "places_leeds", MediaCount: 1}}, nil
+			},
+			DeleteCollection: func(collectionID string) error {
+				called.deleted = append(called.deleted, collectionID)
+				return nil
+			},
+			Logger: app.NewNullLogger(),
+		})
+
+		return fsck, mediaStore, thumbnailStore, called
+	}
+
+	t.Run("it reports every issue without changing anything", func(t *testing.T) {
+		// arrange
+		fsck, mediaStore, thumbnailStore, called := newFsck(t)
+
+		// act
+		report, err := fsck(app.FsckOptions{})
+
+		// assert
+		assert.NilError(t, err)
+		assert.DeepEqual(t, report, app.FsckReport{
+			Media:      6,
+			Originals:  6,
+			Thumbnails: 6,
+			Issues: []app.FsckIssue{
+				{Type: app.FsckMissingOriginal, MediaID: "missing", Key: "2023/missing.jpg"},
+				{Type: app.FsckHashMismatch, MediaID: "corrupt", Key: "2023/corrupt.jpg", Detail: "hashes to " + hashOf("bit rot")},
+				{Type: app.FsckMissingThumbnail, MediaID: "nothumbnail", Key: thumbnailKey(hashOf("nothumbnail"))},
+				{Type: app.FsckOrphanOriginal, Key: "2023/orphan.jpg"},
+				{Type: app.FsckOrphanThumbnail, Key: thumbnailKey(hashOf("gone"))},
+				{Type: app.FsckDanglingMember, MediaID: "purged", CollectionID: "hashtag_tokyo"},
+				{Type: app.FsckEmptyCollection, CollectionID: "hashtag_old"},
+				{Type: app.FsckEmptyCollection, CollectionID: "places_leeds", Detail: "1 trashed media"},
+			},
+		})
+		assert.Equal(t, report.Unrepaired(), 8)
+		assert.Equal(t, len(called.trashed)+len(called.queued)+len(called.removed)+len(called.deleted)+called.importedTmp, 0)
+		_, err = mediaStore.Stat("2023/orphan.jpg")
+		assert.NilError(t, err)
+		_, err = thumbnailStore.Stat(thumbnailKey(hashOf("gone")))
+		assert.NilError(t, err)
+	})
+
+	t.Run("quick checks do not re-hash originals", func(t *testing.T) {
+		// arrange
+		fsck, _, _, _ := newFsck(t)
+
+		// act
+		report, err := fsck(app.FsckOptions{Quick: true})
+
+		// assert
+		assert.NilError(t, err)
+		for _, issue := range report.Issues {
+			assert.Assert(t, issue.Type != app.FsckHashMismatch)
+		}
+	})
+
+	t.Run("it repairs what it can", func(t *testing.T) {
+		// arrange
+		fsck, mediaStore, thumbnailStore, called := newFsck(t)
+
+		// act
+		report, err := fsck(app.FsckOptions{Repair: true})
+
+		// assert
+		assert.NilError(t, err)
+		unrepaired := []app.FsckIssueType{}
+		for _, issue := range report.Issues {
+			if !issue.Repaired {
+				unrepaired = append(unrepaired, issue.Type)
+			}
+		}
+		assert.DeepEqual(t, unrepaired, []app.FsckIssueType{app.FsckHashMismatch, app.FsckEmptyCollection})
+		assert.DeepEqual(t, called.trashed, []string{"missing"})
+		assert.DeepEqual(t, called.queued, []string{"nothumbnail"})
+		assert.DeepEqual(t, called.removed, []app.CollectionMembership{{CollectionID: "hashtag_tokyo", MediaID: "purged"}})
+		assert.DeepEqual(t, called.deleted, []string{"hashtag_old"})
+		assert.Equal(t, called.importedTmp, 1)
+		_, err = mediaStore.Stat("2023/orphan.jpg")
+		assert.Assert(t, errors.Is(err, app.ErrNotFound), "re-indexed orphan is removed")
+		_, err = mediaStore.Stat("2023/notes.txt")
+		assert.NilError(t, err)
+		_, err = thumbnailStore.Stat(thumbnailKey(hashOf("gone")))
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+		_, err = thumbnailStore.Stat(thumbnailKey(hashOf("pending")))
+		assert.NilError(t, err, "thumbnails of pending media are kept")
+	})
+}
+
+func TestReindexer(t *testing.T) {
+	// arrange
+	mediaStore := storage.NewLocalFSStore(t.TempDir())
+	for key, content := range map[string]string{
+		"2023/20230410_090000_hash-tokyo.jpg":      "tokyo",
+		"2023/20230410_090000_hash-tokyo.jpg.json": `{"caption": "crossing at night", "hashtags": ["tokyo", "night"]}`,
+		"2023/20230420_090000_hash-kyoto.mov":      "kyoto",
+		"2023/20230501_090000_hash-broken.jpg":     "broken",
+		"2023/20230502_090000_hash-leeds.jpg":      "leeds",
+		"2023/20230502_090000_hash-leeds.jpg.json": `{"caption": `,
+		"notes.txt": "notes",
+	} {
+		assert.NilError(t, mediaStore.Put(key, strings.NewReader(content), ""))
+	}
+	indexed := []app.Media{}
+	hashtags := []string{}
+	queued := []string{}
+	reindex := app.NewReindexer(app.ReindexerConfig{
+		MediaStore:       mediaStore,
+		DownloadOriginal: storage.NewStoreDownloader(mediaStore),
+		ExtractMetadata: func(mediaFile string) (app.MediaMetadata, error) {
+			content, err := os.ReadFile(mediaFile)
+			assert.NilError(t, err)
+			if string(content) == "broken" {
+				return app.MediaMetadata{}, fmt.Errorf("exiftool failed")
+			}
+			return app.MediaMetadata{Hash: "hash-" + string(content), Title: "embedded " + string(content)}, nil
+		},
+		Geocode: func(lat, lng float64, cTime time.Time) (app.Location, error) {
+			return app.Location{}, fmt.Errorf("geocoder unavailable")
+		},
+		IndexMedia: func(media app.Media) (app.Media, error) {
+			media.ID = media.Hash
+			indexed = append(indexed, media)
+			return media, nil
+		},
+		AddHashtag: func(mediaID, hashtag string) error {
+			hashtags = append(hashtags, mediaID+" #"+hashtag)
+			return nil
+		},
+		QueueThumbnails: func(mediaID string) error {
+			queued = append(queued, mediaID)
+			return nil
+		},
+		Logger: app.NewNullLogger(),
+	})
+
+	// act
+	result, err := reindex()
+
+	// assert
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result, app.ReindexResult{Listed: 4, Indexed: 2, Failed: 2})
+	assert.DeepEqual(t, indexed, []app.Media{
+		{
+			ID:                "hash-tokyo",
+			FilePath:          "2023/20230410_090000_hash-tokyo.jpg",
+			MediaMetadata:     app.MediaMetadata{Hash: "hash-tokyo", Title: "embedded tokyo"},
+			Caption:           "crossing at night",
+			ThumbnailsPending: true,
+		},
+		{
+			ID:                "hash-kyoto",
+			FilePath:          "2023/20230420_090000_hash-kyoto.mov",
+			MediaMetadata:     app.MediaMetadata{Hash: "hash-kyoto", Title: "embedded kyoto"},
+			Caption:           "embedded kyoto",
+			ThumbnailsPending: true,
+		},
+	})
+	assert.DeepEqual(t, hashtags, []string{"hash-tokyo #tokyo", "hash-tokyo #night"})
+	assert.DeepEqual(t, queued, []string{"hash-tokyo", "hash-kyoto"})
+}
+
+// extractHash is a metadata extractor that only hashes the file it is given,
+// so tests can check what reached extraction without exiftool
+func extractHash(date time.Time) app.MetadataExtractor {
+	return func(mediaFile string) (app.MediaMetadata, error) {
+		content, err := os.ReadFile(mediaFile)
+		if err != nil {
+			return app.MediaMetadata{}, err
+		}
+		return app.MediaMetadata{
+			Hash:     fmt.Sprintf("%x", md5.Sum(content)),
+			Ext:      "jpg",
+			MimeType: "image/jpeg",
+			Date:     date,
+		}, nil
+	}
+}
+
+func TestSourceImporterImportsContent(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	srcDir := t.TempDir()
+	content := []byte("the bytes of a photo")
+	hash := fmt.Sprintf("%x", md5.Sum(content))
+	assert.NilError(t, os.MkdirAll(filepath.Join(srcDir, "2023"), 0o700))
+	assert.NilError(t, os.WriteFile(filepath.Join(srcDir, "2023", "tokyo.jpg"), content, 0o600))
+	importSource := appconfig.NewSourceImporter(baseDir, app.NewNullLogger(),
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))),
+	)
+
+	// act
+	result, err := importSource("file://" + srcDir)
+
+	// assert
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result, app.SourceImportResult{Listed: 1, Imported: 1})
+	media, err := appconfig.NewMediaDetail(baseDir)(hash)
+	assert.NilError(t, err)
+	assert.Equal(t, media.Hash, hash)
+	stored, err := appconfig.NewMediaStore(baseDir).Stat(media.FilePath)
+	assert.NilError(t, err)
+	assert.Equal(t, stored.Size, int64(len(content)))
+}
+
+func TestFsckReindexesOrphanContent(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	content := []byte("the bytes of an orphaned photo")
+	hash := fmt.Sprintf("%x", md5.Sum(content))
+	mediaStore := appconfig.NewMediaStore(baseDir)
+	assert.NilError(t, mediaStore.Put("lost/orphan.jpg", bytes.NewReader(content), "image/jpeg"))
+	fsck := appconfig.NewFsck(baseDir, app.NewNullLogger(),
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))),
+	)
+
+	// act
+	report, err := fsck(app.FsckOptions{Repair: true})
+
+	// assert
+	assert.NilError(t, err)
+	assert.DeepEqual(t, report.Issues, []app.FsckIssue{
+		{Type: app.FsckOrphanOriginal, Key: "lost/orphan.jpg", MediaID: hash, Repaired: true},
+	})
+	media, err := appconfig.NewMediaDetail(baseDir)(hash)
+	assert.NilError(t, err)
+	stored, err := mediaStore.Stat(media.FilePath)
+	assert.NilError(t, err)
+	assert.Equal(t, stored.Size, int64(len(content)))
+	_, err = mediaStore.Stat("lost/orphan.jpg")
+	assert.Assert(t, errors.Is(err, app.ErrNotFound))
+}
+
+func TestReindexRestoresSidecars(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	extract := extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))
+	original := filepath.Join(t.TempDir(), "tokyo.jpg")
+	assert.NilError(t, os.WriteFile(original, []byte("the bytes of a photo"), 0o600))
+	imported, err := appconfig.NewMediaImporter(baseDir,
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extract),
+	)(original)
+	assert.NilError(t, err)
+	assert.NilError(t, appconfig.NewUpdateMediaCaption(baseDir)(imported.ID, "crossing at night"))
+	assert.NilError(t, appconfig.NewUpdateMediaHashtag(baseDir)(imported.ID, "tokyo"))
+	assert.NilError(t, appconfig.NewSetTriageState(baseDir)([]string{imported.ID}, app.TriageStateReviewed))
+
+	// act
+	result, err := appconfig.NewReindexer(baseDir, app.NewNullLogger(), func(c *app.ReindexerConfig) {
+		c.ExtractMetadata = extract
+		c.Geocode = google.NewNullGeocoder()
+	})()
+
+	// assert
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result, app.ReindexResult{Listed: 1, Indexed: 1})
+	media, err := appconfig.NewMediaDetail(baseDir)(imported.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, media.Caption, "crossing at night")
+	assert.Equal(t, media.TriageState, app.TriageStateReviewed)
+	hashtags := []string{}
+	for _, c := range media.Collections {
+		if c.Type == app.CollectionTypeHashTag {
+			hashtags = append(hashtags, c.Title)
+		}
+	}
+	assert.DeepEqual(t, hashtags, []string{"tokyo"})
+}
+
+func TestReindexRestoresTrash(t *testing.T) {
+	// arrange
+	baseDir := t.TempDir()
+	extract := extractHash(time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC))
+	importMedia := appconfig.NewMediaImporter(baseDir,
+		appconfig.WithNullLogger(),
+		appconfig.WithNullGeocoder(),
+		appconfig.WithMetadataExtractor(extract),
+	)
+	ids := []string{}
+	for _, name := range []string{"trashed.jpg", "restored.jpg"} {
+		original := filepath.Join(t.TempDir(), name)
+		assert.NilError(t, os.WriteFile(original, []byte("the bytes of "+name), 0o600))
+		imported, err := importMedia(original)
+		assert.NilError(t, err)
+		assert.NilError(t, appconfig.NewDeleteMedia(baseDir)(imported.ID))
+		ids = append(ids, imported.ID)
+	}
+	assert.NilError(t, appconfig.NewRestoreMedia(baseDir)(ids[1]))
+	trashed, err := appconfig.NewMediaDetail(baseDir)(ids[0])
+	assert.NilError(t, err)
+
+	// act
+	result, err := appconfig.NewReindexer(baseDir, app.NewNullLogger(), func(c *app.ReindexerConfig) {
+		c.ExtractMetadata = extract
+		c.Geocode = google.NewNullGeocoder()
+	})()
+
+	// assert
+	assert.NilError(t, err)
+	assert.DeepEqual(t, result, app.ReindexResult{Listed: 2, Indexed: 2})
+	mediaDetail := appconfig.NewMediaDetail(baseDir)
+	reindexedTrash, err := mediaDetail(ids[0])
+	assert.NilError(t, err)
+	assert.Assert(t, reindexedTrash.DeletedAt != nil)
+	assert.Assert(t, reindexedTrash.DeletedAt.Equal(*trashed.DeletedAt))
+	restored, err := mediaDetail(ids[1])
+	assert.NilError(t, err)
+	assert.Assert(t, restored.DeletedAt == nil)
+}
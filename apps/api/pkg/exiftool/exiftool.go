@@ -56,7 +56,8 @@ func NewExtractor() app.MetadataExtractor {
 		mediaMetadata.CameraMake = cameraMake
 		mediaMetadata.Width = width
 		mediaMetadata.Height = height
-		mediaMetadata.Keywords = keywords
+		mediaMetadata.Keywords = strings.Join(keywords, ", ")
+		mediaMetadata.KeywordList = keywords
 		mediaMetadata.Title = title
 
 		return mediaMetadata, nil
@@ -136,12 +137,26 @@ func parseExt(fileInfo exiftoolz.FileMetadata) string {
 	return extVal
 }
 
-func parseKeywords(fileInfo exiftoolz.FileMetadata) string {
-	extVal, err := fileInfo.GetString("Keywords")
-	if err != nil {
-		return ""
+// parseKeywords collects IPTC Keywords, XMP Subject and Lightroom hierarchical
+// keywords eg: "Places|Japan|Tokyo", duplicates are dropped
+func parseKeywords(fileInfo exiftoolz.FileMetadata) []string {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, key := range []string{"Keywords", "Subject", "HierarchicalSubject"} {
+		values, err := fileInfo.GetStrings(key)
+		if err != nil {
+			continue
+		}
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if v == "" || seen[strings.ToLower(v)] {
+				continue
+			}
+			seen[strings.ToLower(v)] = true
+			out = append(out, v)
+		}
 	}
-	return extVal
+	return out
 }
 
 func parseTitle(fileInfo exiftoolz.FileMetadata) string {
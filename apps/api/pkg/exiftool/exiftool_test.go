@@ -61,6 +61,7 @@ var tests = []struct {
 			CameraMake:  "Samsung",
 			CameraModel: "GT-I9100",
 			Keywords:    "holiday",
+			KeywordList: []string{"holiday"},
 			Title:       "Ferry to Rotterdam",
 			Date:        time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
 		},
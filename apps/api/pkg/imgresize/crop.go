@@ -0,0 +1,139 @@
+package imgresize
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// saliencySampleSize is the longest side images are scaled to before
+// measuring detail, enough to find the subject and cheap to scan
+const saliencySampleSize = 64
+
+// fill scales and crops src to exactly width x height, centred on focus or,
+// without one, on the most detailed region
+func fill(src image.Image, width, height int, focus *app.FocusPoint) image.Image {
+	var crop image.Rectangle
+	if focus != nil {
+		crop = focusCrop(src.Bounds(), width, height, *focus)
+	} else {
+		crop = saliencyCrop(src, width, height)
+	}
+	return imaging.Resize(imaging.Crop(src, crop), width, height, imaging.Lanczos)
+}
+
+// cropSize is the largest width:height region that fits in bounds
+func cropSize(bounds image.Rectangle, width, height int) (int, int) {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w*height > h*width {
+		return h * width / height, h
+	}
+	return w, w * height / width
+}
+
+// focusCrop centres the crop on focus, moving it back inside bounds at the edges
+func focusCrop(bounds image.Rectangle, width, height int, focus app.FocusPoint) image.Rectangle {
+	cw, ch := cropSize(bounds, width, height)
+	x := int(math.Round(focus.X*float64(bounds.Dx()))) - cw/2
+	y := int(math.Round(focus.Y*float64(bounds.Dy()))) - ch/2
+	x = min(max(x, 0), bounds.Dx()-cw)
+	y = min(max(y, 0), bounds.Dy()-ch)
+	return image.Rect(x, y, x+cw, y+ch).Add(bounds.Min)
+}
+
+// saliencyCrop slides the crop along the longer side of src and keeps the
+// position with the most edge detail, ties go to the position nearest the centre
+func saliencyCrop(src image.Image, width, height int) image.Rectangle {
+	bounds := src.Bounds()
+	cw, ch := cropSize(bounds, width, height)
+	horizontal := cw < bounds.Dx()
+	if cw == bounds.Dx() && ch == bounds.Dy() {
+		return bounds
+	}
+
+	scale := math.Min(1, float64(saliencySampleSize)/float64(max(bounds.Dx(), bounds.Dy())))
+	sample := imaging.Grayscale(imaging.Resize(src,
+		max(1, int(math.Round(float64(bounds.Dx())*scale))),
+		max(1, int(math.Round(float64(bounds.Dy())*scale))),
+		imaging.Box))
+
+	energy := edgeEnergy(sample, horizontal)
+	length := float64(bounds.Dy())
+	window := float64(ch)
+	if horizontal {
+		length = float64(bounds.Dx())
+		window = float64(cw)
+	}
+	sampleWindow := max(1, int(math.Round(window*float64(len(energy))/length)))
+
+	best, bestScore, bestDistance := 0, -1.0, math.MaxInt
+	centre := (len(energy) - sampleWindow) / 2
+	score := 0.0
+	for i := 0; i < sampleWindow && i < len(energy); i++ {
+		score += energy[i]
+	}
+	for start := 0; start+sampleWindow <= len(energy); start++ {
+		if start > 0 {
+			score += energy[start+sampleWindow-1] - energy[start-1]
+		}
+		distance := abs(start - centre)
+		if score > bestScore || (score == bestScore && distance < bestDistance) {
+			best, bestScore, bestDistance = start, score, distance
+		}
+	}
+
+	offset := int(math.Round(float64(best) * length / float64(len(energy))))
+	if horizontal {
+		offset = min(offset, bounds.Dx()-cw)
+		return image.Rect(offset, 0, offset+cw, ch).Add(bounds.Min)
+	}
+	offset = min(offset, bounds.Dy()-ch)
+	return image.Rect(0, offset, cw, offset+ch).Add(bounds.Min)
+}
+
+// edgeEnergy sums the luminance gradient of each column, or each row when
+// horizontal is false
+func edgeEnergy(img *image.NRGBA, horizontal bool) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	lum := func(x, y int) float64 {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		return float64(img.Pix[y*img.Stride+x*4])
+	}
+
+	size := h
+	if horizontal {
+		size = w
+	}
+	out := make([]float64, size)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gradient := math.Abs(lum(x+1, y)-lum(x-1, y)) + math.Abs(lum(x, y+1)-lum(x, y-1))
+			if horizontal {
+				out[x] += gradient
+			} else {
+				out[y] += gradient
+			}
+		}
+	}
+	return out
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// focusSuffix is added to the keys of cropped thumbnails so moving the focus
+// point creates new keys
+func focusSuffix(focus *app.FocusPoint) string {
+	if focus == nil {
+		return ""
+	}
+	return fmt.Sprintf("-f%dx%d", int(math.Round(focus.X*100)), int(math.Round(focus.Y*100)))
+}
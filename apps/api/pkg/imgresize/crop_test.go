@@ -0,0 +1,128 @@
+package imgresize_test
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/imgresize"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
+	"gotest.tools/v3/assert"
+)
+
+// newTestImage is a flat grey image with a checked square, the only detail, at x, y
+func newTestImage(t *testing.T, width, height, x, y int) string {
+	t.Helper()
+	img := imaging.New(width, height, color.Gray{Y: 128})
+	for py := y; py < y+40; py++ {
+		for px := x; px < x+40; px++ {
+			if (px/5+py/5)%2 == 0 {
+				img.Set(px, py, color.Black)
+			} else {
+				img.Set(px, py, color.White)
+			}
+		}
+	}
+	path := filepath.Join(t.TempDir(), "original.png")
+	assert.NilError(t, imaging.Save(img, path))
+	return path
+}
+
+// checkedAt reports whether the thumbnail has detail around x, y
+func checkedAt(img image.Image, x, y int) bool {
+	seen := map[uint32]bool{}
+	for py := max(y-3, 0); py < min(y+3, img.Bounds().Dy()); py++ {
+		for px := max(x-3, 0); px < min(x+3, img.Bounds().Dx()); px++ {
+			r, _, _, _ := img.At(px, py).RGBA()
+			seen[r>>12] = true
+		}
+	}
+	return len(seen) > 2
+}
+
+func TestSquareCrop(t *testing.T) {
+	profiles := []app.ThumbnailProfile{
+		{Name: "sq", Width: 100, Height: 100, Crop: app.ThumbnailCropFill, Formats: []app.ThumbnailFormat{app.ThumbnailFormatJPEG}},
+	}
+	testCases := []struct {
+		desc        string
+		width       int
+		height      int
+		detailX     int
+		detailY     int
+		focus       *app.FocusPoint
+		expectedKey string
+		checkX      int
+		checkY      int
+	}{
+		{
+			desc:        "portraits keep detail near the top",
+			width:       300,
+			height:      900,
+			detailX:     130,
+			detailY:     20,
+			expectedKey: "sq_20230410_090000_hash.jpg",
+			checkX:      50,
+			checkY:      13,
+		},
+		{
+			desc:        "landscapes keep detail near the edge",
+			width:       900,
+			height:      300,
+			detailX:     840,
+			detailY:     130,
+			expectedKey: "sq_20230410_090000_hash.jpg",
+			checkX:      86,
+			checkY:      50,
+		},
+		{
+			desc:        "a focus point overrides the detail",
+			width:       900,
+			height:      300,
+			detailX:     840,
+			detailY:     130,
+			focus:       &app.FocusPoint{X: 0, Y: 0.5},
+			expectedKey: "sq_20230410_090000_hash-f0x50.jpg",
+			checkX:      50,
+			checkY:      50,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// arrange
+			original := newTestImage(t, tC.width, tC.height, tC.detailX, tC.detailY)
+			thumbnailsDir := t.TempDir()
+			resize := imgresize.NewProfileResizer(storage.NewLocalFSStore(thumbnailsDir), profiles)
+
+			// act
+			thumbnails, err := resize(original, "2023/20230410_090000_hash.jpg", app.ThumbnailOptions{FocusPoint: tC.focus})
+			assert.NilError(t, err)
+
+			// assert
+			key := thumbnails.Sizes["sq"].Sources[app.ThumbnailFormatJPEG]
+			assert.Equal(t, key, tC.expectedKey)
+			thumbnail, err := imaging.Open(filepath.Join(thumbnailsDir, key))
+			assert.NilError(t, err)
+			assert.Equal(t, checkedAt(thumbnail, tC.checkX, tC.checkY), tC.focus == nil)
+		})
+	}
+
+	t.Run("missing cropped thumbnails are recreated at their focus point", func(t *testing.T) {
+		original := newTestImage(t, 900, 300, 0, 130)
+		thumbnailsDir := t.TempDir()
+		createThumbnail := imgresize.NewThumbnailResizer(storage.NewLocalFSStore(thumbnailsDir), profiles)
+
+		err := createThumbnail(original, "sq_20230410_090000_hash-f0x50.jpg", app.ThumbnailOptions{FocusPoint: &app.FocusPoint{X: 0, Y: 0.5}})
+		assert.NilError(t, err)
+
+		thumbnail, err := imaging.Open(filepath.Join(thumbnailsDir, "sq_20230410_090000_hash-f0x50.jpg"))
+		assert.NilError(t, err)
+		assert.Assert(t, checkedAt(thumbnail, 10, 50))
+		_, err = os.Stat(filepath.Join(thumbnailsDir, "sq_20230410_090000_hash.jpg"))
+		assert.Assert(t, os.IsNotExist(err))
+	})
+}
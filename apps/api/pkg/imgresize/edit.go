@@ -0,0 +1,64 @@
+package imgresize
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// open reads an image, auto orients it and applies edits in order
+func open(path string, edits []app.Edit) (image.Image, error) {
+	img, err := imaging.Open(path, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	return ApplyEdits(img, edits), nil
+}
+
+// ApplyEdits returns img with edits applied in order, the edits must be valid
+func ApplyEdits(img image.Image, edits []app.Edit) image.Image {
+	for _, edit := range edits {
+		switch edit.Operation {
+		case app.EditRotate:
+			// imaging rotates counter-clockwise
+			switch edit.Degrees {
+			case 90:
+				img = imaging.Rotate270(img)
+			case 180:
+				img = imaging.Rotate180(img)
+			case 270:
+				img = imaging.Rotate90(img)
+			}
+		case app.EditFlip:
+			if edit.Direction == "vertical" {
+				img = imaging.FlipV(img)
+			} else {
+				img = imaging.FlipH(img)
+			}
+		case app.EditCrop:
+			b := img.Bounds()
+			w, h := float64(b.Dx()), float64(b.Dy())
+			r := edit.Rect
+			x0 := int(math.Round(r.X * w))
+			y0 := int(math.Round(r.Y * h))
+			x1 := max(x0+1, int(math.Round((r.X+r.Width)*w)))
+			y1 := max(y0+1, int(math.Round((r.Y+r.Height)*h)))
+			img = imaging.Crop(img, image.Rect(x0, y0, x1, y1).Add(b.Min))
+		}
+	}
+	return img
+}
+
+// editsSuffix identifies an edit list in thumbnail keys
+func editsSuffix(edits []app.Edit) string {
+	if len(edits) == 0 {
+		return ""
+	}
+	editsJSON, _ := json.Marshal(edits)
+	return fmt.Sprintf("-e%x", md5.Sum(editsJSON))[:10]
+}
@@ -0,0 +1,94 @@
+package imgresize_test
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/imgresize"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestApplyEdits(t *testing.T) {
+	// a 40x20 image, white on the left half
+	img := imaging.New(40, 20, color.Black)
+	img = imaging.Paste(img, imaging.New(20, 20, color.White), image.Pt(0, 0))
+
+	testCases := []struct {
+		desc           string
+		edits          []app.Edit
+		expectedWidth  int
+		expectedHeight int
+		whiteAt        image.Point
+	}{
+		{
+			desc:           "rotate 90 turns clockwise",
+			edits:          []app.Edit{{Operation: app.EditRotate, Degrees: 90}},
+			expectedWidth:  20,
+			expectedHeight: 40,
+			whiteAt:        image.Pt(10, 5),
+		},
+		{
+			desc:           "rotate 270 turns anti-clockwise",
+			edits:          []app.Edit{{Operation: app.EditRotate, Degrees: 270}},
+			expectedWidth:  20,
+			expectedHeight: 40,
+			whiteAt:        image.Pt(10, 35),
+		},
+		{
+			desc:           "flip horizontal mirrors left and right",
+			edits:          []app.Edit{{Operation: app.EditFlip, Direction: "horizontal"}},
+			expectedWidth:  40,
+			expectedHeight: 20,
+			whiteAt:        image.Pt(35, 10),
+		},
+		{
+			desc: "edits are applied in order",
+			edits: []app.Edit{
+				{Operation: app.EditCrop, Rect: &app.EditRect{X: 0.25, Y: 0, Width: 0.75, Height: 0.5}},
+				{Operation: app.EditRotate, Degrees: 180},
+			},
+			expectedWidth:  30,
+			expectedHeight: 10,
+			whiteAt:        image.Pt(25, 5),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// act
+			out := imgresize.ApplyEdits(img, tC.edits)
+
+			// assert
+			assert.Equal(t, out.Bounds().Dx(), tC.expectedWidth)
+			assert.Equal(t, out.Bounds().Dy(), tC.expectedHeight)
+			r, _, _, _ := out.At(tC.whiteAt.X, tC.whiteAt.Y).RGBA()
+			assert.Equal(t, r, uint32(0xffff))
+		})
+	}
+}
+
+func TestEditedThumbnails(t *testing.T) {
+	// arrange
+	original := filepath.Join(t.TempDir(), "original.jpg")
+	assert.NilError(t, imaging.Save(imaging.New(400, 300, color.Black), original))
+	resize := imgresize.NewProfileResizer(storage.NewLocalFSStore(t.TempDir()), []app.ThumbnailProfile{
+		{Name: "lg", Width: 200, Height: 200, Crop: app.ThumbnailCropFit, Formats: []app.ThumbnailFormat{app.ThumbnailFormatJPEG}},
+	})
+
+	// act
+	thumbnails, err := resize(original, "2023/20230410_090000_hash.jpg", app.ThumbnailOptions{
+		Edits: []app.Edit{{Operation: app.EditRotate, Degrees: 90}},
+	})
+	assert.NilError(t, err)
+
+	// assert
+	assert.Equal(t, thumbnails.Sizes["lg"].Width, 150)
+	assert.Equal(t, thumbnails.Sizes["lg"].Height, 200)
+	assert.Assert(t, strings.HasPrefix(thumbnails.Large, "lg_20230410_090000_hash-e"), thumbnails.Large)
+	assert.Assert(t, strings.HasSuffix(thumbnails.Large, ".jpg"), thumbnails.Large)
+}
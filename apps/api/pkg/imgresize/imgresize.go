@@ -2,8 +2,12 @@ package imgresize
 
 import (
 	"fmt"
+	"image"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/disintegration/imaging"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
@@ -19,67 +23,205 @@ const (
 	Landscape         = "l"
 )
 
-func NewResizer(baseDir string) app.Resizer {
-	err := os.MkdirAll(baseDir, 0o700)
-	if err != nil {
-		panic("failed to create thumbnails dir: " + err.Error())
-	}
+// Encoder writes img to path at quality
+type Encoder = func(img image.Image, path string, quality int) error
+
+// Encoders write each thumbnail format, WebP and AVIF use the cwebp and
+// avifenc commands from libwebp and libavif
+var Encoders = map[app.ThumbnailFormat]Encoder{
+	app.ThumbnailFormatJPEG: encodeJPEG,
+	app.ThumbnailFormatWebP: newCommandEncoder("cwebp", func(in, out string, quality int) []string {
+		return []string{"-quiet", "-q", strconv.Itoa(quality), in, "-o", out}
+	}),
+	app.ThumbnailFormatAVIF: newCommandEncoder("avifenc", func(in, out string, quality int) []string {
+		return []string{"-q", strconv.Itoa(quality), in, out}
+	}),
+}
 
-	return func(inPath, outPath string) (app.MediaSrc, error) {
-		thumbnails := app.MediaSrc{}
-		src, err := imaging.Open(inPath, imaging.AutoOrientation(true))
+func NewResizer(thumbnails app.Store) app.Resizer {
+	return NewProfileResizer(thumbnails, app.DefaultThumbnailProfiles)
+}
+
+// NewProfileResizer creates a thumbnail for every profile and format, the lg,
+// sqmd and sqsm JPEGs are also set as the Large, Medium and Small thumbnails.
+// The placeholder is computed from the edited original
+func NewProfileResizer(store app.Store, profiles []app.ThumbnailProfile) app.Resizer {
+	return func(inPath, outPath string, options app.ThumbnailOptions) (app.MediaSrc, error) {
+		thumbnails := app.MediaSrc{Sizes: map[string]app.ThumbnailSize{}}
+		src, err := open(inPath, options.Edits)
 		if err != nil {
 			return app.MediaSrc{}, err
 		}
+		thumbnails.ThumbnailPlaceholder = placeholder(src)
 
-		// figure out landscape or portrait
-		orientation := orientation(src.Bounds().Dx(), src.Bounds().Dy())
+		for _, profile := range profiles {
+			img := resize(src, profile, options.FocusPoint)
+			size := app.ThumbnailSize{
+				Width:   img.Bounds().Dx(),
+				Height:  img.Bounds().Dy(),
+				Sources: map[app.ThumbnailFormat]string{},
+			}
+			for _, format := range profile.Formats {
+				key := thumbnailKey(profile, format, outPath, options)
+				err = save(store, img, key, format, profile.Quality)
+				if err != nil {
+					return app.MediaSrc{}, fmt.Errorf("failed to create %s: %w", key, err)
+				}
+				size.Sources[format] = key
+			}
+			thumbnails.Sizes[profile.Name] = size
 
-		// -- create lg image
-		if orientation == Landscape {
-			src = imaging.Resize(src, ImgSizeLG, 0, imaging.Lanczos)
-		} else {
-			src = imaging.Resize(src, 0, ImgSizeLG, imaging.Lanczos)
+			jpeg := size.Sources[app.ThumbnailFormatJPEG]
+			switch profile.Name {
+			case ImgSizeLGPrefix:
+				thumbnails.Large = jpeg
+			case ImgSizeSQMDPrefix:
+				thumbnails.Medium = jpeg
+			case ImgSizeSQSMPrefix:
+				thumbnails.Small = jpeg
+			}
 		}
-		err = imaging.Save(src, filepath.Join(baseDir, generateFilename(ImgSizeLGPrefix, outPath)))
-		if err != nil {
-			return app.MediaSrc{}, err
+
+		return thumbnails, nil
+	}
+}
+
+// NewThumbnailResizer creates a single thumbnail, the profile is read from the
+// prefix of thumbnailKey and the format from its extension
+func NewThumbnailResizer(store app.Store, profiles []app.ThumbnailProfile) app.ThumbnailResizer {
+	return func(inPath, key string, options app.ThumbnailOptions) error {
+		name, _, _ := strings.Cut(key, "_")
+		format, ok := formatFromKey(key)
+		if !ok {
+			return fmt.Errorf("%w: unknown thumbnail format %s", app.ErrNotFound, key)
 		}
-		thumbnails.Large = generateFilename(ImgSizeLGPrefix, outPath)
+		for _, profile := range profiles {
+			if profile.Name != name {
+				continue
+			}
 
-		// -- create sqmd image
-		if orientation == Landscape {
-			src = imaging.Resize(src, 0, ImgSizeSQMD, imaging.Lanczos)
-		} else {
-			src = imaging.Resize(src, ImgSizeSQMD, 0, imaging.Lanczos)
+			src, err := open(inPath, options.Edits)
+			if err != nil {
+				return err
+			}
+			return save(store, resize(src, profile, options.FocusPoint), key, format, profile.Quality)
 		}
-		src = imaging.CropAnchor(src, ImgSizeSQMD, ImgSizeSQMD, imaging.Center)
-		err = imaging.Save(src, filepath.Join(baseDir, generateFilename("sqmd", outPath)))
+
+		return fmt.Errorf("%w: unknown thumbnail profile %s", app.ErrNotFound, key)
+	}
+}
+
+func resize(src image.Image, profile app.ThumbnailProfile, focus *app.FocusPoint) image.Image {
+	if profile.Crop == app.ThumbnailCropFill {
+		return fill(src, profile.Width, profile.Height, focus)
+	}
+	return imaging.Fit(src, profile.Width, profile.Height, imaging.Lanczos)
+}
+
+// save encodes img to a temp file and puts it in store
+func save(store app.Store, img image.Image, key string, format app.ThumbnailFormat, quality int) error {
+	encode, ok := Encoders[format]
+	if !ok {
+		return fmt.Errorf("no encoder for %s", format)
+	}
+	if quality == 0 {
+		quality = 90
+	}
+
+	tmpFile, err := os.CreateTemp("", "inari-*"+formatExt(format))
+	if err != nil {
+		return err
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	err = encode(img, tmpFile.Name(), quality)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := os.Open(tmpFile.Name())
+	if err != nil {
+		return err
+	}
+	defer encoded.Close()
+	return store.Put(key, encoded, "image/"+string(format))
+}
+
+// formatExt is the temp file extension for format, encoders such as cwebp
+// and avifenc expect the usual extension for their output
+func formatExt(format app.ThumbnailFormat) string {
+	if format == app.ThumbnailFormatJPEG {
+		return ".jpg"
+	}
+	return "." + string(format)
+}
+
+// encodeJPEG always writes a JPEG, rather than letting imaging pick the
+// format from path
+func encodeJPEG(img image.Image, path string, quality int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = imaging.Encode(f, img, imaging.JPEG, imaging.JPEGQuality(quality))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// newCommandEncoder saves img as a PNG and converts it with command
+func newCommandEncoder(command string, args func(in, out string, quality int) []string) Encoder {
+	return func(img image.Image, path string, quality int) error {
+		pngFile, err := os.CreateTemp(filepath.Dir(path), ".*.png")
 		if err != nil {
-			return app.MediaSrc{}, err
+			return err
 		}
-		thumbnails.Medium = generateFilename("sqmd", outPath)
+		pngFile.Close()
+		defer os.Remove(pngFile.Name())
 
-		// -- create sqsm image
-		src = imaging.Resize(src, ImgSizeSQSM, 0, imaging.Lanczos)
-		src = imaging.CropAnchor(src, ImgSizeSQSM, ImgSizeSQSM, imaging.Center)
-		err = imaging.Save(src, filepath.Join(baseDir, generateFilename("sqsm", outPath)))
+		err = imaging.Save(img, pngFile.Name())
 		if err != nil {
-			return app.MediaSrc{}, err
+			return err
 		}
-		thumbnails.Small = generateFilename("sqsm", outPath)
-
-		return thumbnails, nil
+		out, err := exec.Command(command, args(pngFile.Name(), path, quality)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s failed: %w: %s", command, err, out)
+		}
+		return nil
 	}
 }
 
-func generateFilename(prefix, originalImgFilename string) string {
-	return fmt.Sprintf("%s_%s", prefix, filepath.Base(originalImgFilename))
+// thumbnailKey names a thumbnail after its profile and original, JPEGs keep
+// the original's extension so existing keys do not change. Edits and focus
+// points are added as a suffix so changing them creates new keys
+func thumbnailKey(profile app.ThumbnailProfile, format app.ThumbnailFormat, originalImgFilename string, options app.ThumbnailOptions) string {
+	filename := filepath.Base(originalImgFilename)
+	ext := filepath.Ext(filename)
+	if format != app.ThumbnailFormatJPEG {
+		ext = "." + string(format)
+	}
+	suffix := editsSuffix(options.Edits)
+	if profile.Crop == app.ThumbnailCropFill {
+		suffix += focusSuffix(options.FocusPoint)
+	}
+	filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + suffix + ext
+	return generateFilename(profile.Name, filename)
 }
 
-func orientation(w, h int) string {
-	if w > h {
-		return "l"
+func formatFromKey(key string) (app.ThumbnailFormat, bool) {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".jpg", ".jpeg":
+		return app.ThumbnailFormatJPEG, true
+	case ".webp":
+		return app.ThumbnailFormatWebP, true
+	case ".avif":
+		return app.ThumbnailFormatAVIF, true
 	}
-	return "p"
+	return "", false
+}
+
+func generateFilename(prefix, originalImgFilename string) string {
+	return fmt.Sprintf("%s_%s", prefix, filepath.Base(originalImgFilename))
 }
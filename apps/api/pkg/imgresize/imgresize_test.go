@@ -0,0 +1,79 @@
+package imgresize_test
+
+import (
+	"image"
+	_ "image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/imgresize"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
+	"gotest.tools/v3/assert"
+)
+
+func TestProfileResizer(t *testing.T) {
+	// arrange
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original.jpg")
+	err := imaging.Save(imaging.New(400, 300, image.Black.C), original)
+	assert.NilError(t, err)
+	encodeWebP := imgresize.Encoders[app.ThumbnailFormatWebP]
+	t.Cleanup(func() { imgresize.Encoders[app.ThumbnailFormatWebP] = encodeWebP })
+	imgresize.Encoders[app.ThumbnailFormatWebP] = func(img image.Image, path string, quality int) error {
+		return os.WriteFile(path, []byte("webp"), 0o600)
+	}
+	thumbnailsDir := filepath.Join(dir, "thumbnails")
+	resize := imgresize.NewProfileResizer(storage.NewLocalFSStore(thumbnailsDir), []app.ThumbnailProfile{
+		{Name: "lg", Width: 200, Height: 200, Crop: app.ThumbnailCropFit, Formats: []app.ThumbnailFormat{app.ThumbnailFormatJPEG, app.ThumbnailFormatWebP}},
+		{Name: "sq", Width: 50, Height: 50, Crop: app.ThumbnailCropFill, Formats: []app.ThumbnailFormat{app.ThumbnailFormatJPEG}},
+	})
+
+	// act
+	thumbnails, err := resize(original, "2023/20230410_090000_hash.jpg", app.ThumbnailOptions{})
+	assert.NilError(t, err)
+
+	// assert
+	assert.DeepEqual(t, thumbnails, app.MediaSrc{
+		Large: "lg_20230410_090000_hash.jpg",
+		Sizes: map[string]app.ThumbnailSize{
+			"lg": {Width: 200, Height: 150, Sources: map[app.ThumbnailFormat]string{
+				app.ThumbnailFormatJPEG: "lg_20230410_090000_hash.jpg",
+				app.ThumbnailFormatWebP: "lg_20230410_090000_hash.webp",
+			}},
+			"sq": {Width: 50, Height: 50, Sources: map[app.ThumbnailFormat]string{
+				app.ThumbnailFormatJPEG: "sq_20230410_090000_hash.jpg",
+			}},
+		},
+		ThumbnailPlaceholder: app.ThumbnailPlaceholder{
+			BlurHash:       "L00000fQfQfQfQfQfQfQfQfQfQfQ",
+			DominantColour: "#000000",
+		},
+	})
+	for _, key := range thumbnails.Keys() {
+		_, err := os.Stat(filepath.Join(thumbnailsDir, key))
+		assert.NilError(t, err)
+	}
+	entries, err := os.ReadDir(thumbnailsDir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 3, "temp files are renamed")
+}
+
+func TestJPEGEncoderIgnoresExtension(t *testing.T) {
+	// arrange
+	path := filepath.Join(t.TempDir(), "thumbnail.png")
+
+	// act
+	err := imgresize.Encoders[app.ThumbnailFormatJPEG](imaging.New(40, 30, image.Black.C), path, 80)
+
+	// assert
+	assert.NilError(t, err)
+	f, err := os.Open(path)
+	assert.NilError(t, err)
+	defer f.Close()
+	_, format, err := image.DecodeConfig(f)
+	assert.NilError(t, err)
+	assert.Equal(t, format, "jpeg")
+}
@@ -0,0 +1,163 @@
+package imgresize
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+const (
+	// placeholderSampleSize is the longest side images are scaled to before
+	// computing placeholders, a blurred preview needs very few pixels
+	placeholderSampleSize = 32
+	blurHashXComponents   = 4
+	blurHashYComponents   = 3
+	base83Chars           = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// NewPlaceholderExtractor computes the placeholder of an existing thumbnail
+func NewPlaceholderExtractor() app.PlaceholderExtractor {
+	return func(r io.Reader) (app.ThumbnailPlaceholder, error) {
+		img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+		if err != nil {
+			return app.ThumbnailPlaceholder{}, err
+		}
+		return placeholder(img), nil
+	}
+}
+
+func placeholder(img image.Image) app.ThumbnailPlaceholder {
+	sample := imaging.Fit(img, placeholderSampleSize, placeholderSampleSize, imaging.Box)
+	return app.ThumbnailPlaceholder{
+		BlurHash:       blurHash(sample, blurHashXComponents, blurHashYComponents),
+		DominantColour: dominantColour(sample),
+	}
+}
+
+// blurHash encodes img as a BlurHash, see https://github.com/woltapp/blurhash
+func blurHash(img *image.NRGBA, xComponents, yComponents int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			factor := [3]float64{}
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					p := img.PixOffset(x, y)
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * sRGBToLinear(img.Pix[p+c])
+					}
+				}
+			}
+			scale := 1 / float64(width*height)
+			for c := range factor {
+				factor[c] *= scale
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	hash := strings.Builder{}
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maxValue := 1.0
+	ac := factors[1:]
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, factor := range ac {
+			for _, v := range factor {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(encode83(quantisedMax, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		quantised := 0
+		for _, v := range factor {
+			q := int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+			quantised = quantised*19 + q
+		}
+		hash.WriteString(encode83(quantised, 2))
+	}
+
+	return hash.String()
+}
+
+// dominantColour buckets pixels by their top 4 bits per channel and returns
+// the average colour of the fullest bucket as #rrggbb
+func dominantColour(img *image.NRGBA) string {
+	type bucket struct{ count, r, g, b int }
+	buckets := map[int]*bucket{}
+	var dominant *bucket
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			p := img.PixOffset(x, y)
+			r, g, b := int(img.Pix[p]), int(img.Pix[p+1]), int(img.Pix[p+2])
+			key := r>>4<<8 | g>>4<<4 | b>>4
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r += r
+			bk.g += g
+			bk.b += b
+			if dominant == nil || bk.count > dominant.count {
+				dominant = bk
+			}
+		}
+	}
+	if dominant == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", dominant.r/dominant.count, dominant.g/dominant.count, dominant.b/dominant.count)
+}
+
+func encode83(value, length int) string {
+	out := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		out[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(out)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
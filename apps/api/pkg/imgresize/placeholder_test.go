@@ -0,0 +1,61 @@
+package imgresize_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/imgresize"
+	"gotest.tools/v3/assert"
+)
+
+func encodePNG(t *testing.T, img image.Image) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	assert.NilError(t, imaging.Encode(buf, img, imaging.PNG))
+	return buf
+}
+
+func TestPlaceholderExtractor(t *testing.T) {
+	extractPlaceholder := imgresize.NewPlaceholderExtractor()
+
+	t.Run("it encodes a blurhash and dominant colour", func(t *testing.T) {
+		// arrange
+		img := imaging.New(40, 30, color.NRGBA{R: 255, A: 255})
+
+		// act
+		placeholder, err := extractPlaceholder(encodePNG(t, img))
+
+		// assert
+		assert.NilError(t, err)
+		assert.Equal(t, placeholder.BlurHash, "LDTI:j]9fQ]9|co1fQo1fQfQfQfQ")
+		assert.Equal(t, placeholder.DominantColour, "#ff0000")
+	})
+
+	t.Run("the dominant colour covers the most pixels", func(t *testing.T) {
+		// arrange
+		img := imaging.New(100, 100, color.NRGBA{B: 255, A: 255})
+		for y := 0; y < 100; y++ {
+			for x := 0; x < 30; x++ {
+				img.Set(x, y, color.NRGBA{R: 255, A: 255})
+			}
+		}
+
+		// act
+		placeholder, err := extractPlaceholder(encodePNG(t, img))
+
+		// assert
+		assert.NilError(t, err)
+		assert.Equal(t, len(placeholder.BlurHash), 28)
+		assert.Assert(t, strings.HasPrefix(placeholder.BlurHash, "L"))
+		assert.Equal(t, placeholder.DominantColour, "#0000ff")
+	})
+
+	t.Run("it rejects images it can not decode", func(t *testing.T) {
+		_, err := extractPlaceholder(strings.NewReader("not an image"))
+		assert.Assert(t, err != nil)
+	})
+}
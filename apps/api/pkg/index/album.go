@@ -0,0 +1,250 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+func migrateAlbums(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE collection ADD COLUMN description TEXT;`,
+		`ALTER TABLE collection ADD COLUMN cover_media_id TEXT;`,
+		`ALTER TABLE media_collection ADD COLUMN position INTEGER;`,
+		`CREATE INDEX IF NOT EXISTS
+		idx_media_collection_position ON media_collection (collection_id, position);`,
+	)
+}
+
+func NewSqliteAddMediaToAlbum(db *sql.DB) app.AlbumMediaUpdater {
+	return func(collectionID, mediaID string) error {
+		album, err := fetchAlbumByID(db, collectionID)
+		if err != nil {
+			return err
+		}
+		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(
+			`INSERT OR IGNORE INTO
+			media_collection (media_id, collection_id, position)
+			VALUES (?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM media_collection WHERE collection_id = ?));
+			`,
+			media.ID,
+			album.ID,
+			album.ID)
+		if err != nil {
+			return err
+		}
+
+		media = appendCollection(media, album)
+
+		return updateMediaDataByID(db, media)
+	}
+}
+
+func NewSqliteRemoveMediaFromAlbum(db *sql.DB) app.AlbumMediaUpdater {
+	return func(collectionID, mediaID string) error {
+		album, err := fetchAlbumByID(db, collectionID)
+		if err != nil {
+			return err
+		}
+		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if album.CoverMediaID == media.ID {
+			_, err = db.Exec(`UPDATE collection SET cover_media_id = NULL WHERE id = ?;`, album.ID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = removeMediaFromCollection(db, album.ID, media)
+		return err
+	}
+}
+
+// NewSqliteReorderAlbum moves mediaIDs to the start of the album in the given
+// order, media in the album that are not in mediaIDs keep their relative order after them
+func NewSqliteReorderAlbum(db *sql.DB) app.ReorderAlbum {
+	return func(collectionID string, mediaIDs []string) error {
+		album, err := fetchAlbumByID(db, collectionID)
+		if err != nil {
+			return err
+		}
+
+		currentIDs, err := fetchAlbumMediaIDs(db, album.ID)
+		if err != nil {
+			return err
+		}
+		isMember := map[string]bool{}
+		for _, id := range currentIDs {
+			isMember[id] = true
+		}
+
+		newOrder := []string{}
+		seen := map[string]bool{}
+		for _, id := range append(mediaIDs, currentIDs...) {
+			if !isMember[id] || seen[id] {
+				continue
+			}
+			seen[id] = true
+			newOrder = append(newOrder, id)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+		updateStmt, err := tx.Prepare(`UPDATE media_collection SET position = ? WHERE collection_id = ? AND media_id = ?;`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare statement: %w", err)
+		}
+		defer updateStmt.Close()
+		for i, id := range newOrder {
+			_, err = updateStmt.Exec(i+1, album.ID, id)
+			if err != nil {
+				return fmt.Errorf("failed to update album position: %w", err)
+			}
+		}
+
+		return tx.Commit()
+	}
+}
+
+func NewSqliteUpdateAlbum(db *sql.DB) app.UpdateAlbum {
+	return func(collectionID string, update app.AlbumUpdate) (app.Collection, error) {
+		album, err := fetchAlbumByID(db, collectionID)
+		if err != nil {
+			return album, err
+		}
+
+		if update.CoverMediaID != nil && *update.CoverMediaID != "" {
+			isMember := false
+			err = db.QueryRow(
+				`SELECT count(*) > 0 FROM media_collection WHERE collection_id = ? AND media_id = ?;`,
+				album.ID,
+				*update.CoverMediaID,
+			).Scan(&isMember)
+			if err != nil {
+				return album, err
+			}
+			if !isMember {
+				return album, fmt.Errorf("%w: media %s in album %s", app.ErrNotFound, *update.CoverMediaID, album.ID)
+			}
+		}
+
+		if update.Title != nil {
+			album.Title = *update.Title
+		}
+		if update.Description != nil {
+			album.Description = *update.Description
+		}
+		if update.CoverMediaID != nil {
+			album.CoverMediaID = *update.CoverMediaID
+		}
+
+		_, err = db.Exec(
+			`UPDATE collection SET title = ?, description = ?, cover_media_id = ? WHERE id = ?;`,
+			album.Title,
+			nullString(album.Description),
+			nullString(album.CoverMediaID),
+			album.ID)
+
+		return album, err
+	}
+}
+
+func listAlbums(db *sql.DB) ([]app.Collection, error) {
+	out := []app.Collection{}
+
+	rows, err := db.Query(albumSelect+`
+		WHERE c.collection_type = ?
+		GROUP BY c.id
+		ORDER BY c.id DESC;`,
+		app.CollectionTypeAlbum)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanAlbum(rows)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, c)
+	}
+
+	return out, rows.Err()
+}
+
+func fetchAlbumByID(db *sql.DB, collectionID string) (app.Collection, error) {
+	c, err := scanAlbum(db.QueryRow(albumSelect+`
+		WHERE c.id = ? AND c.collection_type = ?
+		GROUP BY c.id;`,
+		collectionID,
+		app.CollectionTypeAlbum))
+	if err == sql.ErrNoRows {
+		return c, fmt.Errorf("%w: album %s", app.ErrNotFound, collectionID)
+	}
+
+	return c, err
+}
+
+// albumSelect counts live media so that empty albums are still returned
+const albumSelect = `SELECT
+	c.id, c.collection_type, c.title,
+	COALESCE(c.description, ''), COALESCE(c.cover_media_id, ''),
+	count(media.id) as media_count, count(media.date_exported) as exported_count,
+	count(CASE WHEN media.triage_state = 'new' THEN 1 END) as inbox_count
+	FROM collection AS c
+	LEFT JOIN media_collection ON media_collection.collection_id = c.id
+	LEFT JOIN media ON media_collection.media_id = media.id AND media.date_deleted IS NULL`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAlbum(row scanner) (app.Collection, error) {
+	c := app.Collection{}
+	err := row.Scan(&c.ID, &c.Type, &c.Title, &c.Description, &c.CoverMediaID, &c.MediaCount, &c.ExportedCount, &c.InboxCount)
+	return c, err
+}
+
+func fetchAlbumMediaIDs(db *sql.DB, collectionID string) ([]string, error) {
+	out := []string{}
+
+	rows, err := db.Query(
+		`SELECT media_id FROM media_collection
+		WHERE collection_id = ?
+		ORDER BY position ASC, rowid ASC;`,
+		collectionID)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		id := ""
+		err = rows.Scan(&id)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, id)
+	}
+
+	return out, rows.Err()
+}
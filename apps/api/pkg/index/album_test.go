@@ -0,0 +1,117 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestAlbums(t *testing.T) {
+	t.Run("it adds, orders and removes album media", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		album, err := index.NewSqliteCreateCollection(db)("Japan trip", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		addMedia := index.NewSqliteAddMediaToAlbum(db)
+		removeMedia := index.NewSqliteRemoveMediaFromAlbum(db)
+		reorderAlbum := index.NewSqliteReorderAlbum(db)
+		collectionDetail := index.NewSqliteCollectionDetail(db)
+		getMedia := index.NewQueryMediaDetail(db)
+
+		// act
+		for _, id := range []string{"hash-tokyo", "hash-kyoto", "hash-leeds"} {
+			err = addMedia(album.ID, id)
+			assert.NilError(t, err)
+		}
+		err = reorderAlbum(album.ID, []string{"hash-leeds", "not-in-album"})
+		assert.NilError(t, err)
+		err = removeMedia(album.ID, "hash-tokyo")
+		assert.NilError(t, err)
+
+		// assert
+		detail, err := collectionDetail(album.ID)
+		assert.NilError(t, err)
+		actualIDs := []string{}
+		for _, m := range detail.Media {
+			actualIDs = append(actualIDs, m.ID)
+		}
+		assert.DeepEqual(t, actualIDs, []string{"hash-leeds", "hash-kyoto"})
+		assert.Equal(t, detail.CollectionMeta.MediaCount, 2)
+
+		kyoto, err := getMedia("hash-kyoto")
+		assert.NilError(t, err)
+		assert.Assert(t, hasCollection(kyoto, album.ID))
+		tokyo, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Assert(t, !hasCollection(tokyo, album.ID))
+	})
+
+	t.Run("empty albums are listed", func(t *testing.T) {
+		db := newTestLibrary(t)
+		album, err := index.NewSqliteCreateCollection(db)("Empty", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+
+		albums, err := index.NewSqliteCollectionLister(db)(app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, albums, []app.Collection{
+			{ID: album.ID, Title: "Empty", Type: app.CollectionTypeAlbum},
+		})
+
+		detail, err := index.NewSqliteCollectionDetail(db)(album.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, len(detail.Media), 0)
+	})
+
+	t.Run("it sets description and cover", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		album, err := index.NewSqliteCreateCollection(db)("Japan trip", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		addMedia := index.NewSqliteAddMediaToAlbum(db)
+		removeMedia := index.NewSqliteRemoveMediaFromAlbum(db)
+		updateAlbum := index.NewSqliteUpdateAlbum(db)
+		err = addMedia(album.ID, "hash-tokyo")
+		assert.NilError(t, err)
+
+		// act
+		description := "two weeks in spring"
+		cover := "hash-tokyo"
+		updated, err := updateAlbum(album.ID, app.AlbumUpdate{Description: &description, CoverMediaID: &cover})
+		assert.NilError(t, err)
+
+		notMember := "hash-leeds"
+		_, coverErr := updateAlbum(album.ID, app.AlbumUpdate{CoverMediaID: &notMember})
+
+		// assert
+		assert.Equal(t, updated.Description, description)
+		assert.Equal(t, updated.CoverMediaID, cover)
+		assert.Equal(t, updated.Title, "Japan trip")
+		assert.Assert(t, errors.Is(coverErr, app.ErrNotFound))
+
+		err = removeMedia(album.ID, "hash-tokyo")
+		assert.NilError(t, err)
+		detail, err := index.NewSqliteCollectionDetail(db)(album.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, detail.CollectionMeta.CoverMediaID, "")
+		assert.Equal(t, detail.CollectionMeta.Description, description)
+	})
+
+	t.Run("it only manages albums", func(t *testing.T) {
+		db := newTestLibrary(t)
+
+		err := index.NewSqliteAddMediaToAlbum(db)("camera__apple-iphone-12", "hash-leeds")
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+}
+
+func hasCollection(media app.Media, collectionID string) bool {
+	for _, c := range media.Collections {
+		if c.ID == collectionID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,247 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// bulkItemOperation applies a bulk operation to a single media inside the bulk transaction
+type bulkItemOperation = func(tx *sql.Tx, media app.Media) error
+
+// NewSqliteBulkMediaOperation applies an operation to many media in a single
+// transaction, if any media fails nothing is changed. Export uploads each media
+// to external storage so can not be rolled back, failed exports are reported
+// and the rest are still exported
+func NewSqliteBulkMediaOperation(db *sql.DB, export app.Exporter) app.BulkMediaOperation {
+	collectionDetail := NewSqliteCollectionDetail(db)
+
+	return func(request app.BulkRequest) (app.BulkResult, error) {
+		out := app.BulkResult{
+			Operation: request.Operation,
+			Results:   []app.BulkItemResult{},
+		}
+
+		applyToMedia, err := newBulkItemOperation(request)
+		if err != nil {
+			return out, err
+		}
+		if request.Operation == app.BulkOperationExport && export == nil {
+			return out, fmt.Errorf("%w: export is not available", app.ErrInvalidInput)
+		}
+		mediaIDs, err := resolveBulkMediaIDs(db, collectionDetail, request)
+		if err != nil {
+			return out, err
+		}
+
+		if request.Operation == app.BulkOperationExport {
+			out.Applied = true
+			for _, mediaID := range mediaIDs {
+				result := app.BulkItemResult{MediaID: mediaID}
+				err = export(mediaID)
+				if err != nil {
+					result.Error = err.Error()
+				}
+				out.Results = append(out.Results, result)
+			}
+			return out, nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return out, fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		failed := false
+		for _, mediaID := range mediaIDs {
+			result := app.BulkItemResult{MediaID: mediaID}
+			media, err := fetchMediaByID(tx, mediaID)
+			if err == sql.ErrNoRows {
+				err = fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+			}
+			if err == nil {
+				err = applyToMedia(tx, media)
+			}
+			if err != nil {
+				result.Error = err.Error()
+				failed = true
+			}
+			out.Results = append(out.Results, result)
+		}
+		if failed {
+			return out, nil
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return out, err
+		}
+		out.Applied = true
+
+		return out, nil
+	}
+}
+
+func newBulkItemOperation(request app.BulkRequest) (bulkItemOperation, error) {
+	switch request.Operation {
+	case app.BulkOperationDelete:
+		now := time.Now().Format(time.RFC3339Nano)
+		return func(tx *sql.Tx, media app.Media) error {
+			_, err := tx.Exec(`UPDATE media SET date_deleted = ? WHERE id = ?;`, now, media.ID)
+			return err
+		}, nil
+	case app.BulkOperationRestore:
+		return func(tx *sql.Tx, media app.Media) error {
+			_, err := tx.Exec(`UPDATE media SET date_deleted = NULL WHERE id = ?;`, media.ID)
+			return err
+		}, nil
+	case app.BulkOperationAddTag:
+		tag := normaliseHashtag(request.Value)
+		if tag == "" {
+			return nil, fmt.Errorf("%w: empty hashtag", app.ErrInvalidInput)
+		}
+		return func(tx *sql.Tx, media app.Media) error {
+			media, err := addMediaToCollection(tx, tag, app.CollectionTypeHashTag, tag, media)
+			if err != nil {
+				return err
+			}
+			return updateMediaDataByID(tx, media)
+		}, nil
+	case app.BulkOperationRemoveTag:
+		if normaliseHashtag(request.Value) == "" {
+			return nil, fmt.Errorf("%w: empty hashtag", app.ErrInvalidInput)
+		}
+		collectionID := hashtagCollectionID(request.Value)
+		return func(tx *sql.Tx, media app.Media) error {
+			_, err := removeMediaFromCollection(tx, collectionID, media)
+			return err
+		}, nil
+	case app.BulkOperationSetCaption:
+		return func(tx *sql.Tx, media app.Media) error {
+			media.Caption = request.Value
+			return updateMediaDataByID(tx, media)
+		}, nil
+	case app.BulkOperationSetLocation:
+		if request.Location == nil {
+			return nil, fmt.Errorf("%w: set_location requires a location", app.ErrInvalidInput)
+		}
+		return func(tx *sql.Tx, media app.Media) error {
+			media.Location = *request.Location
+			media, err := addMediaToPlaces(tx, media)
+			if err != nil {
+				return err
+			}
+			return updateMediaDataByID(tx, media)
+		}, nil
+	case app.BulkOperationExport:
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown operation %s", app.ErrInvalidInput, request.Operation)
+}
+
+// resolveBulkMediaIDs returns the media selected by a bulk request, in collection or query order.
+// Queries and collection pages leave out deleted media, so restore selects the
+// deleted members of a collection and can not use a query
+func resolveBulkMediaIDs(db *sql.DB, collectionDetail app.CollectionDetailQuery, request app.BulkRequest) ([]string, error) {
+	selectors := 0
+	for _, isSet := range []bool{len(request.MediaIDs) > 0, request.CollectionID != "", request.Query != ""} {
+		if isSet {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return nil, fmt.Errorf("%w: select media with exactly one of media_ids, collection_id or query", app.ErrInvalidInput)
+	}
+
+	out := []string{}
+	if request.Operation == app.BulkOperationRestore && request.Query != "" {
+		return out, fmt.Errorf("%w: restore media with media_ids or collection_id", app.ErrInvalidInput)
+	}
+	switch {
+	case request.Operation == app.BulkOperationRestore && request.CollectionID != "":
+		return fetchDeletedMemberIDs(db, request.CollectionID)
+	case request.CollectionID != "":
+		detail, err := collectionDetail(request.CollectionID)
+		if err != nil {
+			return out, err
+		}
+		for _, m := range detail.Media {
+			out = append(out, m.ID)
+		}
+		return out, nil
+	case request.Query != "":
+		where, args, err := compileMediaQuery(request.Query)
+		if err != nil {
+			return out, err
+		}
+		orderBy, _ := mediaSortOrder(app.MediaSortDateDesc)
+		rows, err := db.Query(`SELECT media.id FROM media WHERE `+where+` ORDER BY `+orderBy+`;`, args...)
+		if err != nil {
+			return out, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			id := ""
+			err = rows.Scan(&id)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, id)
+		}
+		return out, rows.Err()
+	}
+
+	seen := map[string]bool{}
+	for _, id := range request.MediaIDs {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+// fetchDeletedMemberIDs returns the deleted media of a collection in collection
+// order, smart collections have no members
+func fetchDeletedMemberIDs(db *sql.DB, collectionID string) ([]string, error) {
+	out := []string{}
+	src, err := fetchCollectionSource(db, collectionID)
+	if err != nil {
+		return out, err
+	}
+	if src.meta.Type == app.CollectionTypeSmart {
+		return out, fmt.Errorf("%w: smart collections can not be restored, use media_ids", app.ErrInvalidInput)
+	}
+	sortName, err := src.sort("")
+	if err != nil {
+		return out, err
+	}
+	sort, err := newCollectionSort(sortName)
+	if err != nil {
+		return out, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf(`SELECT media.id
+		FROM media
+		INNER JOIN media_collection ON media_collection.media_id = media.id
+		WHERE media_collection.collection_id = ? AND media.date_deleted IS NOT NULL
+		ORDER BY %s;`, sort.orderBy(false)),
+		collectionID)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		id := ""
+		err = rows.Scan(&id)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
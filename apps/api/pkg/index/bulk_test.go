@@ -0,0 +1,160 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestBulkMediaOperation(t *testing.T) {
+	t.Run("it tags media selected by a query", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+
+		// act
+		result, err := bulk(app.BulkRequest{
+			Operation: app.BulkOperationAddTag,
+			Query:     "country:japan",
+			Value:     "Japan2023",
+		})
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, result, app.BulkResult{
+			Operation: app.BulkOperationAddTag,
+			Applied:   true,
+			Results: []app.BulkItemResult{
+				{MediaID: "hash-kyoto"},
+				{MediaID: "hash-tokyo"},
+			},
+		})
+		page, err := index.NewSqliteMediaLister(db)(app.MediaQuery{Query: "tag:japan2023"})
+		assert.NilError(t, err)
+		assert.Equal(t, page.Total, 2)
+	})
+
+	t.Run("it rolls back when any media fails", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+
+		// act
+		result, err := bulk(app.BulkRequest{
+			Operation: app.BulkOperationSetCaption,
+			MediaIDs:  []string{"hash-tokyo", "missing", "hash-tokyo"},
+			Value:     "new caption",
+		})
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, result.Applied, false)
+		assert.Equal(t, len(result.Results), 2)
+		assert.Equal(t, result.Results[0].Error, "")
+		assert.Equal(t, result.Results[1].Error, "not found: media missing")
+		tokyo, err := index.NewQueryMediaDetail(db)("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Assert(t, tokyo.Caption != "new caption")
+	})
+
+	t.Run("deleted media can be restored", func(t *testing.T) {
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+		listMedia := index.NewSqliteMediaLister(db)
+
+		_, err := bulk(app.BulkRequest{Operation: app.BulkOperationDelete, CollectionID: "timeline_month__2023-04"})
+		assert.NilError(t, err)
+		deleted, err := listMedia(app.MediaQuery{})
+		assert.NilError(t, err)
+		_, err = bulk(app.BulkRequest{Operation: app.BulkOperationRestore, MediaIDs: []string{"hash-tokyo"}})
+		assert.NilError(t, err)
+		restored, err := listMedia(app.MediaQuery{})
+		assert.NilError(t, err)
+
+		assert.Equal(t, deleted.Total, 1)
+		assert.Equal(t, restored.Total, 2)
+	})
+
+	t.Run("deleted media can be restored by collection", func(t *testing.T) {
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+		listMedia := index.NewSqliteMediaLister(db)
+
+		_, err := bulk(app.BulkRequest{Operation: app.BulkOperationDelete, CollectionID: "timeline_month__2023-04"})
+		assert.NilError(t, err)
+		result, err := bulk(app.BulkRequest{Operation: app.BulkOperationRestore, CollectionID: "timeline_month__2023-04"})
+		assert.NilError(t, err)
+		restored, err := listMedia(app.MediaQuery{})
+		assert.NilError(t, err)
+
+		assert.Assert(t, result.Applied)
+		assert.DeepEqual(t, result.Results, []app.BulkItemResult{
+			{MediaID: "hash-kyoto"},
+			{MediaID: "hash-tokyo"},
+		})
+		assert.Equal(t, restored.Total, 3)
+	})
+
+	t.Run("it sets location and place collections", func(t *testing.T) {
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+
+		result, err := bulk(app.BulkRequest{
+			Operation: app.BulkOperationSetLocation,
+			MediaIDs:  []string{"hash-leeds"},
+			Location: &app.Location{
+				Country:     app.Country{Long: "United Kingdom", Short: "GB"},
+				Region:      "England",
+				Locality:    "York",
+				Coordinates: app.Coordinates{Lat: 53.96, Lng: -1.08},
+			},
+		})
+		assert.NilError(t, err)
+		assert.Assert(t, result.Applied)
+
+		page, err := index.NewSqliteMediaLister(db)(app.MediaQuery{Query: "city:york collection:places_region__england-united-kingdom"})
+		assert.NilError(t, err)
+		assert.Equal(t, page.Total, 1)
+	})
+
+	t.Run("exports are reported per media", func(t *testing.T) {
+		db := newTestLibrary(t)
+		exported := []string{}
+		bulk := index.NewSqliteBulkMediaOperation(db, func(mediaID string) error {
+			if mediaID == "hash-kyoto" {
+				return errors.New("upload failed")
+			}
+			exported = append(exported, mediaID)
+			return nil
+		})
+
+		result, err := bulk(app.BulkRequest{Operation: app.BulkOperationExport, MediaIDs: []string{"hash-kyoto", "hash-leeds"}})
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, result.Results, []app.BulkItemResult{
+			{MediaID: "hash-kyoto", Error: "upload failed"},
+			{MediaID: "hash-leeds"},
+		})
+		assert.DeepEqual(t, exported, []string{"hash-leeds"})
+	})
+
+	t.Run("it rejects invalid requests", func(t *testing.T) {
+		db := newTestLibrary(t)
+		bulk := index.NewSqliteBulkMediaOperation(db, nil)
+
+		for _, request := range []app.BulkRequest{
+			{Operation: "rotate", MediaIDs: []string{"hash-tokyo"}},
+			{Operation: app.BulkOperationAddTag, MediaIDs: []string{"hash-tokyo"}},
+			{Operation: app.BulkOperationDelete},
+			{Operation: app.BulkOperationDelete, MediaIDs: []string{"hash-tokyo"}, Query: "has:gps"},
+			{Operation: app.BulkOperationExport, MediaIDs: []string{"hash-tokyo"}},
+			{Operation: app.BulkOperationRestore, Query: "has:gps"},
+		} {
+			_, err := bulk(request)
+			assert.Assert(t, errors.Is(err, app.ErrInvalidInput), request)
+		}
+	})
+}
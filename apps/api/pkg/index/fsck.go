@@ -0,0 +1,114 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// NewSqliteListDanglingMemberships lists media_collection rows whose media or
+// collection no longer exists
+func NewSqliteListDanglingMemberships(db *sql.DB) app.MembershipLister {
+	return func() ([]app.CollectionMembership, error) {
+		out := []app.CollectionMembership{}
+		rows, err := db.Query(`SELECT mc.collection_id, mc.media_id
+			FROM media_collection AS mc
+			LEFT JOIN media ON media.id = mc.media_id
+			LEFT JOIN collection ON collection.id = mc.collection_id
+			WHERE media.id IS NULL OR collection.id IS NULL
+			ORDER BY mc.collection_id, mc.media_id;`)
+		if err != nil {
+			return out, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			m := app.CollectionMembership{}
+			err = rows.Scan(&m.CollectionID, &m.MediaID)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, m)
+		}
+
+		return out, rows.Err()
+	}
+}
+
+func NewSqliteRemoveMembership(db *sql.DB) app.MembershipRemover {
+	return func(membership app.CollectionMembership) error {
+		_, err := db.Exec(`DELETE FROM media_collection WHERE collection_id = ? AND media_id = ?;`,
+			membership.CollectionID,
+			membership.MediaID,
+		)
+		return err
+	}
+}
+
+// NewSqliteListEmptyCollections lists collections without live media, albums
+// and smart collections may be empty and are left out. MediaCount is the
+// number of trashed media still in the collection
+func NewSqliteListEmptyCollections(db *sql.DB) app.EmptyCollectionLister {
+	return func() ([]app.Collection, error) {
+		out := []app.Collection{}
+		rows, err := db.Query(`SELECT c.id, c.collection_type, c.title,
+			count(media.id) AS trashed_count
+			FROM collection AS c
+			LEFT JOIN media_collection ON media_collection.collection_id = c.id
+			LEFT JOIN media ON media_collection.media_id = media.id
+			WHERE c.collection_type NOT IN (?, ?)
+			GROUP BY c.id
+			HAVING count(CASE WHEN media.date_deleted IS NULL THEN media.id END) = 0
+			ORDER BY c.id;`,
+			app.CollectionTypeAlbum,
+			app.CollectionTypeSmart,
+		)
+		if err != nil {
+			return out, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			c := app.Collection{}
+			title := sql.NullString{}
+			err = rows.Scan(&c.ID, &c.Type, &title, &c.MediaCount)
+			if err != nil {
+				return out, err
+			}
+			c.Title = title.String
+			out = append(out, c)
+		}
+
+		return out, rows.Err()
+	}
+}
+
+// NewSqliteDeleteCollection removes a collection and its memberships
+func NewSqliteDeleteCollection(db *sql.DB) app.DeleteCollection {
+	return func(collectionID string) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`DELETE FROM collection WHERE id = ?;`, collectionID)
+		if err != nil {
+			return err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return fmt.Errorf("%w: collection %s", app.ErrNotFound, collectionID)
+		}
+		_, err = tx.Exec(`DELETE FROM media_collection WHERE collection_id = ?;`, collectionID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
@@ -0,0 +1,83 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestFsckQueries(t *testing.T) {
+	t.Run("it lists and removes dangling memberships", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		_, err := db.Exec(`INSERT INTO media_collection (collection_id, media_id) VALUES
+			('hashtag__hiking', 'purged-media'),
+			('missing-collection', 'hash-tokyo');`)
+		assert.NilError(t, err)
+		listDangling := index.NewSqliteListDanglingMemberships(db)
+
+		// act
+		dangling, err := listDangling()
+		assert.NilError(t, err)
+		for _, membership := range dangling {
+			assert.NilError(t, index.NewSqliteRemoveMembership(db)(membership))
+		}
+		remaining, err := listDangling()
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, dangling, []app.CollectionMembership{
+			{CollectionID: "hashtag__hiking", MediaID: "purged-media"},
+			{CollectionID: "missing-collection", MediaID: "hash-tokyo"},
+		})
+		assert.Equal(t, len(remaining), 0)
+	})
+
+	t.Run("it lists collections without live media", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		_, err := db.Exec(`INSERT INTO collection (id, collection_type, title) VALUES ('hashtag__old', 'hashtag', 'old');`)
+		assert.NilError(t, err)
+		_, err = index.NewSqliteCreateCollection(db)("empty trip", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		assert.NilError(t, index.NewDeleteMedia(db)("hash-leeds"))
+
+		// act
+		empty, err := index.NewSqliteListEmptyCollections(db)()
+
+		// assert
+		assert.NilError(t, err)
+		trashedCollections := 0
+		for _, c := range empty {
+			assert.Assert(t, c.Type != app.CollectionTypeAlbum)
+			if c.ID == "hashtag__old" {
+				assert.Equal(t, c.MediaCount, 0)
+				continue
+			}
+			assert.Equal(t, c.MediaCount, 1, c.ID)
+			trashedCollections++
+		}
+		assert.Assert(t, trashedCollections > 0, "collections of trashed media are listed")
+		assert.Equal(t, len(empty), trashedCollections+1)
+	})
+
+	t.Run("it deletes collections", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		deleteCollection := index.NewSqliteDeleteCollection(db)
+
+		// act
+		err := deleteCollection("hashtag__hiking")
+		assert.NilError(t, err)
+		missing := deleteCollection("hashtag__hiking")
+
+		// assert
+		assert.Assert(t, errors.Is(missing, app.ErrNotFound))
+		dangling, err := index.NewSqliteListDanglingMemberships(db)()
+		assert.NilError(t, err)
+		assert.Equal(t, len(dangling), 0, "memberships are deleted with the collection")
+	})
+}
@@ -0,0 +1,277 @@
+package index
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gosimple/slug"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// normaliseHashtag trims whitespace and a leading # and lowercases tag,
+// so "#Hiking" and "hiking" are the same hashtag
+func normaliseHashtag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "#")))
+}
+
+func hashtagCollectionID(tag string) string {
+	return slug.Make(fmt.Sprintf("%s__%s", app.CollectionTypeHashTag, normaliseHashtag(tag)))
+}
+
+func NewSqliteRemoveMediaTag(db *sql.DB) app.UpdateMediaTextProperty {
+	return func(mediaID, tag string) error {
+		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = removeMediaFromCollection(db, hashtagCollectionID(tag), media)
+		return err
+	}
+}
+
+// NewSqliteImportKeywords tags existing media with hashtags from their embedded
+// keywords, it returns the number of media that gained hashtags
+func NewSqliteImportKeywords(db *sql.DB, keywordRules app.KeywordRules) app.ImportKeywords {
+	return func() (int, error) {
+		tagged := 0
+
+		rows, err := db.Query(`SELECT ` + mediaColumns + ` FROM media WHERE media.date_deleted IS NULL;`)
+		if err != nil {
+			return tagged, err
+		}
+		allMedia, err := scanMediaRows(rows)
+		if err != nil {
+			return tagged, err
+		}
+
+		for _, media := range allMedia {
+			collectionCount := len(media.Collections)
+			media, err = addKeywordHashtags(db, keywordRules, media)
+			if err != nil {
+				return tagged, fmt.Errorf("failed to add keywords to media %s: %w", media.ID, err)
+			}
+			if len(media.Collections) == collectionCount {
+				continue
+			}
+
+			err = updateMediaDataByID(db, media)
+			if err != nil {
+				return tagged, err
+			}
+			tagged++
+		}
+
+		return tagged, nil
+	}
+}
+
+func addKeywordHashtags(db *sql.DB, keywordRules app.KeywordRules, media app.Media) (app.Media, error) {
+	var err error
+	for _, tag := range keywordRules.Hashtags(media.ParsedKeywords()) {
+		tag = normaliseHashtag(tag)
+		if tag == "" {
+			continue
+		}
+		media, err = addMediaToCollection(db, tag, app.CollectionTypeHashTag, tag, media)
+		if err != nil {
+			return media, err
+		}
+	}
+
+	return media, nil
+}
+
+// NewSqliteListHashtags lists every hashtag with its usage count, most used first
+func NewSqliteListHashtags(db *sql.DB) app.HashtagLister {
+	return func() ([]app.Collection, error) {
+		out := []app.Collection{}
+
+		q := `SELECT
+			c.id, c.collection_type, c.title, count(media.id) as media_count, count(media.date_exported) as exported_count,
+			count(CASE WHEN media.triage_state = 'new' THEN 1 END) as inbox_count
+			FROM collection AS c
+			LEFT JOIN media_collection ON media_collection.collection_id = c.id
+			LEFT JOIN media ON media_collection.media_id = media.id AND media.date_deleted IS NULL
+			WHERE c.collection_type = ?
+			GROUP BY c.id
+			ORDER BY media_count DESC, c.title ASC;
+			`
+		rows, err := db.Query(q, app.CollectionTypeHashTag)
+		if err != nil {
+			return out, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			c := app.Collection{}
+			err = rows.Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount, &c.InboxCount)
+			if err != nil {
+				return out, err
+			}
+			out = append(out, c)
+		}
+
+		return out, rows.Err()
+	}
+}
+
+// NewSqliteRenameHashtag renames the hashtag from to to across the whole library,
+// if to already exists the two hashtags are merged
+func NewSqliteRenameHashtag(db *sql.DB) app.RenameHashtag {
+	return func(from, to string) (app.Collection, error) {
+		return renameHashtag(db, hashtagCollectionID(from), to)
+	}
+}
+
+// NewSqliteNormaliseHashtags rewrites every hashtag in its normalised form,
+// hashtags that only differed by case or a leading # are merged
+func NewSqliteNormaliseHashtags(db *sql.DB) app.NormaliseHashtags {
+	listHashtags := NewSqliteListHashtags(db)
+
+	return func() ([]app.Collection, error) {
+		hashtags, err := listHashtags()
+		if err != nil {
+			return hashtags, err
+		}
+
+		for _, c := range hashtags {
+			// hashtags merged earlier in the loop no longer exist
+			_, err = renameHashtag(db, c.ID, c.Title)
+			if err != nil && !errors.Is(err, app.ErrNotFound) {
+				return hashtags, fmt.Errorf("failed to normalise hashtag %s: %w", c.ID, err)
+			}
+		}
+
+		return listHashtags()
+	}
+}
+
+// renameHashtag moves all media from the hashtag fromID to the hashtag
+// titled to, rewriting media_collection and each media's collections
+func renameHashtag(db *sql.DB, fromID, to string) (app.Collection, error) {
+	to = normaliseHashtag(to)
+	if to == "" {
+		return app.Collection{}, fmt.Errorf("%w: empty hashtag", app.ErrInvalidInput)
+	}
+	collectionType, err := fetchCollectionType(db, fromID)
+	if err != nil {
+		return app.Collection{}, err
+	}
+	if collectionType != app.CollectionTypeHashTag {
+		return app.Collection{}, fmt.Errorf("%w: hashtag %s", app.ErrNotFound, fromID)
+	}
+	toHashtag := app.Collection{
+		ID:    hashtagCollectionID(to),
+		Title: to,
+		Type:  app.CollectionTypeHashTag,
+	}
+
+	// media already tagged with to is rewritten too so its title stays in sync
+	allMedia, err := fetchHashtagMedia(db, fromID, toHashtag.ID)
+	if err != nil {
+		return toHashtag, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return toHashtag, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO collection (id, collection_type, title) VALUES (?,?,?)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title;`,
+		toHashtag.ID,
+		toHashtag.Type,
+		toHashtag.Title)
+	if err != nil {
+		return toHashtag, err
+	}
+
+	for _, media := range allMedia {
+		_, err = tx.Exec(
+			`INSERT OR IGNORE INTO media_collection (media_id, collection_id) VALUES (?,?);`,
+			media.ID,
+			toHashtag.ID)
+		if err != nil {
+			return toHashtag, err
+		}
+
+		collections := []app.Collection{}
+		for _, c := range media.Collections {
+			if c.ID != fromID && c.ID != toHashtag.ID {
+				collections = append(collections, c)
+			}
+		}
+		media.Collections = collections
+		media = appendCollection(media, toHashtag)
+
+		err = updateMediaDataByID(tx, media)
+		if err != nil {
+			return toHashtag, fmt.Errorf("failed to update media %s: %w", media.ID, err)
+		}
+	}
+
+	if fromID != toHashtag.ID {
+		_, err = tx.Exec(`DELETE FROM media_collection WHERE collection_id = ?;`, fromID)
+		if err != nil {
+			return toHashtag, err
+		}
+		_, err = tx.Exec(`DELETE FROM collection WHERE id = ?;`, fromID)
+		if err != nil {
+			return toHashtag, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return toHashtag, err
+	}
+
+	return fetchHashtagByID(db, toHashtag.ID)
+}
+
+// fetchHashtagMedia returns all media in any of collectionIDs, including deleted media
+func fetchHashtagMedia(db *sql.DB, collectionIDs ...string) ([]app.Media, error) {
+	args := []any{}
+	for _, id := range collectionIDs {
+		args = append(args, id)
+	}
+	q := `SELECT ` + mediaColumns + `
+			FROM media
+			WHERE media.id IN (
+				SELECT media_id FROM media_collection
+				WHERE collection_id IN (?` + strings.Repeat(",?", len(collectionIDs)-1) + `)
+			);
+			`
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMediaRows(rows)
+}
+
+func fetchHashtagByID(db *sql.DB, collectionID string) (app.Collection, error) {
+	c := app.Collection{}
+	err := db.QueryRow(`SELECT
+			c.id, c.collection_type, c.title, count(media.id) as media_count, count(media.date_exported) as exported_count,
+			count(CASE WHEN media.triage_state = 'new' THEN 1 END) as inbox_count
+			FROM collection AS c
+			LEFT JOIN media_collection ON media_collection.collection_id = c.id
+			LEFT JOIN media ON media_collection.media_id = media.id AND media.date_deleted IS NULL
+			WHERE c.id = ?
+			GROUP BY c.id;
+			`, collectionID).Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount, &c.InboxCount)
+	if err == sql.ErrNoRows {
+		return c, fmt.Errorf("%w: hashtag %s", app.ErrNotFound, collectionID)
+	}
+
+	return c, err
+}
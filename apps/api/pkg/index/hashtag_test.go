@@ -0,0 +1,125 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func hashtagTitles(media app.Media) []string {
+	out := []string{}
+	for _, c := range media.Collections {
+		if c.Type == app.CollectionTypeHashTag {
+			out = append(out, c.Title)
+		}
+	}
+	return out
+}
+
+func TestHashtags(t *testing.T) {
+	t.Run("tags are normalised when added and can be removed", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		addTag := index.NewUpdateMediaTag(db)
+		removeTag := index.NewSqliteRemoveMediaTag(db)
+		getMedia := index.NewQueryMediaDetail(db)
+
+		// act
+		err := addTag("hash-tokyo", " #Hiking ")
+		assert.NilError(t, err)
+		tokyo, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		err = removeTag("hash-kyoto", "HIKING")
+		assert.NilError(t, err)
+		kyoto, err := getMedia("hash-kyoto")
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, hashtagTitles(tokyo), []string{"hiking"})
+		assert.DeepEqual(t, hashtagTitles(kyoto), []string{})
+		page, err := index.NewSqliteMediaLister(db)(app.MediaQuery{Query: "tag:hiking"})
+		assert.NilError(t, err)
+		assert.Equal(t, page.Total, 1)
+		assert.Equal(t, page.Media[0].ID, "hash-tokyo")
+
+		err = addTag("hash-tokyo", "#")
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+		err = removeTag("missing", "hiking")
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+
+	t.Run("it lists hashtags by usage", func(t *testing.T) {
+		db := newTestLibrary(t)
+		addTag := index.NewUpdateMediaTag(db)
+		assert.NilError(t, addTag("hash-tokyo", "hiking"))
+		assert.NilError(t, addTag("hash-tokyo", "ferry"))
+		_, err := index.NewSqliteCreateCollection(db)("unused", app.CollectionTypeHashTag)
+		assert.NilError(t, err)
+
+		hashtags, err := index.NewSqliteListHashtags(db)()
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, hashtags, []app.Collection{
+			{ID: "hashtag__hiking", Title: "hiking", Type: app.CollectionTypeHashTag, MediaCount: 2, ExportedCount: 1, InboxCount: 2},
+			{ID: "hashtag__ferry", Title: "ferry", Type: app.CollectionTypeHashTag, MediaCount: 1, ExportedCount: 1, InboxCount: 1},
+			{ID: "hashtag__unused", Title: "unused", Type: app.CollectionTypeHashTag},
+		})
+	})
+
+	t.Run("renaming to an existing tag merges them", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		addTag := index.NewUpdateMediaTag(db)
+		renameHashtag := index.NewSqliteRenameHashtag(db)
+		getMedia := index.NewQueryMediaDetail(db)
+		assert.NilError(t, addTag("hash-tokyo", "walking"))
+		assert.NilError(t, addTag("hash-kyoto", "walking"))
+
+		// act
+		merged, err := renameHashtag("walking", "Hiking")
+		assert.NilError(t, err)
+		renamed, err := renameHashtag("hiking", "hillwalking")
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, merged, app.Collection{
+			ID: "hashtag__hiking", Title: "hiking", Type: app.CollectionTypeHashTag, MediaCount: 2, ExportedCount: 1, InboxCount: 2,
+		})
+		assert.Equal(t, renamed.ID, "hashtag__hillwalking")
+		assert.Equal(t, renamed.MediaCount, 2)
+		for _, id := range []string{"hash-tokyo", "hash-kyoto"} {
+			m, err := getMedia(id)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, hashtagTitles(m), []string{"hillwalking"})
+		}
+		hashtags, err := index.NewSqliteListHashtags(db)()
+		assert.NilError(t, err)
+		assert.Equal(t, len(hashtags), 1)
+		results, err := index.NewSqliteSearchMedia(db)("hillwalking")
+		assert.NilError(t, err)
+		assert.Equal(t, len(results), 2)
+
+		_, err = renameHashtag("walking", "hiking")
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+		_, err = renameHashtag("hillwalking", " ")
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+	})
+
+	t.Run("normalise lowercases existing tags", func(t *testing.T) {
+		db := newTestLibrary(t)
+		_, err := index.NewSqliteCreateCollection(db)("Ferry", app.CollectionTypeHashTag)
+		assert.NilError(t, err)
+
+		hashtags, err := index.NewSqliteNormaliseHashtags(db)()
+		assert.NilError(t, err)
+
+		titles := []string{}
+		for _, c := range hashtags {
+			titles = append(titles, c.Title)
+		}
+		assert.DeepEqual(t, titles, []string{"hiking", "ferry"})
+	})
+}
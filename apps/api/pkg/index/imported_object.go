@@ -0,0 +1,74 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+func migrateImportedObjects(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS
+		imported_object (
+			source TEXT NOT NULL,
+			object_key TEXT NOT NULL,
+			etag TEXT NOT NULL DEFAULT '',
+			size INTEGER NOT NULL,
+			mod_time INTEGER NOT NULL,
+			media_id TEXT NOT NULL DEFAULT '',
+			imported_at INTEGER NOT NULL,
+			PRIMARY KEY (source, object_key)
+		);`,
+	)
+}
+
+// NewSqliteQueryImportedObject returns the last import of an object from source
+func NewSqliteQueryImportedObject(db *sql.DB) app.ImportedObjectQuery {
+	return func(source, key string) (app.ImportedObject, error) {
+		object := app.ImportedObject{Source: source, Key: key}
+		modTime := int64(0)
+		importedAt := int64(0)
+		err := db.QueryRow(`SELECT etag, size, mod_time, media_id, imported_at
+			FROM imported_object
+			WHERE source = ? AND object_key = ?;`,
+			source,
+			key,
+		).Scan(&object.ETag, &object.Size, &modTime, &object.MediaID, &importedAt)
+		if err == sql.ErrNoRows {
+			return object, fmt.Errorf("%w: imported object %s %s", app.ErrNotFound, source, key)
+		}
+		if err != nil {
+			return object, err
+		}
+		object.ModTime = time.Unix(modTime, 0).UTC()
+		object.ImportedAt = time.Unix(importedAt, 0).UTC()
+
+		return object, nil
+	}
+}
+
+// NewSqliteSaveImportedObject records an imported object, replacing any
+// earlier import of the same key
+func NewSqliteSaveImportedObject(db *sql.DB) app.SaveImportedObject {
+	return func(object app.ImportedObject) error {
+		_, err := db.Exec(`INSERT INTO imported_object (source, object_key, etag, size, mod_time, media_id, imported_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (source, object_key) DO UPDATE SET
+				etag = excluded.etag,
+				size = excluded.size,
+				mod_time = excluded.mod_time,
+				media_id = excluded.media_id,
+				imported_at = excluded.imported_at;`,
+			object.Source,
+			object.Key,
+			object.ETag,
+			object.Size,
+			object.ModTime.Unix(),
+			object.MediaID,
+			object.ImportedAt.Unix(),
+		)
+		return err
+	}
+}
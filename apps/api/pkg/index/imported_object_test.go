@@ -0,0 +1,42 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestImportedObjects(t *testing.T) {
+	// arrange
+	db := newTestDB(t)
+	assert.NilError(t, index.CreateIndex(db))
+	fetchImported := index.NewSqliteQueryImportedObject(db)
+	saveImported := index.NewSqliteSaveImportedObject(db)
+	object := app.ImportedObject{
+		Source:     "s3://photos/backup/",
+		Key:        "backup/2023/tokyo.jpg",
+		ETag:       "5d41402abc4b2a76b9719d911017c592",
+		Size:       5,
+		ModTime:    time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC),
+		MediaID:    "hash-tokyo",
+		ImportedAt: time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	// act
+	_, missing := fetchImported(object.Source, object.Key)
+	assert.NilError(t, saveImported(object))
+	object.Size = 11
+	assert.NilError(t, saveImported(object))
+	saved, err := fetchImported(object.Source, object.Key)
+	assert.NilError(t, err)
+	_, otherSource := fetchImported("webdav://nas/photos/", object.Key)
+
+	// assert
+	assert.Assert(t, errors.Is(missing, app.ErrNotFound))
+	assert.DeepEqual(t, saved, object)
+	assert.Assert(t, errors.Is(otherSource, app.ErrNotFound))
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strconv"
 	"time"
 
@@ -12,81 +13,128 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// CreateIndex brings the index schema up to date by applying any pending migrations
 func CreateIndex(db *sql.DB) error {
-	q := `CREATE TABLE IF NOT EXISTS media (
-			id TEXT NOT NULL PRIMARY KEY,
-			date_created DATETIME NOT NULL,
-			date_deleted DATETIME,
-			date_exported DATETIME,
-			media_data TEXT
-		);`
-	if _, err := db.Exec(q); err != nil {
-		return err
-	}
+	_, err := Migrate(db)
+	return err
+}
 
-	q = `CREATE TABLE IF NOT EXISTS collection (
-			id TEXT NOT NULL PRIMARY KEY,
-			collection_type TEXT,
-			title TEXT
-		);
-		CREATE INDEX IF NOT EXISTS
-		idx_collection_type ON collection (collection_type);
-  `
-	if _, err := db.Exec(q); err != nil {
-		return err
+func NewQueryMediaDetail(db *sql.DB) app.QueryMediaDetail {
+	return func(mediaID string) (app.Media, error) {
+		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return media, fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		return media, err
 	}
+}
 
-	q = `CREATE TABLE IF NOT EXISTS
-		media_collection (
-			media_id TEXT NOT NULL,
-			collection_id TEXT NOT NULL
-		);
-		CREATE UNIQUE INDEX IF NOT EXISTS
-		idx_media_collections ON media_collection (media_id, collection_id);
-  `
-	if _, err := db.Exec(q); err != nil {
-		return err
+// NewSqliteMediaDetail returns a media and, when viewed in a collection, the
+// media either side of it in that collection's order
+func NewSqliteMediaDetail(db *sql.DB) app.MediaDetailQuery {
+	return func(mediaID string, context app.MediaContext) (app.MediaDetailView, error) {
+		out := app.MediaDetailView{CollectionID: context.CollectionID}
+
+		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return out, fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		if err != nil {
+			return out, err
+		}
+		out.Media = media
+
+		if context.CollectionID == "" {
+			return out, nil
+		}
+		out.PreviousID, out.NextID, err = fetchNeighbours(db, context.CollectionID, mediaID, context.Sort)
+
+		return out, err
 	}
+}
 
-	q = `CREATE TABLE IF NOT EXISTS
-		gpx (
-			timestamp TEXT NOT NULL,
-			lat TEXT NOT NULL,
-			lng TEXT NOT NULL
-		);
-		CREATE UNIQUE INDEX IF NOT EXISTS
-		idx_gpx ON gpx (timestamp, lat, lng);
-  `
-	if _, err := db.Exec(q); err != nil {
-		return err
+// NewSqliteListAllMedia lists all media that is not in the trash, oldest first
+func NewSqliteListAllMedia(db *sql.DB) app.AllMediaLister {
+	return func() ([]app.Media, error) {
+		rows, err := db.Query(`SELECT ` + mediaColumns + `
+			FROM media
+			WHERE media.date_deleted IS NULL
+			ORDER BY media.taken_at ASC, media.id ASC;
+			`)
+		if err != nil {
+			return []app.Media{}, err
+		}
+
+		return scanMediaRows(rows)
 	}
+}
 
-	return nil
+func NewSqliteUpdateThumbnails(db *sql.DB) app.UpdateThumbnails {
+	return func(mediaID string, thumbnails app.MediaSrc) error {
+		return saveThumbnails(db, mediaID, func(media *app.Media) error {
+			media.Thumbnails = thumbnails
+			return nil
+		})
+	}
 }
 
-func NewQueryMediaDetail(db *sql.DB) app.QueryMediaDetail {
-	return func(mediaID string) (app.Media, error) {
-		return fetchMediaByID(db, mediaID)
+// NewSqliteSaveJobThumbnails saves thumbnails created from options, when the
+// media's options have changed since the thumbnails are stale and not saved
+func NewSqliteSaveJobThumbnails(db *sql.DB) app.SaveJobThumbnails {
+	return func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+		return saveThumbnails(db, mediaID, func(media *app.Media) error {
+			if !reflect.DeepEqual(media.ThumbnailOptions, options) {
+				return fmt.Errorf("%w: thumbnail options of %s have changed", app.ErrConflict, mediaID)
+			}
+			media.Thumbnails = thumbnails
+			return nil
+		})
 	}
 }
 
-func fetchMediaByID(db *sql.DB, mediaID string) (app.Media, error) {
-	out := app.Media{}
+func NewSqliteSaveThumbnailOptions(db *sql.DB) app.SaveThumbnailOptions {
+	return func(mediaID string, options app.ThumbnailOptions, thumbnails app.MediaSrc) error {
+		return saveThumbnails(db, mediaID, func(media *app.Media) error {
+			media.ThumbnailOptions = options
+			media.Thumbnails = thumbnails
+			return nil
+		})
+	}
+}
 
-	q := `SELECT
-			media_data,
-			date_exported IS NOT NULL
+func fetchMediaByID(db queryer, mediaID string) (app.Media, error) {
+	q := `SELECT ` + mediaColumns + `
 			FROM media
 			WHERE id = ?;
 			`
-	row := db.QueryRow(q, mediaID)
+	return scanMedia(db.QueryRow(q, mediaID))
+}
+
+// mediaColumns are the columns read by scanMedia
+const mediaColumns = `media.media_data,
+			media.date_exported IS NOT NULL,
+			media.triage_state,
+			media.date_deleted`
 
+// scanMedia reads a row starting with mediaColumns, any extra columns are scanned into dest
+func scanMedia(row scanner, dest ...any) (app.Media, error) {
+	out := app.Media{}
 	jsonStr := ""
-	err := row.Scan(&jsonStr, &out.IsExported)
+	isExported := false
+	triageState := app.TriageStateNew
+	dateDeleted := sql.NullString{}
+	err := row.Scan(append([]any{&jsonStr, &isExported, &triageState, &dateDeleted}, dest...)...)
 	if err != nil {
 		return out, err
 	}
+
 	err = json.Unmarshal([]byte(jsonStr), &out)
+	out.IsExported = isExported
+	out.TriageState = triageState
+	out.DeletedAt = nil
+	if deletedAt, dErr := time.Parse(time.RFC3339Nano, dateDeleted.String); dateDeleted.Valid && dErr == nil {
+		out.DeletedAt = &deletedAt
+	}
 	out.FormattedDate = out.MediaMetadata.Date.Format(time.RFC3339Nano)
 	return out, err
 }
@@ -119,7 +167,14 @@ func NewExportMedia(db *sql.DB) app.DeleteMedia {
 
 func NewUpdateMediaTag(db *sql.DB) app.UpdateMediaTextProperty {
 	return func(mediaID, newTag string) error {
+		newTag = normaliseHashtag(newTag)
+		if newTag == "" {
+			return fmt.Errorf("%w: empty hashtag", app.ErrInvalidInput)
+		}
 		media, err := fetchMediaByID(db, mediaID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
 		if err != nil {
 			return err
 		}
@@ -152,31 +207,85 @@ func NewUpdateMediaCaption(db *sql.DB) app.UpdateMediaTextProperty {
 	}
 }
 
-func updateMediaDataByID(db *sql.DB, media app.Media) error {
+func updateMediaDataByID(db execer, media app.Media) error {
 	mediaData, err := json.Marshal(media)
 	if err != nil {
 		return err
 	}
 	_, err = db.Exec(
-		`UPDATE media SET media_data = ? WHERE id = ?;`,
-		string(mediaData),
-		media.ID)
+		`UPDATE media SET
+			media_data = ?,
+			taken_at = ?,
+			lat = ?,
+			lng = ?,
+			camera_make = ?,
+			camera_model = ?,
+			mime_type = ?,
+			width = ?,
+			height = ?,
+			caption = ?,
+			country = ?,
+			region = ?,
+			locality = ?,
+			taken_date = ?
+			WHERE id = ?;`,
+		append(append([]any{string(mediaData)}, mediaColumnValues(media)...), takenDate(media), media.ID)...)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return updateSearchIndex(db, media)
+}
+
+// mediaColumnValues returns the queryable columns denormalised from media_data, in the order
+// taken_at, lat, lng, camera_make, camera_model, mime_type, width, height, caption, country, region, locality
+func mediaColumnValues(media app.Media) []any {
+	coordinates := media.Location.Coordinates
+	if coordinates.Lat == 0 && coordinates.Lng == 0 {
+		coordinates = media.MediaMetadata.Coordinates
+	}
+	var lat, lng sql.NullFloat64
+	if coordinates.Lat != 0 || coordinates.Lng != 0 {
+		lat = sql.NullFloat64{Float64: coordinates.Lat, Valid: true}
+		lng = sql.NullFloat64{Float64: coordinates.Lng, Valid: true}
+	}
+
+	return []any{
+		media.Date.UTC().Format(time.RFC3339),
+		lat,
+		lng,
+		nullString(media.CameraMake),
+		nullString(media.CameraModel),
+		nullString(media.MimeType),
+		nullInt(media.Width),
+		nullInt(media.Height),
+		nullString(media.Caption),
+		nullString(media.Location.Country.Long),
+		nullString(media.Location.Region),
+		nullString(media.Location.Locality),
+	}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(s string) sql.NullInt64 {
+	i, err := strconv.ParseInt(s, 10, 64)
+	return sql.NullInt64{Int64: i, Valid: err == nil}
 }
 
 func NewSqliteIndexer(db *sql.DB) app.Indexer {
+	return NewSqliteKeywordIndexer(db, app.KeywordRules{})
+}
+
+// NewSqliteKeywordIndexer indexes media, embedded keywords become hashtags according to keywordRules
+func NewSqliteKeywordIndexer(db *sql.DB, keywordRules app.KeywordRules) app.Indexer {
 	return func(media app.Media) (app.Media, error) {
 		media.ID = media.Hash
 
 		// inbox
-		media, err := addMediaToCollection(
-			db,
-			media.Date.Format("2006-01"),
-			app.CollectionTypeInbox,
-			fmt.Sprintf("inbox %s", media.Date.Format("Jan 2006")),
-			media,
-		)
+		media, err := addMediaToInbox(db, media)
 		if err != nil {
 			return app.Media{}, err
 		}
@@ -217,65 +326,117 @@ func NewSqliteIndexer(db *sql.DB) app.Indexer {
 			return app.Media{}, err
 		}
 
-		if media.Location.Country.Long != "" {
-			// country
-			media, err = addMediaToCollection(
-				db,
-				media.Location.Country.Long,
-				app.CollectionTypePlacesCountry,
-				media.Location.Country.Long,
-				media,
-			)
-			if err != nil {
-				return app.Media{}, err
-			}
+		// places
+		media, err = addMediaToPlaces(db, media)
+		if err != nil {
+			return app.Media{}, err
 		}
-		if media.Location.Region != "" && media.Location.Country.Long != "" {
-			// region
-			media, err = addMediaToCollection(
-				db,
-				fmt.Sprintf("%s, %s", media.Location.Region, media.Location.Country.Long),
-				app.CollectionTypePlacesRegion,
-				fmt.Sprintf("%s, %s", media.Location.Region, media.Location.Country.Long),
-				media,
-			)
-			if err != nil {
-				return app.Media{}, err
-			}
+
+		// keywords
+		media, err = addKeywordHashtags(db, keywordRules, media)
+		if err != nil {
+			return app.Media{}, err
 		}
 
 		return InsertMedia(db, media)
 	}
 }
 
+// addMediaToPlaces adds media to the country and region collections of its location
+func addMediaToPlaces(db execer, media app.Media) (app.Media, error) {
+	var err error
+	if media.Location.Country.Long != "" {
+		// country
+		media, err = addMediaToCollection(
+			db,
+			media.Location.Country.Long,
+			app.CollectionTypePlacesCountry,
+			media.Location.Country.Long,
+			media,
+		)
+		if err != nil {
+			return media, err
+		}
+	}
+	if media.Location.Region != "" && media.Location.Country.Long != "" {
+		// region
+		media, err = addMediaToCollection(
+			db,
+			fmt.Sprintf("%s, %s", media.Location.Region, media.Location.Country.Long),
+			app.CollectionTypePlacesRegion,
+			fmt.Sprintf("%s, %s", media.Location.Region, media.Location.Country.Long),
+			media,
+		)
+		if err != nil {
+			return media, err
+		}
+	}
+
+	return media, nil
+}
+
+func addMediaToInbox(db *sql.DB, media app.Media) (app.Media, error) {
+	return addMediaToCollection(
+		db,
+		media.Date.Format("2006-01"),
+		app.CollectionTypeInbox,
+		fmt.Sprintf("inbox %s", media.Date.Format("Jan 2006")),
+		media,
+	)
+}
+
 func InsertMedia(db *sql.DB, media app.Media) (app.Media, error) {
 	mediaData, err := json.Marshal(media)
 	if err != nil {
 		return media, err
 	}
-	_, err = db.Exec(
+	res, err := db.Exec(
 		`INSERT OR IGNORE INTO
-			media (id, date_created, media_data)
-			VALUES (?,?,?);
+			media (
+				id, date_created, media_data,
+				taken_at, lat, lng, camera_make, camera_model, mime_type,
+				width, height, caption, country, region, locality,
+				taken_date
+			)
+			VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?);
 			`,
-		media.ID,
-		media.Date.Format(time.RFC3339),
-		string(mediaData))
+		append(append([]any{
+			media.ID,
+			media.Date.Format(time.RFC3339),
+			string(mediaData),
+		}, mediaColumnValues(media)...), takenDate(media))...)
+	if err != nil {
+		return media, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil || inserted == 0 {
+		return media, err
+	}
 
-	return media, err
+	return media, updateSearchIndex(db, media)
 }
 
 func NewSqliteCollectionLister(db *sql.DB) app.CollectionLister {
 	return func(collectionType app.CollectionType) ([]app.Collection, error) {
+		switch collectionType {
+		case app.CollectionTypeSmart:
+			return listSmartCollections(db)
+		case app.CollectionTypeAlbum:
+			return listAlbums(db)
+		}
+
 		out := []app.Collection{}
 
+		// inboxes are emptied as media is reviewed, empty ones are left out
 		q := `SELECT
-			c.id, c.collection_type, c.title, count(*) as media_count, count(media.date_exported) as exported_count
+			c.id, c.collection_type, c.title, count(media.id) as media_count, count(media.date_exported) as exported_count,
+			count(CASE WHEN media.triage_state = 'new' THEN 1 END) as inbox_count
 			FROM collection AS c
 			LEFT JOIN media_collection ON media_collection.collection_id = c.id
 			LEFT JOIN media ON media_collection.media_id = media.id
 			WHERE c.collection_type = ? AND media.date_deleted IS NULL
 			GROUP BY c.id
+			HAVING media_count > 0 OR c.collection_type != 'inbox'
 			ORDER BY c.id DESC;
 			`
 		rows, err := db.Query(q, collectionType)
@@ -285,7 +446,7 @@ func NewSqliteCollectionLister(db *sql.DB) app.CollectionLister {
 
 		for rows.Next() {
 			c := app.Collection{}
-			err = rows.Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount)
+			err = rows.Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount, &c.InboxCount)
 			if err != nil {
 				return out, err
 			}
@@ -296,76 +457,43 @@ func NewSqliteCollectionLister(db *sql.DB) app.CollectionLister {
 	}
 }
 
+// NewSqliteCollectionDetail returns a collection with all of its media in natural order
 func NewSqliteCollectionDetail(db *sql.DB) app.CollectionDetailQuery {
 	return func(collectionID string) (app.CollectionDetail, error) {
-		out := app.CollectionDetail{}
-
-		c, err := fetchCollectionByID(db, collectionID)
-		if err != nil {
-			return out, err
-		}
-		out.CollectionMeta = c
-
-		media, err := fetchMediaByCollectionID(db, collectionID)
-		if err != nil {
-			return out, err
-		}
-		out.Media = media
-
-		return out, nil
+		return fetchCollectionPage(db, collectionID, app.CollectionPage{}, 0)
 	}
 }
 
-func fetchMediaByCollectionID(db *sql.DB, collectionID string) ([]app.Media, error) {
+// scanMediaRows reads rows of mediaColumns and closes rows
+func scanMediaRows(rows *sql.Rows) ([]app.Media, error) {
 	out := []app.Media{}
-
-	q := `SELECT
-			media_data,
-			date_exported IS NOT NULL
-			FROM collection AS c
-			INNER JOIN media_collection ON media_collection.collection_id = c.id
-			INNER JOIN media ON media_collection.media_id = media.id
-			WHERE c.id = ? AND media.date_deleted IS NULL
-			ORDER BY media.id DESC;
-			`
-	rows, err := db.Query(q, collectionID)
-	if err != nil {
-		return out, err
-	}
+	defer rows.Close()
 
 	for rows.Next() {
-		m := app.Media{}
-		jsonStr := ""
-		err = rows.Scan(&jsonStr, &m.IsExported)
-		if err != nil {
-			return out, err
-		}
-		err = json.Unmarshal([]byte(jsonStr), &m)
+		m, err := scanMedia(rows)
 		if err != nil {
 			return out, err
 		}
 
-		m.FormattedDate = m.MediaMetadata.Date.Format(time.RFC3339Nano)
-
 		out = append(out, m)
 	}
 
-	return out, nil
+	return out, rows.Err()
 }
 
 func fetchCollectionByID(db *sql.DB, collectionID string) (app.Collection, error) {
 	q := `SELECT
-			c.id, c.collection_type, c.title, count(*) as media_count, count(media.date_exported) as exported_count
+			c.id, c.collection_type, c.title, count(media.id) as media_count, count(media.date_exported) as exported_count,
+			count(CASE WHEN media.triage_state = 'new' THEN 1 END) as inbox_count
 			FROM collection AS c
-			INNER JOIN media_collection ON media_collection.collection_id = c.id
-			INNER JOIN media ON media_collection.media_id = media.id
-			WHERE c.id = ? AND media.date_deleted IS NULL
-			GROUP BY c.id
-			ORDER BY c.id DESC;
+			LEFT JOIN media_collection ON media_collection.collection_id = c.id
+			LEFT JOIN media ON media_collection.media_id = media.id AND media.date_deleted IS NULL
+			WHERE c.id = ?
+			GROUP BY c.id;
 			`
 
 	c := app.Collection{}
-	err := db.QueryRow(q, collectionID).Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount)
+	err := db.QueryRow(q, collectionID).Scan(&c.ID, &c.Type, &c.Title, &c.MediaCount, &c.ExportedCount, &c.InboxCount)
 	if err != nil {
 		return c, err
 	}
@@ -396,7 +524,7 @@ func NewSqliteCreateCollection(db *sql.DB) app.CreateCollection {
 	}
 }
 
-func addMediaToCollection(db *sql.DB, collectionID string, collectionType app.CollectionType, collectionTitle string, media app.Media) (app.Media, error) {
+func addMediaToCollection(db execer, collectionID string, collectionType app.CollectionType, collectionTitle string, media app.Media) (app.Media, error) {
 	collectionID = slug.Make(fmt.Sprintf("%s__%s", collectionType, collectionID))
 
 	_, err := db.Exec(
@@ -419,25 +547,63 @@ func addMediaToCollection(db *sql.DB, collectionID string, collectionType app.Co
 		media.ID,
 		collectionID)
 
-	colExists := false
-	for _, m := range media.Collections {
-		if m.ID == collectionID {
-			colExists = true
+	media = appendCollection(media, app.Collection{
+		ID:    collectionID,
+		Title: collectionTitle,
+		Type:  collectionType,
+	})
+
+	return media, err
+}
+
+// removeMediaFromCollection removes membership and saves the media without the collection
+func removeMediaFromCollection(db execer, collectionID string, media app.Media) (app.Media, error) {
+	_, err := db.Exec(
+		`DELETE FROM media_collection WHERE media_id = ? AND collection_id = ?;`,
+		media.ID,
+		collectionID)
+	if err != nil {
+		return media, err
+	}
+
+	collections := []app.Collection{}
+	for _, c := range media.Collections {
+		if c.ID != collectionID {
+			collections = append(collections, c)
 		}
 	}
+	media.Collections = collections
 
-	if !colExists {
-		media.Collections = append(
-			media.Collections,
-			app.Collection{
-				ID:    collectionID,
-				Title: collectionTitle,
-				Type:  collectionType,
-			},
-		)
+	return media, updateMediaDataByID(db, media)
+}
+
+// appendCollection adds c to the media's collections if it is not already there
+func appendCollection(media app.Media, c app.Collection) app.Media {
+	for _, m := range media.Collections {
+		if m.ID == c.ID {
+			return media
+		}
 	}
 
-	return media, err
+	media.Collections = append(
+		media.Collections,
+		app.Collection{
+			ID:    c.ID,
+			Title: c.Title,
+			Type:  c.Type,
+		},
+	)
+
+	return media
+}
+
+func fetchCollectionType(db *sql.DB, collectionID string) (app.CollectionType, error) {
+	collectionType := app.CollectionType("")
+	err := db.QueryRow(`SELECT collection_type FROM collection WHERE id = ?;`, collectionID).Scan(&collectionType)
+	if err == sql.ErrNoRows {
+		return collectionType, fmt.Errorf("%w: collection %s", app.ErrNotFound, collectionID)
+	}
+	return collectionType, err
 }
 
 func NewSaveGPXPoints(db *sql.DB) app.SaveGPXPoints {
@@ -63,6 +63,61 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+func TestMediaColumns(t *testing.T) {
+	t.Run("it keeps queryable columns in sync with media data", func(t *testing.T) {
+		// arrange
+		db := newTestDB(t)
+		err := index.CreateIndex(db)
+		assert.NilError(t, err)
+		indexMedia := index.NewSqliteIndexer(db)
+		updateCaption := index.NewUpdateMediaCaption(db)
+
+		media := app.Media{
+			MediaMetadata: app.MediaMetadata{
+				Hash:        "test-hash",
+				Date:        time.Date(2022, time.January, 28, 12, 0, 0, 0, time.UTC),
+				CameraMake:  "Fairphone",
+				CameraModel: "FP3",
+				MimeType:    "image/jpeg",
+				Width:       "100",
+				Height:      "133",
+			},
+			Location: app.Location{
+				Country:  app.Country{Long: "United Kingdom", Short: "GB"},
+				Region:   "West Yorkshire",
+				Locality: "Leeds",
+				Coordinates: app.Coordinates{
+					Lat: 53.8700189722222,
+					Lng: -1.561703,
+				},
+			},
+		}
+
+		// act
+		_, err = indexMedia(media)
+		assert.NilError(t, err)
+		err = updateCaption("test-hash", "new caption")
+		assert.NilError(t, err)
+
+		// assert
+		takenAt, cameraModel, caption, country, locality := "", "", "", "", ""
+		width := 0
+		lat := 0.0
+		err = db.QueryRow(`SELECT
+			taken_at, camera_model, caption, country, locality, width, lat
+			FROM media WHERE id = ?;`, "test-hash").Scan(
+			&takenAt, &cameraModel, &caption, &country, &locality, &width, &lat)
+		assert.NilError(t, err)
+		assert.Equal(t, takenAt, "2022-01-28T12:00:00Z")
+		assert.Equal(t, cameraModel, "FP3")
+		assert.Equal(t, caption, "new caption")
+		assert.Equal(t, country, "United Kingdom")
+		assert.Equal(t, locality, "Leeds")
+		assert.Equal(t, width, 100)
+		assert.Equal(t, lat, 53.8700189722222)
+	})
+}
+
 func TestFindNearestGPX(t *testing.T) {
 	testCases := []struct {
 		desc          string
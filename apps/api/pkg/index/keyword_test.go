@@ -0,0 +1,79 @@
+package index_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestKeywordHashtags(t *testing.T) {
+	rules := app.KeywordRules{
+		Map:    map[string]string{"hols": "holiday"},
+		Ignore: []string{"export"},
+	}
+
+	t.Run("the indexer turns keywords into hashtags", func(t *testing.T) {
+		// arrange
+		db := newTestDB(t)
+		assert.NilError(t, index.CreateIndex(db))
+		indexMedia := index.NewSqliteKeywordIndexer(db, rules)
+
+		// act
+		media, err := indexMedia(app.Media{
+			MediaMetadata: app.MediaMetadata{
+				Hash:        "hash-ferry",
+				Date:        time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
+				Keywords:    "hols, Places|NL|Rotterdam, export",
+				KeywordList: []string{"hols", "Places|NL|Rotterdam", "export"},
+			},
+		})
+		assert.NilError(t, err)
+		page, err := index.NewSqliteMediaLister(db)(app.MediaQuery{Query: "tag:rotterdam tag:holiday"})
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, hashtagTitles(media), []string{"holiday", "rotterdam"})
+		assert.Equal(t, page.Total, 1)
+	})
+
+	t.Run("import keywords backfills existing media", func(t *testing.T) {
+		// arrange
+		db := newTestDB(t)
+		assert.NilError(t, index.CreateIndex(db))
+		// media imported before keywords became hashtags
+		_, err := index.InsertMedia(db, app.Media{
+			ID: "hash-ferry",
+			MediaMetadata: app.MediaMetadata{
+				Hash:     "hash-ferry",
+				Date:     time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
+				Keywords: "hols, ferry",
+			},
+		})
+		assert.NilError(t, err)
+		_, err = index.InsertMedia(db, app.Media{
+			ID: "hash-no-keywords",
+			MediaMetadata: app.MediaMetadata{
+				Hash: "hash-no-keywords",
+				Date: time.Date(2014, time.March, 22, 8, 1, 18, 0, time.UTC),
+			},
+		})
+		assert.NilError(t, err)
+		importKeywords := index.NewSqliteImportKeywords(db, rules)
+
+		// act
+		tagged, err := importKeywords()
+		assert.NilError(t, err)
+		taggedAgain, err := importKeywords()
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, tagged, 1)
+		assert.Equal(t, taggedAgain, 0)
+		media, err := index.NewQueryMediaDetail(db)("hash-ferry")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, hashtagTitles(media), []string{"holiday", "ferry"})
+	})
+}
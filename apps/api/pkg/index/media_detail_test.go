@@ -0,0 +1,102 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestMediaDetail(t *testing.T) {
+	db := newTestLibrary(t)
+	album, err := index.NewSqliteCreateCollection(db)("trip", app.CollectionTypeAlbum)
+	assert.NilError(t, err)
+	for _, id := range []string{"hash-kyoto", "hash-leeds", "hash-tokyo"} {
+		assert.NilError(t, index.NewSqliteAddMediaToAlbum(db)(album.ID, id))
+	}
+	smart, err := index.NewSqliteCreateSmartCollection(db)("japan", "country:japan")
+	assert.NilError(t, err)
+	mediaDetail := index.NewSqliteMediaDetail(db)
+
+	testCases := []struct {
+		desc             string
+		mediaID          string
+		context          app.MediaContext
+		expectedPrevious string
+		expectedNext     string
+	}{
+		{
+			desc:    "without a collection there are no neighbours",
+			mediaID: "hash-kyoto",
+		},
+		{
+			desc:             "album neighbours are in album order",
+			mediaID:          "hash-leeds",
+			context:          app.MediaContext{CollectionID: album.ID},
+			expectedPrevious: "hash-kyoto",
+			expectedNext:     "hash-tokyo",
+		},
+		{
+			desc:             "neighbours follow the requested sort",
+			mediaID:          "hash-kyoto",
+			context:          app.MediaContext{CollectionID: album.ID, Sort: app.MediaSortDateAsc},
+			expectedPrevious: "hash-tokyo",
+			expectedNext:     "hash-leeds",
+		},
+		{
+			desc:         "the first media has no previous",
+			mediaID:      "hash-kyoto",
+			context:      app.MediaContext{CollectionID: "timeline_month__2023-04"},
+			expectedNext: "hash-tokyo",
+		},
+		{
+			desc:             "smart collections use their query",
+			mediaID:          "hash-tokyo",
+			context:          app.MediaContext{CollectionID: smart.ID},
+			expectedPrevious: "hash-kyoto",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// act
+			detail, err := mediaDetail(tC.mediaID, tC.context)
+			assert.NilError(t, err)
+
+			// assert
+			assert.Equal(t, detail.Media.ID, tC.mediaID)
+			assert.Equal(t, detail.PreviousID, tC.expectedPrevious)
+			assert.Equal(t, detail.NextID, tC.expectedNext)
+		})
+	}
+
+	t.Run("it includes location, collections and export status", func(t *testing.T) {
+		detail, err := mediaDetail("hash-tokyo", app.MediaContext{})
+		assert.NilError(t, err)
+
+		assert.Equal(t, detail.Media.Location.Locality, "Shibuya")
+		assert.Equal(t, detail.Media.CameraModel, "iPhone 12")
+		assert.Equal(t, detail.Media.IsExported, true)
+		assert.Assert(t, len(detail.Media.Collections) > 0)
+	})
+
+	t.Run("it returns not found", func(t *testing.T) {
+		for _, tC := range []struct {
+			mediaID string
+			context app.MediaContext
+		}{
+			{mediaID: "missing"},
+			{mediaID: "hash-leeds", context: app.MediaContext{CollectionID: "timeline_month__2023-04"}},
+			{mediaID: "hash-leeds", context: app.MediaContext{CollectionID: "missing"}},
+		} {
+			_, err := mediaDetail(tC.mediaID, tC.context)
+			assert.Assert(t, errors.Is(err, app.ErrNotFound), tC)
+		}
+	})
+
+	t.Run("it rejects unknown sorts", func(t *testing.T) {
+		_, err := mediaDetail("hash-leeds", app.MediaContext{CollectionID: album.ID, Sort: "size"})
+		assert.Assert(t, errors.Is(err, app.ErrInvalidQuery))
+	})
+}
@@ -0,0 +1,351 @@
+package index
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// Migration is a single, ordered change to the index schema.
+// Migrations are append only, once released a migration must never be edited.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+type MigrationStatus struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "baseline schema",
+		Up:          migrateBaselineSchema,
+	},
+	{
+		Version:     2,
+		Description: "add queryable media columns",
+		Up:          migrateMediaColumns,
+	},
+	{
+		Version:     3,
+		Description: "add media full text search index",
+		Up:          migrateSearchIndex,
+	},
+	{
+		Version:     4,
+		Description: "add smart collection queries",
+		Up:          migrateSmartCollections,
+	},
+	{
+		Version:     5,
+		Description: "add album descriptions, covers and positions",
+		Up:          migrateAlbums,
+	},
+	{
+		Version:     6,
+		Description: "add media triage state",
+		Up:          migrateTriageState,
+	},
+	{
+		Version:     7,
+		Description: "add thumbnail job queue",
+		Up:          migrateThumbnailJobs,
+	},
+	{
+		Version:     8,
+		Description: "add imported source objects",
+		Up:          migrateImportedObjects,
+	},
+	{
+		Version:     9,
+		Description: "add local media taken dates",
+		Up:          migrateTakenDate,
+	},
+}
+
+// Migrations returns every known migration in version order
+func Migrations() []Migration {
+	return migrations
+}
+
+func migrateBaselineSchema(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS media (
+			id TEXT NOT NULL PRIMARY KEY,
+			date_created DATETIME NOT NULL,
+			date_deleted DATETIME,
+			date_exported DATETIME,
+			media_data TEXT
+		);`,
+		`CREATE TABLE IF NOT EXISTS collection (
+			id TEXT NOT NULL PRIMARY KEY,
+			collection_type TEXT,
+			title TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS
+		idx_collection_type ON collection (collection_type);`,
+		`CREATE TABLE IF NOT EXISTS
+		media_collection (
+			media_id TEXT NOT NULL,
+			collection_id TEXT NOT NULL
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS
+		idx_media_collections ON media_collection (media_id, collection_id);`,
+		`CREATE TABLE IF NOT EXISTS
+		gpx (
+			timestamp TEXT NOT NULL,
+			lat TEXT NOT NULL,
+			lng TEXT NOT NULL
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS
+		idx_gpx ON gpx (timestamp, lat, lng);`,
+	)
+}
+
+func migrateMediaColumns(tx *sql.Tx) error {
+	err := execAll(tx,
+		`ALTER TABLE media ADD COLUMN taken_at DATETIME;`,
+		`ALTER TABLE media ADD COLUMN lat REAL;`,
+		`ALTER TABLE media ADD COLUMN lng REAL;`,
+		`ALTER TABLE media ADD COLUMN camera_make TEXT;`,
+		`ALTER TABLE media ADD COLUMN camera_model TEXT;`,
+		`ALTER TABLE media ADD COLUMN mime_type TEXT;`,
+		`ALTER TABLE media ADD COLUMN width INTEGER;`,
+		`ALTER TABLE media ADD COLUMN height INTEGER;`,
+		`ALTER TABLE media ADD COLUMN caption TEXT;`,
+		`ALTER TABLE media ADD COLUMN country TEXT;`,
+		`ALTER TABLE media ADD COLUMN region TEXT;`,
+		`ALTER TABLE media ADD COLUMN locality TEXT;`,
+		`CREATE INDEX IF NOT EXISTS idx_media_taken_at ON media (taken_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_camera ON media (camera_make, camera_model);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_mime_type ON media (mime_type);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_place ON media (country, region, locality);`,
+		`CREATE INDEX IF NOT EXISTS idx_media_lat_lng ON media (lat, lng);`,
+	)
+	if err != nil {
+		return err
+	}
+
+	return backfillMediaColumns(tx)
+}
+
+// backfillMediaColumns populates the queryable columns from each row's media_data
+func backfillMediaColumns(tx *sql.Tx) error {
+	allMedia, err := selectAllMedia(tx)
+	if err != nil {
+		return err
+	}
+
+	updateStmt, err := tx.Prepare(`UPDATE media SET
+		taken_at = ?,
+		lat = ?,
+		lng = ?,
+		camera_make = ?,
+		camera_model = ?,
+		mime_type = ?,
+		width = ?,
+		height = ?,
+		caption = ?,
+		country = ?,
+		region = ?,
+		locality = ?
+		WHERE id = ?;`)
+	if err != nil {
+		return err
+	}
+	defer updateStmt.Close()
+
+	for _, media := range allMedia {
+		_, err = updateStmt.Exec(append(mediaColumnValues(media), media.ID)...)
+		if err != nil {
+			return fmt.Errorf("failed to backfill media %s: %w", media.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// selectAllMedia loads every media row, including deleted media, for use by backfills
+func selectAllMedia(tx *sql.Tx) ([]app.Media, error) {
+	out := []app.Media{}
+
+	rows, err := tx.Query(`SELECT id, media_data FROM media;`)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		id := ""
+		jsonStr := sql.NullString{}
+		err = rows.Scan(&id, &jsonStr)
+		if err != nil {
+			return out, err
+		}
+		m := app.Media{}
+		if jsonStr.Valid {
+			err = json.Unmarshal([]byte(jsonStr.String), &m)
+			if err != nil {
+				return out, fmt.Errorf("failed to unmarshal media %s: %w", id, err)
+			}
+		}
+		m.ID = id
+		out = append(out, m)
+	}
+
+	return out, rows.Err()
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS
+		schema_version (
+			version INTEGER NOT NULL PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		);`)
+	return err
+}
+
+// SchemaVersion returns the highest applied migration version, 0 for a new db
+func SchemaVersion(db *sql.DB) (int, error) {
+	err := createSchemaVersionTable(db)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version;`).Scan(&version)
+	return version, err
+}
+
+// PendingMigrations returns migrations that have not yet been applied to db
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	out := []Migration{}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return out, err
+	}
+
+	for _, m := range migrations {
+		if m.Version > version {
+			out = append(out, m)
+		}
+	}
+
+	return out, nil
+}
+
+// SchemaStatus lists every known migration and when it was applied
+func SchemaStatus(db *sql.DB) ([]MigrationStatus, error) {
+	out := []MigrationStatus{}
+
+	err := createSchemaVersionTable(db)
+	if err != nil {
+		return out, err
+	}
+
+	applied := map[int]time.Time{}
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_version;`)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		version := 0
+		appliedAt := ""
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return out, err
+		}
+		applied[version], err = time.Parse(time.RFC3339, appliedAt)
+		if err != nil {
+			return out, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return out, err
+	}
+
+	for _, m := range migrations {
+		s := MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+		}
+		if appliedAt, ok := applied[m.Version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+// Migrate applies all pending migrations in order, each in its own transaction,
+// and returns the migrations that were applied
+func Migrate(db *sql.DB) ([]Migration, error) {
+	applied := []Migration{}
+
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return applied, err
+	}
+
+	for _, m := range pending {
+		err := applyMigration(db, m)
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d %q: %w", m.Version, m.Description, err)
+		}
+		applied = append(applied, m)
+	}
+
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.Up(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO schema_version (version, description, applied_at) VALUES (?,?,?);`,
+		m.Version,
+		m.Description,
+		time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// BackupDB writes a consistent copy of db to dstFilename
+func BackupDB(db *sql.DB, dstFilename string) error {
+	_, err := db.Exec(`VACUUM INTO ?;`, dstFilename)
+	return err
+}
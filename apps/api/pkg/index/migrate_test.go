@@ -0,0 +1,190 @@
+package index_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+// baselineSchema is the schema created by CreateIndex before migrations existed
+const baselineSchema = `CREATE TABLE IF NOT EXISTS media (
+		id TEXT NOT NULL PRIMARY KEY,
+		date_created DATETIME NOT NULL,
+		date_deleted DATETIME,
+		date_exported DATETIME,
+		media_data TEXT
+	);
+	CREATE TABLE IF NOT EXISTS collection (
+		id TEXT NOT NULL PRIMARY KEY,
+		collection_type TEXT,
+		title TEXT
+	);
+	CREATE INDEX IF NOT EXISTS
+	idx_collection_type ON collection (collection_type);
+	CREATE TABLE IF NOT EXISTS
+	media_collection (
+		media_id TEXT NOT NULL,
+		collection_id TEXT NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS
+	idx_media_collections ON media_collection (media_id, collection_id);
+	CREATE TABLE IF NOT EXISTS
+	gpx (
+		timestamp TEXT NOT NULL,
+		lat TEXT NOT NULL,
+		lng TEXT NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS
+	idx_gpx ON gpx (timestamp, lat, lng);`
+
+const baselineMediaData = `{"id":"test-hash","media_metadata":{"hash":"test-hash","date":"2022-01-28T12:00:00Z","coordinates":{},"ext":"jpg","mime_type":"image/jpeg","width":"100","height":"133","camera_make":"Fairphone","camera_model":"FP3","keywords":"","title":""},"thumbnails":{"key":"","large":"","medium":"","small":""},"location":{"country":{"short":"","long":""}},"caption":"test caption"}`
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dbFilepath := filepath.Join(os.TempDir(), fmt.Sprintf("inari-test-db-%s", uuid.New().String()))
+	db, err := sql.Open("sqlite3", dbFilepath)
+	if err != nil {
+		t.Fatalf("failed to open sqlite db: %s", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(dbFilepath)
+	})
+	return db
+}
+
+func newBaselineDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	_, err := db.Exec(baselineSchema)
+	assert.NilError(t, err)
+	_, err = db.Exec(
+		`INSERT INTO media (id, date_created, media_data) VALUES (?,?,?);`,
+		"test-hash",
+		"2022-01-28T12:00:00Z",
+		baselineMediaData,
+	)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO collection (id, collection_type, title) VALUES ('inbox__2022-01', 'inbox', 'inbox Jan 2022');`)
+	assert.NilError(t, err)
+	_, err = db.Exec(`INSERT INTO media_collection (media_id, collection_id) VALUES ('test-hash', 'inbox__2022-01');`)
+	assert.NilError(t, err)
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	latestVersion := index.Migrations()[len(index.Migrations())-1].Version
+
+	t.Run("it migrates a new db to the latest version", func(t *testing.T) {
+		db := newTestDB(t)
+
+		applied, err := index.Migrate(db)
+		assert.NilError(t, err)
+		assert.Equal(t, len(applied), len(index.Migrations()))
+
+		version, err := index.SchemaVersion(db)
+		assert.NilError(t, err)
+		assert.Equal(t, version, latestVersion)
+	})
+
+	t.Run("it migrates a baseline db and keeps existing media", func(t *testing.T) {
+		db := newBaselineDB(t)
+
+		_, err := index.Migrate(db)
+		assert.NilError(t, err)
+
+		version, err := index.SchemaVersion(db)
+		assert.NilError(t, err)
+		assert.Equal(t, version, latestVersion)
+
+		media, err := index.NewQueryMediaDetail(db)("test-hash")
+		assert.NilError(t, err)
+		assert.Equal(t, media.ID, "test-hash")
+		assert.Equal(t, media.Caption, "test caption")
+
+		detail, err := index.NewSqliteCollectionDetail(db)("inbox__2022-01")
+		assert.NilError(t, err)
+		assert.Equal(t, len(detail.Media), 1)
+	})
+
+	t.Run("it backfills queryable media columns", func(t *testing.T) {
+		db := newBaselineDB(t)
+
+		_, err := index.Migrate(db)
+		assert.NilError(t, err)
+
+		takenAt, takenDate, cameraMake, cameraModel, caption := "", "", "", "", ""
+		width, height := 0, 0
+		hasLocation := false
+		err = db.QueryRow(`SELECT
+			taken_at, taken_date, camera_make, camera_model, caption, width, height, lat IS NOT NULL
+			FROM media WHERE id = ?;`, "test-hash").Scan(
+			&takenAt, &takenDate, &cameraMake, &cameraModel, &caption, &width, &height, &hasLocation)
+		assert.NilError(t, err)
+		assert.Equal(t, takenAt, "2022-01-28T12:00:00Z")
+		assert.Equal(t, takenDate, "2022-01-28")
+		assert.Equal(t, cameraMake, "Fairphone")
+		assert.Equal(t, cameraModel, "FP3")
+		assert.Equal(t, caption, "test caption")
+		assert.Equal(t, width, 100)
+		assert.Equal(t, height, 133)
+		assert.Equal(t, hasLocation, false)
+	})
+
+	t.Run("it does nothing when already up to date", func(t *testing.T) {
+		db := newTestDB(t)
+
+		_, err := index.Migrate(db)
+		assert.NilError(t, err)
+		applied, err := index.Migrate(db)
+		assert.NilError(t, err)
+		assert.Equal(t, len(applied), 0)
+
+		pending, err := index.PendingMigrations(db)
+		assert.NilError(t, err)
+		assert.Equal(t, len(pending), 0)
+	})
+
+	t.Run("it reports status of every migration", func(t *testing.T) {
+		db := newBaselineDB(t)
+
+		status, err := index.SchemaStatus(db)
+		assert.NilError(t, err)
+		assert.Equal(t, len(status), len(index.Migrations()))
+		for _, s := range status {
+			assert.Assert(t, s.AppliedAt == nil)
+		}
+
+		_, err = index.Migrate(db)
+		assert.NilError(t, err)
+
+		status, err = index.SchemaStatus(db)
+		assert.NilError(t, err)
+		for _, s := range status {
+			assert.Assert(t, s.AppliedAt != nil)
+		}
+	})
+
+	t.Run("it backs up the db", func(t *testing.T) {
+		db := newBaselineDB(t)
+		backupFilepath := filepath.Join(os.TempDir(), fmt.Sprintf("inari-test-db-backup-%s", uuid.New().String()))
+		defer os.Remove(backupFilepath)
+
+		err := index.BackupDB(db, backupFilepath)
+		assert.NilError(t, err)
+
+		backupDB, err := sql.Open("sqlite3", backupFilepath)
+		assert.NilError(t, err)
+		defer backupDB.Close()
+		mediaCount := 0
+		err = backupDB.QueryRow(`SELECT count(*) FROM media;`).Scan(&mediaCount)
+		assert.NilError(t, err)
+		assert.Equal(t, mediaCount, 1)
+	})
+}
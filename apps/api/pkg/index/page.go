@@ -0,0 +1,303 @@
+package index
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+const (
+	defaultCollectionPageLimit = 200
+	maxCollectionPageLimit     = 1000
+
+	// mediaSortPosition is the natural order of albums
+	mediaSortPosition app.MediaSort = "position"
+)
+
+// mediaCursor holds the sort keys of the last media on a page, every sort
+// ends with a unique key so pages never overlap or skip media
+type mediaCursor struct {
+	Sort     app.MediaSort `json:"s"`
+	TakenAt  string        `json:"t,omitempty"`
+	Position int64         `json:"p,omitempty"`
+	RowID    int64         `json:"r,omitempty"`
+	ID       string        `json:"i,omitempty"`
+}
+
+func (c mediaCursor) encode() string {
+	cursorJSON, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursorJSON)
+}
+
+func decodeMediaCursor(cursor string, sort app.MediaSort) (mediaCursor, error) {
+	out := mediaCursor{}
+	cursorJSON, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(cursorJSON, &out)
+	}
+	if err != nil || out.Sort != sort {
+		return out, fmt.Errorf("%w: invalid cursor %s", app.ErrInvalidInput, cursor)
+	}
+	return out, nil
+}
+
+// collectionSort orders media by keys, the last key is unique so the order is stable
+type collectionSort struct {
+	keys       []string
+	desc       bool
+	cursorArgs func(c mediaCursor) []any
+}
+
+func newCollectionSort(sort app.MediaSort) (collectionSort, error) {
+	takenAtAndID := func(c mediaCursor) []any { return []any{c.TakenAt, c.ID} }
+	switch sort {
+	case app.MediaSortDateDesc:
+		return collectionSort{[]string{`COALESCE(media.taken_at, '')`, `media.id`}, true, takenAtAndID}, nil
+	case app.MediaSortDateAsc:
+		return collectionSort{[]string{`COALESCE(media.taken_at, '')`, `media.id`}, false, takenAtAndID}, nil
+	case app.MediaSortImported:
+		return collectionSort{[]string{`media.rowid`}, true, func(c mediaCursor) []any { return []any{c.RowID} }}, nil
+	case mediaSortPosition:
+		return collectionSort{
+			[]string{`COALESCE(media_collection.position, 0)`, `media.id`},
+			false,
+			func(c mediaCursor) []any { return []any{c.Position, c.ID} },
+		}, nil
+	}
+	return collectionSort{}, fmt.Errorf("%w: unknown sort %s", app.ErrInvalidQuery, sort)
+}
+
+// orderBy returns the ORDER BY clause, reversed for walking backwards
+func (s collectionSort) orderBy(reverse bool) string {
+	dir := "ASC"
+	if s.desc != reverse {
+		dir = "DESC"
+	}
+	keys := []string{}
+	for _, k := range s.keys {
+		keys = append(keys, k+" "+dir)
+	}
+	return strings.Join(keys, ", ")
+}
+
+// after returns a WHERE clause selecting media after cursor, or before it when reversed
+func (s collectionSort) after(cursor mediaCursor, reverse bool) (string, []any) {
+	op := ">"
+	if s.desc != reverse {
+		op = "<"
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(s.keys)), ", ")
+	return fmt.Sprintf("(%s) %s (%s)", strings.Join(s.keys, ", "), op, placeholders), s.cursorArgs(cursor)
+}
+
+// collectionSource is where a collection's media comes from, smart collections
+// select media by query, everything else by membership
+type collectionSource struct {
+	meta     app.Collection
+	from     string
+	where    string
+	args     []any
+	position string
+}
+
+func fetchCollectionSource(db *sql.DB, collectionID string) (collectionSource, error) {
+	out := collectionSource{
+		from: `media
+		INNER JOIN media_collection ON media_collection.media_id = media.id`,
+		where:    `media_collection.collection_id = ? AND media.date_deleted IS NULL`,
+		args:     []any{collectionID},
+		position: `COALESCE(media_collection.position, 0)`,
+	}
+
+	collectionType, err := fetchCollectionType(db, collectionID)
+	if err != nil {
+		return out, err
+	}
+
+	switch collectionType {
+	case app.CollectionTypeSmart:
+		out.meta, err = fetchSmartCollectionByID(db, collectionID)
+		if err != nil {
+			return out, err
+		}
+		out.from = `media`
+		out.where, out.args, err = compileMediaQuery(out.meta.Query)
+		out.position = `0`
+	case app.CollectionTypeAlbum:
+		out.meta, err = fetchAlbumByID(db, collectionID)
+	default:
+		out.meta, err = fetchCollectionByID(db, collectionID)
+	}
+
+	return out, err
+}
+
+// sort returns the collection's natural order when sort is empty,
+// albums are in album order and everything else is newest first. Only
+// albums have positions to sort by
+func (src collectionSource) sort(sort app.MediaSort) (app.MediaSort, error) {
+	isAlbum := src.meta.Type == app.CollectionTypeAlbum
+	if sort == mediaSortPosition && !isAlbum {
+		return sort, fmt.Errorf("%w: %s sort is only for albums", app.ErrInvalidQuery, sort)
+	}
+	if sort != "" {
+		return sort, nil
+	}
+	if isAlbum {
+		return mediaSortPosition, nil
+	}
+	return app.MediaSortDateDesc, nil
+}
+
+// cursorColumns are scanned into mediaCursor.dest
+func (src collectionSource) cursorColumns() string {
+	return fmt.Sprintf(`COALESCE(media.taken_at, ''), %s, media.rowid, media.id`, src.position)
+}
+
+func (c *mediaCursor) dest() []any {
+	return []any{&c.TakenAt, &c.Position, &c.RowID, &c.ID}
+}
+
+func NewSqliteCollectionPage(db *sql.DB) app.CollectionPageQuery {
+	return func(collectionID string, page app.CollectionPage) (app.CollectionDetail, error) {
+		limit := page.Limit
+		if limit <= 0 {
+			limit = defaultCollectionPageLimit
+		}
+		if limit > maxCollectionPageLimit {
+			limit = maxCollectionPageLimit
+		}
+
+		return fetchCollectionPage(db, collectionID, page, limit)
+	}
+}
+
+// fetchCollectionPage returns a collection and up to limit of its media, a limit of 0 returns all media
+func fetchCollectionPage(db *sql.DB, collectionID string, page app.CollectionPage, limit int) (app.CollectionDetail, error) {
+	out := app.CollectionDetail{Media: []app.Media{}}
+
+	src, err := fetchCollectionSource(db, collectionID)
+	if err != nil {
+		return out, err
+	}
+	out.CollectionMeta = src.meta
+
+	sortName, err := src.sort(page.Sort)
+	if err != nil {
+		return out, err
+	}
+	sort, err := newCollectionSort(sortName)
+	if err != nil {
+		return out, err
+	}
+	where := src.where
+	args := src.args
+	if page.Cursor != "" {
+		cursor, err := decodeMediaCursor(page.Cursor, sortName)
+		if err != nil {
+			return out, err
+		}
+		after, afterArgs := sort.after(cursor, false)
+		where = fmt.Sprintf("%s AND %s", where, after)
+		args = append(args, afterArgs...)
+	}
+	limitClause := ""
+	if limit > 0 {
+		// one extra row tells us if there is a next page
+		limitClause = "LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	rows, err := db.Query(
+		fmt.Sprintf(`SELECT
+			%s,
+			%s
+			FROM %s
+			WHERE %s
+			ORDER BY %s
+			%s;
+			`, mediaColumns, src.cursorColumns(), src.from, where, sort.orderBy(false), limitClause),
+		args...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	last := mediaCursor{Sort: sortName}
+	for rows.Next() {
+		if limit > 0 && len(out.Media) == limit {
+			out.NextCursor = last.encode()
+			break
+		}
+		m, err := scanMedia(rows, last.dest()...)
+		if err != nil {
+			return out, err
+		}
+		out.Media = append(out.Media, m)
+	}
+	err = rows.Err()
+	if err != nil {
+		return out, err
+	}
+
+	if page.ThumbnailsOnly {
+		out.Thumbnails = []app.MediaThumbnail{}
+		for _, m := range out.Media {
+			out.Thumbnails = append(out.Thumbnails, app.MediaThumbnail{
+				ID:         m.ID,
+				Date:       m.FormattedDate,
+				Thumbnails: m.Thumbnails,
+			})
+		}
+		out.Media = []app.Media{}
+	}
+
+	return out, nil
+}
+
+// fetchNeighbours returns the ids of the media before and after mediaID in a collection
+func fetchNeighbours(db *sql.DB, collectionID, mediaID string, sortName app.MediaSort) (string, string, error) {
+	src, err := fetchCollectionSource(db, collectionID)
+	if err != nil {
+		return "", "", err
+	}
+	sortName, err = src.sort(sortName)
+	if err != nil {
+		return "", "", err
+	}
+	sort, err := newCollectionSort(sortName)
+	if err != nil {
+		return "", "", err
+	}
+
+	cursor := mediaCursor{Sort: sortName}
+	err = db.QueryRow(
+		fmt.Sprintf(`SELECT %s FROM %s WHERE %s AND media.id = ?;`, src.cursorColumns(), src.from, src.where),
+		append(src.args, mediaID)...,
+	).Scan(cursor.dest()...)
+	if err == sql.ErrNoRows {
+		return "", "", fmt.Errorf("%w: media %s is not in collection %s", app.ErrNotFound, mediaID, collectionID)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	neighbours := []string{"", ""}
+	for i, reverse := range []bool{true, false} {
+		after, afterArgs := sort.after(cursor, reverse)
+		err = db.QueryRow(
+			fmt.Sprintf(`SELECT media.id FROM %s WHERE %s AND %s ORDER BY %s LIMIT 1;`,
+				src.from, src.where, after, sort.orderBy(reverse)),
+			append(append([]any{}, src.args...), afterArgs...)...,
+		).Scan(&neighbours[i])
+		if err != nil && err != sql.ErrNoRows {
+			return "", "", err
+		}
+	}
+
+	return neighbours[0], neighbours[1], nil
+}
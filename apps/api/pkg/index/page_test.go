@@ -0,0 +1,110 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestCollectionPage(t *testing.T) {
+	db := newTestLibrary(t)
+	album, err := index.NewSqliteCreateCollection(db)("trip", app.CollectionTypeAlbum)
+	assert.NilError(t, err)
+	for _, id := range []string{"hash-kyoto", "hash-leeds", "hash-tokyo"} {
+		assert.NilError(t, index.NewSqliteAddMediaToAlbum(db)(album.ID, id))
+	}
+	collectionPage := index.NewSqliteCollectionPage(db)
+
+	testCases := []struct {
+		desc         string
+		collectionID string
+		sort         app.MediaSort
+		expected     []string
+	}{
+		{
+			desc:         "albums default to album order",
+			collectionID: album.ID,
+			expected:     []string{"hash-kyoto", "hash-leeds", "hash-tokyo"},
+		},
+		{
+			desc:         "newest first",
+			collectionID: album.ID,
+			sort:         app.MediaSortDateDesc,
+			expected:     []string{"hash-leeds", "hash-kyoto", "hash-tokyo"},
+		},
+		{
+			desc:         "oldest first",
+			collectionID: album.ID,
+			sort:         app.MediaSortDateAsc,
+			expected:     []string{"hash-tokyo", "hash-kyoto", "hash-leeds"},
+		},
+		{
+			desc:         "import order",
+			collectionID: album.ID,
+			sort:         app.MediaSortImported,
+			expected:     []string{"hash-leeds", "hash-kyoto", "hash-tokyo"},
+		},
+		{
+			desc:         "other collections default to newest first",
+			collectionID: "timeline_month__2023-04",
+			expected:     []string{"hash-kyoto", "hash-tokyo"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// act
+			ids := []string{}
+			cursor := ""
+			for pages := 0; pages < 10; pages++ {
+				detail, err := collectionPage(tC.collectionID, app.CollectionPage{Cursor: cursor, Limit: 2, Sort: tC.sort})
+				assert.NilError(t, err)
+				for _, m := range detail.Media {
+					ids = append(ids, m.ID)
+				}
+				cursor = detail.NextCursor
+				if cursor == "" {
+					break
+				}
+			}
+
+			// assert
+			assert.DeepEqual(t, ids, tC.expected)
+		})
+	}
+
+	t.Run("it can return only thumbnails", func(t *testing.T) {
+		detail, err := collectionPage(album.ID, app.CollectionPage{ThumbnailsOnly: true})
+		assert.NilError(t, err)
+
+		assert.Equal(t, len(detail.Media), 0)
+		assert.Equal(t, len(detail.Thumbnails), 3)
+		assert.Equal(t, detail.Thumbnails[0].ID, "hash-kyoto")
+		assert.Equal(t, detail.NextCursor, "")
+		assert.Equal(t, detail.CollectionMeta.MediaCount, 3)
+	})
+
+	t.Run("it rejects cursors from another sort", func(t *testing.T) {
+		detail, err := collectionPage(album.ID, app.CollectionPage{Limit: 1})
+		assert.NilError(t, err)
+
+		_, err = collectionPage(album.ID, app.CollectionPage{Limit: 1, Cursor: detail.NextCursor, Sort: app.MediaSortDateAsc})
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+		_, err = collectionPage(album.ID, app.CollectionPage{Cursor: "not-a-cursor"})
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+	})
+
+	t.Run("it only sorts albums by position", func(t *testing.T) {
+		smart, err := index.NewSqliteCreateSmartCollection(db)("japan", "country:japan")
+		assert.NilError(t, err)
+
+		for _, collectionID := range []string{smart.ID, "timeline_month__2023-04"} {
+			_, err = collectionPage(collectionID, app.CollectionPage{Sort: "position"})
+			assert.Assert(t, errors.Is(err, app.ErrInvalidQuery), collectionID)
+		}
+		_, err = collectionPage(album.ID, app.CollectionPage{Sort: "position"})
+		assert.NilError(t, err)
+	})
+}
@@ -0,0 +1,318 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gosimple/slug"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+const (
+	defaultMediaPageLimit = 100
+	maxMediaPageLimit     = 1000
+)
+
+// QueryTerm is a single criteria of a media query, eg: -country:Japan
+// has Field "country", Value "Japan" and Negate true. Terms without a
+// field are matched against the full text search index
+type QueryTerm struct {
+	Field  string
+	Value  string
+	Negate bool
+}
+
+// ParseMediaQuery splits a media query into terms, values containing spaces can be "quoted"
+func ParseMediaQuery(query string) ([]QueryTerm, error) {
+	terms := []QueryTerm{}
+	rs := []rune(query)
+	i := 0
+
+	for i < len(rs) {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+
+		term := QueryTerm{}
+		if rs[i] == '-' {
+			term.Negate = true
+			i++
+		}
+
+		if i < len(rs) && rs[i] != '"' {
+			start := i
+			for i < len(rs) && !unicode.IsSpace(rs[i]) && rs[i] != ':' {
+				i++
+			}
+			word := string(rs[start:i])
+			if i < len(rs) && rs[i] == ':' {
+				term.Field = strings.ToLower(word)
+				i++
+			} else {
+				term.Value = word
+			}
+		}
+
+		if term.Value == "" && i < len(rs) {
+			if rs[i] == '"' {
+				end := i + 1
+				for end < len(rs) && rs[end] != '"' {
+					end++
+				}
+				if end == len(rs) {
+					return terms, fmt.Errorf("%w: unterminated quote", app.ErrInvalidQuery)
+				}
+				term.Value = string(rs[i+1 : end])
+				i = end + 1
+			} else {
+				start := i
+				for i < len(rs) && !unicode.IsSpace(rs[i]) {
+					i++
+				}
+				term.Value = string(rs[start:i])
+			}
+		}
+
+		if term.Field != "" && term.Value == "" {
+			return terms, fmt.Errorf("%w: %s: has no value", app.ErrInvalidQuery, term.Field)
+		}
+		if term.Value == "" {
+			continue
+		}
+
+		// bare "exported" is shorthand for is:exported
+		if term.Field == "" && strings.ToLower(term.Value) == "exported" {
+			term.Field = "is"
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// compileMediaQuery converts a media query into an sql WHERE clause against the media table
+func compileMediaQuery(query string) (string, []any, error) {
+	where := []string{"media.date_deleted IS NULL"}
+	args := []any{}
+
+	terms, err := ParseMediaQuery(query)
+	if err != nil {
+		return "", args, err
+	}
+
+	for _, term := range terms {
+		clause, termArgs, err := compileQueryTerm(term)
+		if err != nil {
+			return "", args, err
+		}
+		if clause == "" {
+			continue
+		}
+		if term.Negate {
+			clause = fmt.Sprintf("NOT COALESCE((%s), 0)", clause)
+		}
+		where = append(where, clause)
+		args = append(args, termArgs...)
+	}
+
+	return strings.Join(where, " AND "), args, nil
+}
+
+func compileQueryTerm(term QueryTerm) (string, []any, error) {
+	switch term.Field {
+	case "":
+		matchQuery := parseSearchQuery(term.Value)
+		if matchQuery == "" {
+			return "", nil, nil
+		}
+		return `media.id IN (SELECT media_id FROM media_search WHERE media_search MATCH ?)`, []any{matchQuery}, nil
+	case "camera":
+		return `TRIM(COALESCE(media.camera_make, '') || ' ' || COALESCE(media.camera_model, '')) LIKE ? ESCAPE '\'`,
+			[]any{"%" + likeEscaper.Replace(term.Value) + "%"}, nil
+	case "country":
+		return `media.country = ? COLLATE NOCASE`, []any{term.Value}, nil
+	case "region":
+		return `media.region = ? COLLATE NOCASE`, []any{term.Value}, nil
+	case "locality", "city":
+		return `media.locality = ? COLLATE NOCASE`, []any{term.Value}, nil
+	case "after", "before", "on":
+		// dates are compared to the local date a media was taken, as the
+		// timeline is. after includes the date given, before excludes it
+		start, end, err := parseQueryDate(term.Value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %s:%s: %s", app.ErrInvalidQuery, term.Field, term.Value, err.Error())
+		}
+		if term.Field == "after" {
+			return `media.taken_date >= ?`, []any{start}, nil
+		}
+		if term.Field == "before" {
+			return `media.taken_date < ?`, []any{start}, nil
+		}
+		return `media.taken_date >= ? AND media.taken_date < ?`, []any{start, end}, nil
+	case "has":
+		switch strings.ToLower(term.Value) {
+		case "gps", "location":
+			return `media.lat IS NOT NULL`, nil, nil
+		case "caption":
+			return `COALESCE(media.caption, '') != ''`, nil, nil
+		case "tag", "tags":
+			return `EXISTS (
+				SELECT 1 FROM media_collection AS mc
+				INNER JOIN collection AS c ON c.id = mc.collection_id
+				WHERE mc.media_id = media.id AND c.collection_type = ?)`,
+				[]any{app.CollectionTypeHashTag}, nil
+		}
+	case "is":
+		switch strings.ToLower(term.Value) {
+		case "exported":
+			return `media.date_exported IS NOT NULL`, nil, nil
+		case string(app.TriageStateNew), "unreviewed":
+			return `media.triage_state = ?`, []any{app.TriageStateNew}, nil
+		case string(app.TriageStateReviewed), string(app.TriageStateArchived):
+			return `media.triage_state = ?`, []any{strings.ToLower(term.Value)}, nil
+		}
+	case "type":
+		switch strings.ToLower(term.Value) {
+		case "photo", "image":
+			return `media.mime_type LIKE 'image/%'`, nil, nil
+		case "video":
+			return `media.mime_type LIKE 'video/%'`, nil, nil
+		}
+	case "tag":
+		return `EXISTS (
+			SELECT 1 FROM media_collection AS mc
+			WHERE mc.media_id = media.id AND mc.collection_id = ?)`,
+			[]any{slug.Make(fmt.Sprintf("%s__%s", app.CollectionTypeHashTag, term.Value))}, nil
+	case "collection":
+		return `EXISTS (
+			SELECT 1 FROM media_collection AS mc
+			WHERE mc.media_id = media.id AND mc.collection_id = ?)`,
+			[]any{term.Value}, nil
+	default:
+		return "", nil, fmt.Errorf("%w: unknown field %s:", app.ErrInvalidQuery, term.Field)
+	}
+
+	return "", nil, fmt.Errorf("%w: unknown value %s:%s", app.ErrInvalidQuery, term.Field, term.Value)
+}
+
+// likeEscaper escapes LIKE wildcards so values match literally, with ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// parseQueryDate parses a year, month or day and returns the first day of
+// that period and the first day of the next in the format of media.taken_date
+func parseQueryDate(value string) (string, string, error) {
+	layouts := []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	}
+	for _, l := range layouts {
+		t, err := time.Parse(l.layout, value)
+		if err != nil {
+			continue
+		}
+		return t.Format(takenDateLayout), l.next(t).Format(takenDateLayout), nil
+	}
+
+	return "", "", fmt.Errorf("expected YYYY, YYYY-MM or YYYY-MM-DD")
+}
+
+// takenDateLayout is the format of media.taken_date
+const takenDateLayout = "2006-01-02"
+
+func migrateTakenDate(tx *sql.Tx) error {
+	err := execAll(tx,
+		`ALTER TABLE media ADD COLUMN taken_date TEXT;`,
+		`CREATE INDEX IF NOT EXISTS idx_media_taken_date ON media (taken_date);`,
+	)
+	if err != nil {
+		return err
+	}
+
+	allMedia, err := selectAllMedia(tx)
+	if err != nil {
+		return err
+	}
+	for _, media := range allMedia {
+		_, err = tx.Exec(`UPDATE media SET taken_date = ? WHERE id = ?;`, takenDate(media), media.ID)
+		if err != nil {
+			return fmt.Errorf("failed to backfill media %s: %w", media.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// takenDate is the local date a media was taken, the date of its timeline
+// collections, where taken_at is in UTC
+func takenDate(media app.Media) string {
+	return media.Date.Format(takenDateLayout)
+}
+
+func mediaSortOrder(sort app.MediaSort) (string, error) {
+	switch sort {
+	case "", app.MediaSortDateDesc:
+		return `media.taken_at DESC, media.id DESC`, nil
+	case app.MediaSortDateAsc:
+		return `media.taken_at ASC, media.id ASC`, nil
+	case app.MediaSortImported:
+		return `media.rowid DESC`, nil
+	}
+	return "", fmt.Errorf("%w: unknown sort %s", app.ErrInvalidQuery, sort)
+}
+
+func NewSqliteMediaLister(db *sql.DB) app.MediaLister {
+	return func(query app.MediaQuery) (app.MediaPage, error) {
+		out := app.MediaPage{
+			Media:  []app.Media{},
+			Limit:  query.Limit,
+			Offset: query.Offset,
+		}
+		if out.Limit <= 0 {
+			out.Limit = defaultMediaPageLimit
+		}
+		if out.Limit > maxMediaPageLimit {
+			out.Limit = maxMediaPageLimit
+		}
+		if out.Offset < 0 {
+			out.Offset = 0
+		}
+
+		where, args, err := compileMediaQuery(query.Query)
+		if err != nil {
+			return out, err
+		}
+		orderBy, err := mediaSortOrder(query.Sort)
+		if err != nil {
+			return out, err
+		}
+
+		err = db.QueryRow(`SELECT count(*) FROM media WHERE `+where+`;`, args...).Scan(&out.Total)
+		if err != nil {
+			return out, err
+		}
+
+		q := fmt.Sprintf(`SELECT
+			%s
+			FROM media
+			WHERE %s
+			ORDER BY %s
+			LIMIT ? OFFSET ?;
+			`, mediaColumns, where, orderBy)
+		rows, err := db.Query(q, append(args, out.Limit, out.Offset)...)
+		if err != nil {
+			return out, err
+		}
+		out.Media, err = scanMediaRows(rows)
+
+		return out, err
+	}
+}
@@ -0,0 +1,289 @@
+package index_test
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+// newTestLibrary creates a migrated db containing a small library of media
+func newTestLibrary(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	err := index.CreateIndex(db)
+	assert.NilError(t, err)
+
+	indexMedia := index.NewSqliteIndexer(db)
+	library := []app.Media{
+		{
+			MediaMetadata: app.MediaMetadata{
+				Hash:        "hash-tokyo",
+				Date:        time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC),
+				CameraMake:  "Apple",
+				CameraModel: "iPhone 12",
+				MimeType:    "image/jpeg",
+			},
+			Location: app.Location{
+				Country:     app.Country{Long: "Japan", Short: "JP"},
+				Region:      "Tokyo",
+				Locality:    "Shibuya",
+				Coordinates: app.Coordinates{Lat: 35.6, Lng: 139.7},
+			},
+			Caption: "crossing at night",
+		},
+		{
+			MediaMetadata: app.MediaMetadata{
+				Hash:        "hash-kyoto",
+				Date:        time.Date(2023, time.April, 20, 9, 0, 0, 0, time.UTC),
+				CameraMake:  "Apple",
+				CameraModel: "iPhone 12",
+				MimeType:    "image/jpeg",
+			},
+			Location: app.Location{
+				Country:     app.Country{Long: "Japan", Short: "JP"},
+				Region:      "Kyoto",
+				Coordinates: app.Coordinates{Lat: 35.0, Lng: 135.7},
+			},
+		},
+		{
+			MediaMetadata: app.MediaMetadata{
+				Hash:        "hash-leeds",
+				Date:        time.Date(2023, time.May, 1, 9, 0, 0, 0, time.UTC),
+				CameraMake:  "Fairphone",
+				CameraModel: "FP3",
+				MimeType:    "video/mp4",
+			},
+		},
+	}
+	for _, m := range library {
+		_, err := indexMedia(m)
+		assert.NilError(t, err)
+	}
+	err = index.NewUpdateMediaTag(db)("hash-kyoto", "hiking")
+	assert.NilError(t, err)
+	err = index.NewExportMedia(db)("hash-tokyo")
+	assert.NilError(t, err)
+
+	return db
+}
+
+func TestParseMediaQuery(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		query         string
+		expectedTerms []index.QueryTerm
+		expectError   bool
+	}{
+		{
+			desc:  "it parses fields, quoted values, negation and text",
+			query: `camera:"Apple iPhone 12" country:Japan  -exported tag:hiking ferry`,
+			expectedTerms: []index.QueryTerm{
+				{Field: "camera", Value: "Apple iPhone 12"},
+				{Field: "country", Value: "Japan"},
+				{Field: "is", Value: "exported", Negate: true},
+				{Field: "tag", Value: "hiking"},
+				{Value: "ferry"},
+			},
+		},
+		{
+			desc:  "it parses quoted text",
+			query: `"ferry to" -Country:"United Kingdom"`,
+			expectedTerms: []index.QueryTerm{
+				{Value: "ferry to"},
+				{Field: "country", Value: "United Kingdom", Negate: true},
+			},
+		},
+		{
+			desc:        "unterminated quote is an error",
+			query:       `camera:"Apple`,
+			expectError: true,
+		},
+		{
+			desc:        "field without value is an error",
+			query:       `camera: tag:hiking`,
+			expectError: true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			terms, err := index.ParseMediaQuery(tC.query)
+			if tC.expectError {
+				assert.Assert(t, errors.Is(err, app.ErrInvalidQuery))
+				return
+			}
+			assert.NilError(t, err)
+			assert.DeepEqual(t, tC.expectedTerms, terms)
+		})
+	}
+}
+
+func TestMediaLister(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		query         app.MediaQuery
+		expectedIDs   []string
+		expectedTotal int
+		expectError   bool
+	}{
+		{
+			desc:          "empty query lists all media newest first",
+			query:         app.MediaQuery{},
+			expectedIDs:   []string{"hash-leeds", "hash-kyoto", "hash-tokyo"},
+			expectedTotal: 3,
+		},
+		{
+			desc:          "it combines criteria",
+			query:         app.MediaQuery{Query: `camera:"Apple iPhone 12" country:japan after:2023-04 before:2023-05 has:gps -exported tag:hiking`},
+			expectedIDs:   []string{"hash-kyoto"},
+			expectedTotal: 1,
+		},
+		{
+			desc:          "it filters by date",
+			query:         app.MediaQuery{Query: `on:2023-04`, Sort: app.MediaSortDateAsc},
+			expectedIDs:   []string{"hash-tokyo", "hash-kyoto"},
+			expectedTotal: 2,
+		},
+		{
+			desc:          "camera wildcards match literally",
+			query:         app.MediaQuery{Query: `camera:% camera:i_hone`},
+			expectedIDs:   []string{},
+			expectedTotal: 0,
+		},
+		{
+			desc:          "it matches part of the camera",
+			query:         app.MediaQuery{Query: `camera:iphone`},
+			expectedIDs:   []string{"hash-kyoto", "hash-tokyo"},
+			expectedTotal: 2,
+		},
+		{
+			desc:          "negated fields include media without a value",
+			query:         app.MediaQuery{Query: `-region:tokyo`},
+			expectedIDs:   []string{"hash-leeds", "hash-kyoto"},
+			expectedTotal: 2,
+		},
+		{
+			desc:          "it filters by type and export state",
+			query:         app.MediaQuery{Query: `type:photo exported`},
+			expectedIDs:   []string{"hash-tokyo"},
+			expectedTotal: 1,
+		},
+		{
+			desc:          "it matches free text",
+			query:         app.MediaQuery{Query: `crossing`},
+			expectedIDs:   []string{"hash-tokyo"},
+			expectedTotal: 1,
+		},
+		{
+			desc:          "it paginates",
+			query:         app.MediaQuery{Limit: 1, Offset: 1},
+			expectedIDs:   []string{"hash-kyoto"},
+			expectedTotal: 3,
+		},
+		{
+			desc:          "it sorts by import order",
+			query:         app.MediaQuery{Sort: app.MediaSortImported},
+			expectedIDs:   []string{"hash-leeds", "hash-kyoto", "hash-tokyo"},
+			expectedTotal: 3,
+		},
+		{
+			desc:        "unknown field is an error",
+			query:       app.MediaQuery{Query: `lens:50mm`},
+			expectError: true,
+		},
+		{
+			desc:        "invalid date is an error",
+			query:       app.MediaQuery{Query: `after:april`},
+			expectError: true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// arrange
+			db := newTestLibrary(t)
+			listMedia := index.NewSqliteMediaLister(db)
+
+			// act
+			page, err := listMedia(tC.query)
+
+			// assert
+			if tC.expectError {
+				assert.Assert(t, errors.Is(err, app.ErrInvalidQuery))
+				return
+			}
+			assert.NilError(t, err)
+			actualIDs := []string{}
+			for _, m := range page.Media {
+				actualIDs = append(actualIDs, m.ID)
+			}
+			assert.DeepEqual(t, tC.expectedIDs, actualIDs)
+			assert.Equal(t, page.Total, tC.expectedTotal)
+		})
+	}
+}
+
+func TestMediaListerDates(t *testing.T) {
+	// arrange
+	db := newTestLibrary(t)
+	_, err := index.NewSqliteIndexer(db)(app.Media{
+		MediaMetadata: app.MediaMetadata{
+			Hash: "hash-london",
+			Date: time.Date(2023, time.May, 1, 0, 30, 0, 0, time.FixedZone("BST", 60*60)),
+		},
+	})
+	assert.NilError(t, err)
+	listMedia := index.NewSqliteMediaLister(db)
+
+	testCases := []struct {
+		desc        string
+		query       string
+		expectedIDs []string
+	}{
+		{
+			desc:        "on matches the local date media was taken",
+			query:       `on:2023-05-01`,
+			expectedIDs: []string{"hash-leeds", "hash-london"},
+		},
+		{
+			desc:        "the day before in utc does not match",
+			query:       `on:2023-04-30`,
+			expectedIDs: []string{},
+		},
+		{
+			desc:        "after includes the date given",
+			query:       `after:2023-05-01`,
+			expectedIDs: []string{"hash-leeds", "hash-london"},
+		},
+		{
+			desc:        "before excludes the date given",
+			query:       `before:2023-05-01`,
+			expectedIDs: []string{"hash-kyoto", "hash-tokyo"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// act
+			page, err := listMedia(app.MediaQuery{Query: tC.query})
+
+			// assert
+			assert.NilError(t, err)
+			actualIDs := []string{}
+			for _, m := range page.Media {
+				actualIDs = append(actualIDs, m.ID)
+			}
+			assert.DeepEqual(t, tC.expectedIDs, actualIDs)
+		})
+	}
+
+	t.Run("it matches the month collections", func(t *testing.T) {
+		detail, err := index.NewSqliteCollectionDetail(db)("timeline_month__2023-05")
+		assert.NilError(t, err)
+		page, err := listMedia(app.MediaQuery{Query: `on:2023-05`})
+		assert.NilError(t, err)
+		assert.Equal(t, page.Total, len(detail.Media))
+	})
+}
@@ -0,0 +1,31 @@
+package index
+
+import (
+	"context"
+	"database/sql"
+)
+
+// CopyGPXPoints copies the gpx points of the db at srcFilename into db, gpx
+// points can not be recovered from the media store
+func CopyGPXPoints(db *sql.DB, srcFilename string) (int64, error) {
+	ctx := context.Background()
+	// attached databases only exist on the connection that attached them
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `ATTACH DATABASE ? AS src;`, srcFilename)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.ExecContext(ctx, `DETACH DATABASE src;`)
+
+	res, err := conn.ExecContext(ctx, `INSERT OR IGNORE INTO gpx (timestamp, lat, lng)
+		SELECT timestamp, lat, lng FROM src.gpx;`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
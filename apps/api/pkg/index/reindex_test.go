@@ -0,0 +1,41 @@
+package index_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestCopyGPXPoints(t *testing.T) {
+	// arrange
+	srcFilename := filepath.Join(t.TempDir(), "inari-media-db.db")
+	src, err := sql.Open("sqlite3", srcFilename)
+	assert.NilError(t, err)
+	defer src.Close()
+	assert.NilError(t, index.CreateIndex(src))
+	at := time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC)
+	assert.NilError(t, index.NewSaveGPXPoints(src)([]app.GPXPoint{
+		{Timestamp: at, Location: app.Location{Coordinates: app.Coordinates{Lat: 35.6, Lng: 139.7}}},
+		{Timestamp: at.Add(time.Hour), Location: app.Location{Coordinates: app.Coordinates{Lat: 35.7, Lng: 139.8}}},
+	}))
+	db := newTestDB(t)
+	assert.NilError(t, index.CreateIndex(db))
+
+	// act
+	copied, err := index.CopyGPXPoints(db, srcFilename)
+	assert.NilError(t, err)
+	copiedAgain, err := index.CopyGPXPoints(db, srcFilename)
+	assert.NilError(t, err)
+
+	// assert
+	assert.Equal(t, copied, int64(2))
+	assert.Equal(t, copiedAgain, int64(0))
+	point, err := index.NewQueryNearestGPX(db, 8)(at.Add(time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, point.Lat, 35.6)
+}
@@ -0,0 +1,185 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+const (
+	searchResultLimit = 100
+	searchEllipsis    = "…"
+
+	// snippets are marked with private use characters so the caption can be
+	// escaped before the markers become <mark> tags
+	searchMarkStart = "\uE000"
+	searchMarkEnd   = "\uE001"
+)
+
+// snippetReplacer turns the escaped snippet markers into <mark> tags
+var snippetReplacer = strings.NewReplacer(searchMarkStart, "<mark>", searchMarkEnd, "</mark>")
+
+// highlightSnippet HTML escapes a snippet, the <mark> tags around matches
+// are the only markup
+func highlightSnippet(snippet string) string {
+	return snippetReplacer.Replace(html.EscapeString(snippet))
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// migrateSearchIndex creates the media_search full text index. FTS5 is used when
+// sqlite is built with it (go build -tags sqlite_fts5), otherwise we fall back to FTS4
+func migrateSearchIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS media_search USING fts5(
+		media_id UNINDEXED,
+		caption,
+		title,
+		keywords,
+		tags,
+		places,
+		tokenize = 'unicode61 remove_diacritics 2'
+	);`)
+	if err != nil && strings.Contains(err.Error(), "no such module") {
+		_, err = tx.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS media_search USING fts4(
+			media_id,
+			caption,
+			title,
+			keywords,
+			tags,
+			places,
+			notindexed=media_id,
+			tokenize=unicode61 "remove_diacritics=2"
+		);`)
+	}
+	if err != nil {
+		return err
+	}
+
+	allMedia, err := selectAllMedia(tx)
+	if err != nil {
+		return err
+	}
+	for _, media := range allMedia {
+		err = updateSearchIndex(tx, media)
+		if err != nil {
+			return fmt.Errorf("failed to index media %s: %w", media.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// updateSearchIndex replaces the full text entry for media
+func updateSearchIndex(db execer, media app.Media) error {
+	_, err := db.Exec(`DELETE FROM media_search WHERE media_id = ?;`, media.ID)
+	if err != nil {
+		return err
+	}
+
+	tags := []string{}
+	for _, c := range media.Collections {
+		if c.Type == app.CollectionTypeHashTag {
+			tags = append(tags, c.Title)
+		}
+	}
+	places := []string{}
+	for _, p := range []string{media.Location.Locality, media.Location.Region, media.Location.Country.Long} {
+		if p != "" {
+			places = append(places, p)
+		}
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO media_search (media_id, caption, title, keywords, tags, places) VALUES (?,?,?,?,?,?);`,
+		media.ID,
+		media.Caption,
+		media.Title,
+		media.Keywords,
+		strings.Join(tags, " "),
+		strings.Join(places, " "),
+	)
+	return err
+}
+
+func searchUsesFTS5(db queryer) (bool, error) {
+	tableSQL := ""
+	err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'media_search';`).Scan(&tableSQL)
+	if err != nil {
+		return false, err
+	}
+	return strings.Contains(strings.ToLower(tableSQL), "fts5"), nil
+}
+
+// parseSearchQuery turns free text into an fts MATCH expression, every word is
+// treated as a prefix and all words must match
+func parseSearchQuery(query string) string {
+	terms := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, term := range terms {
+		terms[i] = term + "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+func NewSqliteSearchMedia(db *sql.DB) app.SearchMedia {
+	return func(query string) ([]app.SearchResult, error) {
+		out := []app.SearchResult{}
+
+		matchQuery := parseSearchQuery(query)
+		if matchQuery == "" {
+			return out, nil
+		}
+
+		isFTS5, err := searchUsesFTS5(db)
+		if err != nil {
+			return out, err
+		}
+
+		// fts4 has no built in ranking so we fall back to newest first
+		snippet := fmt.Sprintf(`snippet(media_search, '%s', '%s', '%s', -1, 12)`, searchMarkStart, searchMarkEnd, searchEllipsis)
+		orderBy := `media.taken_at DESC`
+		if isFTS5 {
+			snippet = fmt.Sprintf(`snippet(media_search, -1, '%s', '%s', '%s', 12)`, searchMarkStart, searchMarkEnd, searchEllipsis)
+			orderBy = `bm25(media_search), media.taken_at DESC`
+		}
+
+		q := fmt.Sprintf(`SELECT
+			%s,
+			%s
+			FROM media_search
+			INNER JOIN media ON media.id = media_search.media_id
+			WHERE media_search MATCH ? AND media.date_deleted IS NULL
+			ORDER BY %s
+			LIMIT ?;
+			`, mediaColumns, snippet, orderBy)
+		rows, err := db.Query(q, matchQuery, searchResultLimit)
+		if err != nil {
+			return out, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			r := app.SearchResult{}
+			r.Media, err = scanMedia(rows, &r.Snippet)
+			if err != nil {
+				return out, err
+			}
+			r.Snippet = highlightSnippet(r.Snippet)
+
+			out = append(out, r)
+		}
+
+		return out, rows.Err()
+	}
+}
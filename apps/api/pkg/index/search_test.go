@@ -0,0 +1,138 @@
+package index_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestSearchMedia(t *testing.T) {
+	testCases := []struct {
+		desc            string
+		query           string
+		expectedIDs     []string
+		expectedSnippet string
+	}{
+		{
+			desc:            "it matches captions and highlights the match",
+			query:           "ferry",
+			expectedIDs:     []string{"hash-ferry"},
+			expectedSnippet: "<mark>Ferry</mark> to Rotterdam",
+		},
+		{
+			desc:            "it escapes captions in the snippet",
+			query:           "boat",
+			expectedIDs:     []string{"hash-boat"},
+			expectedSnippet: `&lt;b&gt;<mark>Boat</mark>&lt;/b&gt; &amp; <mark>boating</mark>`,
+		},
+		{
+			desc:        "it matches word prefixes",
+			query:       "rotter",
+			expectedIDs: []string{"hash-ferry"},
+		},
+		{
+			desc:        "it matches hashtags",
+			query:       "hiking",
+			expectedIDs: []string{"hash-leeds"},
+		},
+		{
+			desc:        "it matches places",
+			query:       "west yorkshire",
+			expectedIDs: []string{"hash-leeds"},
+		},
+		{
+			desc:        "it matches keywords",
+			query:       "holiday",
+			expectedIDs: []string{"hash-ferry"},
+		},
+		{
+			desc:        "it ignores fts syntax in the query",
+			query:       `"ferry" -rotter*`,
+			expectedIDs: []string{"hash-ferry"},
+		},
+		{
+			desc:        "it does not return deleted media",
+			query:       "deleted",
+			expectedIDs: []string{},
+		},
+		{
+			desc:        "empty query returns nothing",
+			query:       "  ",
+			expectedIDs: []string{},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// arrange
+			db := newTestDB(t)
+			err := index.CreateIndex(db)
+			assert.NilError(t, err)
+			indexMedia := index.NewSqliteIndexer(db)
+			updateTag := index.NewUpdateMediaTag(db)
+			updateCaption := index.NewUpdateMediaCaption(db)
+			deleteMedia := index.NewDeleteMedia(db)
+			searchMedia := index.NewSqliteSearchMedia(db)
+
+			_, err = indexMedia(app.Media{
+				MediaMetadata: app.MediaMetadata{
+					Hash:     "hash-ferry",
+					Date:     time.Date(2014, time.March, 21, 8, 1, 18, 0, time.UTC),
+					Title:    "Ferry to Rotterdam",
+					Keywords: "holiday",
+				},
+				Caption: "Ferry to Rotterdam",
+			})
+			assert.NilError(t, err)
+			_, err = indexMedia(app.Media{
+				MediaMetadata: app.MediaMetadata{
+					Hash: "hash-leeds",
+					Date: time.Date(2022, time.January, 3, 13, 45, 40, 0, time.UTC),
+				},
+				Location: app.Location{
+					Country:  app.Country{Long: "United Kingdom", Short: "GB"},
+					Region:   "West Yorkshire",
+					Locality: "Leeds",
+				},
+			})
+			assert.NilError(t, err)
+			_, err = indexMedia(app.Media{
+				MediaMetadata: app.MediaMetadata{
+					Hash: "hash-boat",
+					Date: time.Date(2015, time.June, 2, 10, 0, 0, 0, time.UTC),
+				},
+				Caption: "<b>Boat</b> & boating",
+			})
+			assert.NilError(t, err)
+			err = updateTag("hash-leeds", "hiking")
+			assert.NilError(t, err)
+			_, err = indexMedia(app.Media{
+				MediaMetadata: app.MediaMetadata{
+					Hash: "hash-deleted",
+					Date: time.Date(2022, time.January, 4, 13, 45, 40, 0, time.UTC),
+				},
+			})
+			assert.NilError(t, err)
+			err = updateCaption("hash-deleted", "deleted photo")
+			assert.NilError(t, err)
+			err = deleteMedia("hash-deleted")
+			assert.NilError(t, err)
+
+			// act
+			results, err := searchMedia(tC.query)
+			assert.NilError(t, err)
+
+			// assert
+			actualIDs := []string{}
+			for _, r := range results {
+				actualIDs = append(actualIDs, r.Media.ID)
+			}
+			assert.DeepEqual(t, tC.expectedIDs, actualIDs)
+			if tC.expectedSnippet != "" {
+				assert.Equal(t, results[0].Snippet, tC.expectedSnippet)
+			}
+		})
+	}
+}
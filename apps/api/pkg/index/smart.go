@@ -0,0 +1,119 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/gosimple/slug"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+func migrateSmartCollections(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE collection ADD COLUMN query TEXT;`,
+	)
+}
+
+// NewSqliteCreateSmartCollection saves a media query as a collection,
+// saving an existing smart collection title replaces its query
+func NewSqliteCreateSmartCollection(db *sql.DB) app.CreateSmartCollection {
+	return func(collectionName, query string) (app.Collection, error) {
+		_, _, err := compileMediaQuery(query)
+		if err != nil {
+			return app.Collection{}, err
+		}
+
+		collectionID := slug.Make(fmt.Sprintf("%s__%s", app.CollectionTypeSmart, collectionName))
+
+		_, err = db.Exec(
+			`INSERT INTO
+		collection (id, collection_type, title, query)
+		VALUES (?,?,?,?)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, query = excluded.query;
+		`,
+			collectionID,
+			app.CollectionTypeSmart,
+			collectionName,
+			query)
+		if err != nil {
+			return app.Collection{}, err
+		}
+
+		return app.Collection{
+			ID:    collectionID,
+			Title: collectionName,
+			Type:  app.CollectionTypeSmart,
+			Query: query,
+		}, nil
+	}
+}
+
+func listSmartCollections(db *sql.DB) ([]app.Collection, error) {
+	out := []app.Collection{}
+
+	rows, err := db.Query(
+		`SELECT id, collection_type, title, COALESCE(query, '')
+		FROM collection
+		WHERE collection_type = ?
+		ORDER BY id DESC;`,
+		app.CollectionTypeSmart)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c := app.Collection{}
+		err = rows.Scan(&c.ID, &c.Type, &c.Title, &c.Query)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, c)
+	}
+	if err = rows.Err(); err != nil {
+		return out, err
+	}
+
+	for i, c := range out {
+		out[i], err = countSmartCollection(db, c)
+		if err != nil {
+			return out, fmt.Errorf("failed to count smart collection %s: %w", c.ID, err)
+		}
+	}
+
+	return out, nil
+}
+
+func fetchSmartCollectionByID(db *sql.DB, collectionID string) (app.Collection, error) {
+	c := app.Collection{}
+	err := db.QueryRow(
+		`SELECT id, collection_type, title, COALESCE(query, '')
+		FROM collection
+		WHERE id = ? AND collection_type = ?;`,
+		collectionID,
+		app.CollectionTypeSmart,
+	).Scan(&c.ID, &c.Type, &c.Title, &c.Query)
+	if err != nil {
+		return c, err
+	}
+
+	return countSmartCollection(db, c)
+}
+
+func countSmartCollection(db *sql.DB, c app.Collection) (app.Collection, error) {
+	where, args, err := compileMediaQuery(c.Query)
+	if err != nil {
+		return c, err
+	}
+
+	err = db.QueryRow(
+		`SELECT
+		count(*),
+		count(media.date_exported),
+		count(CASE WHEN media.triage_state = 'new' THEN 1 END)
+		FROM media WHERE `+where+`;`,
+		args...,
+	).Scan(&c.MediaCount, &c.ExportedCount, &c.InboxCount)
+
+	return c, err
+}
@@ -0,0 +1,77 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestSmartCollections(t *testing.T) {
+	t.Run("it lists smart collections with live counts", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		createSmartCollection := index.NewSqliteCreateSmartCollection(db)
+		listCollections := index.NewSqliteCollectionLister(db)
+		indexMedia := index.NewSqliteIndexer(db)
+
+		// act
+		c, err := createSmartCollection("Japan 2023", "country:japan after:2023")
+		assert.NilError(t, err)
+		_, err = indexMedia(app.Media{
+			MediaMetadata: app.MediaMetadata{
+				Hash: "hash-osaka",
+				Date: time.Date(2023, time.April, 21, 9, 0, 0, 0, time.UTC),
+			},
+			Location: app.Location{Country: app.Country{Long: "Japan", Short: "JP"}},
+		})
+		assert.NilError(t, err)
+		collections, err := listCollections(app.CollectionTypeSmart)
+		assert.NilError(t, err)
+
+		// assert
+		assert.DeepEqual(t, collections, []app.Collection{
+			{
+				ID:            c.ID,
+				Title:         "Japan 2023",
+				Type:          app.CollectionTypeSmart,
+				Query:         "country:japan after:2023",
+				MediaCount:    3,
+				ExportedCount: 1,
+				InboxCount:    3,
+			},
+		})
+	})
+
+	t.Run("collection detail evaluates the saved query", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		createSmartCollection := index.NewSqliteCreateSmartCollection(db)
+		collectionDetail := index.NewSqliteCollectionDetail(db)
+
+		// act
+		_, err := createSmartCollection("iphone", "has:gps")
+		assert.NilError(t, err)
+		c, err := createSmartCollection("iphone", `camera:"iPhone 12" -exported`)
+		assert.NilError(t, err)
+		detail, err := collectionDetail(c.ID)
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, detail.CollectionMeta.Query, `camera:"iPhone 12" -exported`)
+		assert.Equal(t, detail.CollectionMeta.MediaCount, 1)
+		assert.Equal(t, len(detail.Media), 1)
+		assert.Equal(t, detail.Media[0].ID, "hash-kyoto")
+	})
+
+	t.Run("it rejects invalid queries", func(t *testing.T) {
+		db := newTestLibrary(t)
+		createSmartCollection := index.NewSqliteCreateSmartCollection(db)
+
+		_, err := createSmartCollection("broken", "lens:50mm")
+		assert.Assert(t, errors.Is(err, app.ErrInvalidQuery))
+	})
+}
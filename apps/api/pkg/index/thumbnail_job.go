@@ -0,0 +1,153 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+func migrateThumbnailJobs(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE IF NOT EXISTS
+		thumbnail_job (
+			media_id TEXT NOT NULL PRIMARY KEY,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at INTEGER NOT NULL,
+			last_error TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS
+		idx_thumbnail_job_due ON thumbnail_job (status, next_attempt_at);`,
+	)
+}
+
+// NewSqliteQueueThumbnails adds a job to create the thumbnails of a media,
+// queueing media that already has a job resets it
+func NewSqliteQueueThumbnails(db *sql.DB) app.QueueThumbnails {
+	return func(mediaID string) error {
+		_, err := db.Exec(`INSERT INTO thumbnail_job (media_id, status, attempts, next_attempt_at, last_error)
+			VALUES (?, ?, 0, ?, NULL)
+			ON CONFLICT (media_id) DO UPDATE SET
+				status = excluded.status,
+				attempts = 0,
+				next_attempt_at = excluded.next_attempt_at,
+				last_error = NULL;`,
+			mediaID,
+			app.ThumbnailJobPending,
+			time.Now().Unix(),
+		)
+		return err
+	}
+}
+
+// NewSqliteNextThumbnailJob returns the pending job that has been due the longest
+func NewSqliteNextThumbnailJob(db *sql.DB) app.NextThumbnailJob {
+	return func(now time.Time) (app.ThumbnailJob, error) {
+		job := app.ThumbnailJob{}
+		nextAttemptAt := int64(0)
+		lastError := sql.NullString{}
+		err := db.QueryRow(`SELECT media_id, status, attempts, next_attempt_at, last_error
+			FROM thumbnail_job
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY next_attempt_at ASC, media_id ASC
+			LIMIT 1;`,
+			app.ThumbnailJobPending,
+			now.Unix(),
+		).Scan(&job.MediaID, &job.Status, &job.Attempts, &nextAttemptAt, &lastError)
+		if err == sql.ErrNoRows {
+			return job, fmt.Errorf("%w: no thumbnail jobs due", app.ErrNotFound)
+		}
+		if err != nil {
+			return job, err
+		}
+		job.NextAttemptAt = time.Unix(nextAttemptAt, 0).UTC()
+		job.LastError = lastError.String
+
+		return job, nil
+	}
+}
+
+func NewSqliteRetryThumbnailJob(db *sql.DB) app.RetryThumbnailJob {
+	return func(job app.ThumbnailJob) error {
+		_, err := db.Exec(`UPDATE thumbnail_job SET
+			status = ?,
+			attempts = ?,
+			next_attempt_at = ?,
+			last_error = ?
+			WHERE media_id = ?;`,
+			job.Status,
+			job.Attempts,
+			job.NextAttemptAt.Unix(),
+			job.LastError,
+			job.MediaID,
+		)
+		return err
+	}
+}
+
+// NewSqliteFailThumbnails records why the thumbnails of a media could not be
+// created, the media is no longer pending
+func NewSqliteFailThumbnails(db *sql.DB) app.FailThumbnails {
+	return func(mediaID string, reason string) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		media, err := fetchMediaByID(tx, mediaID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+		if err != nil {
+			return err
+		}
+		media.ThumbnailsPending = false
+		media.ThumbnailsFailed = reason
+
+		err = updateMediaDataByID(tx, media)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
+
+// saveThumbnails updates the thumbnails of a media, the media is no longer
+// pending or failed and any queued job is removed. Nothing is saved when
+// update returns an error
+func saveThumbnails(db *sql.DB, mediaID string, update func(media *app.Media) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	media, err := fetchMediaByID(tx, mediaID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+	}
+	if err != nil {
+		return err
+	}
+	err = update(&media)
+	if err != nil {
+		return err
+	}
+	media.ThumbnailsPending = false
+	media.ThumbnailsFailed = ""
+
+	err = updateMediaDataByID(tx, media)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM thumbnail_job WHERE media_id = ?;`, mediaID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,144 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestThumbnailJobs(t *testing.T) {
+	t.Run("jobs are due in order until their thumbnails are saved", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		queueThumbnails := index.NewSqliteQueueThumbnails(db)
+		nextJob := index.NewSqliteNextThumbnailJob(db)
+		retryJob := index.NewSqliteRetryThumbnailJob(db)
+		saveThumbnails := index.NewSqliteUpdateThumbnails(db)
+		now := time.Now().Add(time.Second)
+		assert.NilError(t, queueThumbnails("hash-tokyo"))
+		assert.NilError(t, queueThumbnails("hash-kyoto"))
+
+		// act
+		first, err := nextJob(now)
+		assert.NilError(t, err)
+		first.Attempts = 1
+		first.LastError = "resize failed"
+		first.NextAttemptAt = now.Add(time.Minute)
+		assert.NilError(t, retryJob(first))
+		second, err := nextJob(now)
+		assert.NilError(t, err)
+		assert.NilError(t, saveThumbnails(second.MediaID, app.MediaSrc{Large: "lg_" + second.MediaID + ".jpg"}))
+		_, noneDue := nextJob(now)
+		retried, err := nextJob(now.Add(time.Minute))
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, first.MediaID, "hash-kyoto")
+		assert.Equal(t, second.MediaID, "hash-tokyo")
+		assert.Assert(t, errors.Is(noneDue, app.ErrNotFound))
+		assert.Equal(t, retried.MediaID, "hash-kyoto")
+		assert.Equal(t, retried.Attempts, 1)
+		assert.Equal(t, retried.LastError, "resize failed")
+	})
+
+	t.Run("saving thumbnails clears pending media", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		getMedia := index.NewQueryMediaDetail(db)
+		media, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		media.ThumbnailsPending = true
+		_, err = index.NewSqliteIndexer(db)(media)
+		assert.NilError(t, err)
+		assert.NilError(t, index.NewSqliteQueueThumbnails(db)("hash-tokyo"))
+
+		// act
+		err = index.NewSqliteUpdateThumbnails(db)("hash-tokyo", app.MediaSrc{Large: "lg_tokyo.jpg"})
+		assert.NilError(t, err)
+
+		// assert
+		media, err = getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Equal(t, media.ThumbnailsPending, false)
+		assert.Equal(t, media.Thumbnails.Large, "lg_tokyo.jpg")
+		_, err = index.NewSqliteNextThumbnailJob(db)(time.Now().Add(time.Hour))
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+
+	t.Run("failed jobs are not retried", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		nextJob := index.NewSqliteNextThumbnailJob(db)
+		assert.NilError(t, index.NewSqliteQueueThumbnails(db)("hash-tokyo"))
+		job, err := nextJob(time.Now().Add(time.Second))
+		assert.NilError(t, err)
+		job.Status = app.ThumbnailJobFailed
+
+		// act
+		err = index.NewSqliteRetryThumbnailJob(db)(job)
+		assert.NilError(t, err)
+
+		// assert
+		_, err = nextJob(time.Now().Add(24 * time.Hour))
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+
+	t.Run("failed thumbnails are recorded until thumbnails are saved", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		getMedia := index.NewQueryMediaDetail(db)
+		media, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		media.ThumbnailsPending = true
+		_, err = index.NewSqliteIndexer(db)(media)
+		assert.NilError(t, err)
+
+		// act
+		err = index.NewSqliteFailThumbnails(db)("hash-tokyo", "unsupported format")
+		assert.NilError(t, err)
+		failed, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		err = index.NewSqliteUpdateThumbnails(db)("hash-tokyo", app.MediaSrc{Large: "lg_tokyo.jpg"})
+		assert.NilError(t, err)
+		saved, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, failed.ThumbnailsPending, false)
+		assert.Equal(t, failed.ThumbnailsFailed, "unsupported format")
+		assert.Equal(t, saved.ThumbnailsFailed, "")
+		assert.Assert(t, errors.Is(index.NewSqliteFailThumbnails(db)("missing", "err"), app.ErrNotFound))
+	})
+
+	t.Run("job thumbnails are not saved when the options have changed", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		getMedia := index.NewQueryMediaDetail(db)
+		saveJobThumbnails := index.NewSqliteSaveJobThumbnails(db)
+		assert.NilError(t, index.NewSqliteQueueThumbnails(db)("hash-tokyo"))
+		focus := app.ThumbnailOptions{FocusPoint: &app.FocusPoint{X: 0.2, Y: 0.8}}
+		err := index.NewSqliteSaveThumbnailOptions(db)("hash-tokyo", focus, app.MediaSrc{Large: "lg_tokyo_focus.jpg"})
+		assert.NilError(t, err)
+		assert.NilError(t, index.NewSqliteQueueThumbnails(db)("hash-tokyo"))
+
+		// act
+		staleErr := saveJobThumbnails("hash-tokyo", app.ThumbnailOptions{}, app.MediaSrc{Large: "lg_tokyo.jpg"})
+		stale, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		_, jobErr := index.NewSqliteNextThumbnailJob(db)(time.Now().Add(time.Hour))
+		err = saveJobThumbnails("hash-tokyo", focus, app.MediaSrc{Large: "lg_tokyo_focus2.jpg"})
+		assert.NilError(t, err)
+		saved, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+
+		// assert
+		assert.Assert(t, errors.Is(staleErr, app.ErrConflict))
+		assert.Equal(t, stale.Thumbnails.Large, "lg_tokyo_focus.jpg")
+		assert.NilError(t, jobErr)
+		assert.Equal(t, saved.Thumbnails.Large, "lg_tokyo_focus2.jpg")
+	})
+}
@@ -0,0 +1,111 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+// NewSqliteListTrash lists deleted media, most recently deleted first
+func NewSqliteListTrash(db *sql.DB) app.TrashLister {
+	return func() ([]app.Media, error) {
+		q := `SELECT ` + mediaColumns + `
+			FROM media
+			WHERE media.date_deleted IS NOT NULL
+			ORDER BY media.date_deleted DESC, media.id DESC;
+			`
+		rows, err := db.Query(q)
+		if err != nil {
+			return []app.Media{}, err
+		}
+
+		return scanMediaRows(rows)
+	}
+}
+
+func NewSqliteRestoreMedia(db *sql.DB) app.RestoreMedia {
+	return func(mediaID string) error {
+		res, err := db.Exec(
+			`UPDATE media SET date_deleted = NULL WHERE id = ? AND date_deleted IS NOT NULL;`,
+			mediaID)
+		if err != nil {
+			return err
+		}
+		restored, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if restored == 0 {
+			return fmt.Errorf("%w: media %s is not in the trash", app.ErrNotFound, mediaID)
+		}
+
+		return nil
+	}
+}
+
+// NewSqliteTrashMediaAt moves media into the trash as if it was deleted at
+// deletedAt, so it is purged when it would have been
+func NewSqliteTrashMediaAt(db *sql.DB) app.TrashMediaAt {
+	return func(mediaID string, deletedAt time.Time) error {
+		res, err := db.Exec(`UPDATE media SET date_deleted = ? WHERE id = ?;`,
+			deletedAt.Format(time.RFC3339Nano),
+			mediaID)
+		if err != nil {
+			return err
+		}
+		trashed, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if trashed == 0 {
+			return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+		}
+
+		return nil
+	}
+}
+
+// NewSqlitePurgeMedia permanently removes deleted media from the index
+// along with its collection memberships, search entry and thumbnail job
+func NewSqlitePurgeMedia(db *sql.DB) app.PurgeMedia {
+	return func(mediaID string) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`DELETE FROM media WHERE id = ? AND date_deleted IS NOT NULL;`, mediaID)
+		if err != nil {
+			return err
+		}
+		purged, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if purged == 0 {
+			return fmt.Errorf("%w: media %s is not in the trash", app.ErrNotFound, mediaID)
+		}
+
+		_, err = tx.Exec(`DELETE FROM media_collection WHERE media_id = ?;`, mediaID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM media_search WHERE media_id = ?;`, mediaID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM thumbnail_job WHERE media_id = ?;`, mediaID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE collection SET cover_media_id = NULL WHERE cover_media_id = ?;`, mediaID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}
+}
@@ -0,0 +1,94 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestTrash(t *testing.T) {
+	t.Run("deleted media is listed in the trash and can be restored", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		deleteMedia := index.NewDeleteMedia(db)
+		listTrash := index.NewSqliteListTrash(db)
+		restoreMedia := index.NewSqliteRestoreMedia(db)
+		assert.NilError(t, deleteMedia("hash-tokyo"))
+		assert.NilError(t, deleteMedia("hash-leeds"))
+
+		// act
+		trash, err := listTrash()
+		assert.NilError(t, err)
+		err = restoreMedia("hash-tokyo")
+		assert.NilError(t, err)
+		trashAfterRestore, err := listTrash()
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, len(trash), 2)
+		assert.Equal(t, trash[0].ID, "hash-leeds")
+		assert.Assert(t, trash[0].DeletedAt != nil)
+		assert.Equal(t, len(trashAfterRestore), 1)
+		tokyo, err := index.NewQueryMediaDetail(db)("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Assert(t, tokyo.DeletedAt == nil)
+
+		err = restoreMedia("hash-kyoto")
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+
+	t.Run("purged media is removed from the index", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		purgeMedia := index.NewSqlitePurgeMedia(db)
+		album, err := index.NewSqliteCreateCollection(db)("trip", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		assert.NilError(t, index.NewSqliteAddMediaToAlbum(db)(album.ID, "hash-kyoto"))
+		cover := "hash-kyoto"
+		_, err = index.NewSqliteUpdateAlbum(db)(album.ID, app.AlbumUpdate{CoverMediaID: &cover})
+		assert.NilError(t, err)
+
+		// act
+		errNotDeleted := purgeMedia("hash-kyoto")
+		assert.NilError(t, index.NewDeleteMedia(db)("hash-kyoto"))
+		err = purgeMedia("hash-kyoto")
+		assert.NilError(t, err)
+
+		// assert
+		assert.Assert(t, errors.Is(errNotDeleted, app.ErrNotFound))
+		_, err = index.NewQueryMediaDetail(db)("hash-kyoto")
+		assert.Assert(t, err != nil)
+		results, err := index.NewSqliteSearchMedia(db)("kyoto")
+		assert.NilError(t, err)
+		assert.Equal(t, len(results), 0)
+		hashtags, err := index.NewSqliteListHashtags(db)()
+		assert.NilError(t, err)
+		assert.Equal(t, hashtags[0].MediaCount, 0)
+		detail, err := index.NewSqliteCollectionDetail(db)(album.ID)
+		assert.NilError(t, err)
+		assert.Equal(t, detail.CollectionMeta.CoverMediaID, "")
+		assert.Equal(t, len(detail.Media), 0)
+	})
+
+	t.Run("media can be trashed at a given time", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		deletedAt := time.Date(2024, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+		// act
+		err := index.NewSqliteTrashMediaAt(db)("hash-tokyo", deletedAt)
+		assert.NilError(t, err)
+
+		// assert
+		tokyo, err := index.NewQueryMediaDetail(db)("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Assert(t, tokyo.DeletedAt != nil)
+		assert.Assert(t, tokyo.DeletedAt.Equal(deletedAt))
+		err = index.NewSqliteTrashMediaAt(db)("missing", deletedAt)
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+}
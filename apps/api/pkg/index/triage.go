@@ -0,0 +1,124 @@
+package index
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+func migrateTriageState(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE media ADD COLUMN triage_state TEXT NOT NULL DEFAULT 'new';`,
+		`CREATE INDEX IF NOT EXISTS idx_media_triage_state ON media (triage_state);`,
+	)
+}
+
+// NewSqliteSetTriageState moves media between triage states, reviewed and archived
+// media is removed from the inbox, media marked new goes back into the inbox
+func NewSqliteSetTriageState(db *sql.DB) app.SetTriageState {
+	return func(mediaIDs []string, state app.TriageState) error {
+		switch state {
+		case app.TriageStateNew, app.TriageStateReviewed, app.TriageStateArchived:
+		default:
+			return fmt.Errorf("%w: unknown triage state %s", app.ErrInvalidInput, state)
+		}
+
+		allMedia := []app.Media{}
+		for _, mediaID := range mediaIDs {
+			media, err := fetchMediaByID(db, mediaID)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: media %s", app.ErrNotFound, mediaID)
+			}
+			if err != nil {
+				return err
+			}
+			allMedia = append(allMedia, media)
+		}
+
+		for _, media := range allMedia {
+			_, err := db.Exec(`UPDATE media SET triage_state = ? WHERE id = ?;`, state, media.ID)
+			if err != nil {
+				return err
+			}
+			media.TriageState = state
+
+			if state == app.TriageStateNew {
+				media, err = addMediaToInbox(db, media)
+				if err != nil {
+					return err
+				}
+				err = updateMediaDataByID(db, media)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			for _, c := range media.Collections {
+				if c.Type != app.CollectionTypeInbox {
+					continue
+				}
+				media, err = removeMediaFromCollection(db, c.ID, media)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// NewSqliteNextUnreviewed finds the next new media after fromMediaID in collection
+// order, wrapping around to the start of the collection
+func NewSqliteNextUnreviewed(db *sql.DB) app.NextUnreviewedQuery {
+	return func(collectionID, fromMediaID string) (app.Media, error) {
+		src, err := fetchCollectionSource(db, collectionID)
+		if err != nil {
+			return app.Media{}, err
+		}
+		sortName, err := src.sort("")
+		if err != nil {
+			return app.Media{}, err
+		}
+		sort, err := newCollectionSort(sortName)
+		if err != nil {
+			return app.Media{}, err
+		}
+
+		where := fmt.Sprintf(`%s AND media.triage_state = ? AND media.id != ?`, src.where)
+		args := append(append([]any{}, src.args...), app.TriageStateNew, fromMediaID)
+		next := func(where string, args []any) (app.Media, error) {
+			return scanMedia(db.QueryRow(
+				fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT 1;`,
+					mediaColumns, src.from, where, sort.orderBy(false)),
+				args...,
+			))
+		}
+
+		// media after fromMediaID, when it is in the collection
+		cursor := mediaCursor{Sort: sortName}
+		err = db.QueryRow(
+			fmt.Sprintf(`SELECT %s FROM %s WHERE %s AND media.id = ?;`, src.cursorColumns(), src.from, src.where),
+			append(append([]any{}, src.args...), fromMediaID)...,
+		).Scan(cursor.dest()...)
+		if err != nil && err != sql.ErrNoRows {
+			return app.Media{}, err
+		}
+		if err == nil {
+			after, afterArgs := sort.after(cursor, false)
+			media, err := next(where+" AND "+after, append(append([]any{}, args...), afterArgs...))
+			if err != sql.ErrNoRows {
+				return media, err
+			}
+		}
+
+		// wrap around to the start of the collection
+		media, err := next(where, args)
+		if err == sql.ErrNoRows {
+			return app.Media{}, fmt.Errorf("%w: no unreviewed media in %s", app.ErrNotFound, collectionID)
+		}
+		return media, err
+	}
+}
@@ -0,0 +1,126 @@
+package index_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/index"
+	"gotest.tools/v3/assert"
+)
+
+func TestTriage(t *testing.T) {
+	t.Run("reviewed media leaves the inbox", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		setTriageState := index.NewSqliteSetTriageState(db)
+		getMedia := index.NewQueryMediaDetail(db)
+		listCollections := index.NewSqliteCollectionLister(db)
+
+		// act
+		err := setTriageState([]string{"hash-tokyo", "hash-kyoto"}, app.TriageStateReviewed)
+		assert.NilError(t, err)
+
+		// assert
+		tokyo, err := getMedia("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Equal(t, tokyo.TriageState, app.TriageStateReviewed)
+		assert.Assert(t, !hasCollection(tokyo, "inbox__2023-04"))
+		assert.Assert(t, hasCollection(tokyo, "timeline_month__2023-04"))
+
+		inboxes, err := listCollections(app.CollectionTypeInbox)
+		assert.NilError(t, err)
+		assert.Equal(t, len(inboxes), 1)
+		assert.Equal(t, inboxes[0].ID, "inbox__2023-05")
+
+		months, err := listCollections(app.CollectionTypeTimelineMonth)
+		assert.NilError(t, err)
+		inboxCounts := map[string]int{}
+		for _, c := range months {
+			inboxCounts[c.ID] = c.InboxCount
+		}
+		assert.DeepEqual(t, inboxCounts, map[string]int{
+			"timeline_month__2023-05": 1,
+			"timeline_month__2023-04": 0,
+		})
+	})
+
+	t.Run("new media goes back into the inbox", func(t *testing.T) {
+		db := newTestLibrary(t)
+		setTriageState := index.NewSqliteSetTriageState(db)
+
+		err := setTriageState([]string{"hash-tokyo"}, app.TriageStateArchived)
+		assert.NilError(t, err)
+		err = setTriageState([]string{"hash-tokyo"}, app.TriageStateNew)
+		assert.NilError(t, err)
+
+		tokyo, err := index.NewQueryMediaDetail(db)("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Equal(t, tokyo.TriageState, app.TriageStateNew)
+		assert.Assert(t, hasCollection(tokyo, "inbox__2023-04"))
+	})
+
+	t.Run("it rejects unknown states and media", func(t *testing.T) {
+		db := newTestLibrary(t)
+		setTriageState := index.NewSqliteSetTriageState(db)
+
+		err := setTriageState([]string{"hash-tokyo"}, "deleted")
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+		err = setTriageState([]string{"hash-tokyo", "missing"}, app.TriageStateReviewed)
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+
+		tokyo, err := index.NewQueryMediaDetail(db)("hash-tokyo")
+		assert.NilError(t, err)
+		assert.Equal(t, tokyo.TriageState, app.TriageStateNew)
+	})
+
+	t.Run("next unreviewed follows collection order and wraps around", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		setTriageState := index.NewSqliteSetTriageState(db)
+		nextUnreviewed := index.NewSqliteNextUnreviewed(db)
+		album, err := index.NewSqliteCreateCollection(db)("trip", app.CollectionTypeAlbum)
+		assert.NilError(t, err)
+		for _, id := range []string{"hash-tokyo", "hash-kyoto", "hash-leeds"} {
+			err = index.NewSqliteAddMediaToAlbum(db)(album.ID, id)
+			assert.NilError(t, err)
+		}
+		err = setTriageState([]string{"hash-kyoto"}, app.TriageStateReviewed)
+		assert.NilError(t, err)
+
+		// act
+		first, err := nextUnreviewed(album.ID, "")
+		assert.NilError(t, err)
+		afterTokyo, err := nextUnreviewed(album.ID, "hash-tokyo")
+		assert.NilError(t, err)
+		afterLeeds, err := nextUnreviewed(album.ID, "hash-leeds")
+		assert.NilError(t, err)
+		err = setTriageState([]string{"hash-tokyo", "hash-leeds"}, app.TriageStateReviewed)
+		assert.NilError(t, err)
+		_, noneErr := nextUnreviewed(album.ID, "hash-leeds")
+
+		// assert
+		assert.Equal(t, first.ID, "hash-tokyo")
+		assert.Equal(t, afterTokyo.ID, "hash-leeds")
+		assert.Equal(t, afterLeeds.ID, "hash-tokyo")
+		assert.Assert(t, errors.Is(noneErr, app.ErrNotFound))
+	})
+
+	t.Run("next unreviewed queries smart collections", func(t *testing.T) {
+		// arrange
+		db := newTestLibrary(t)
+		nextUnreviewed := index.NewSqliteNextUnreviewed(db)
+		smart, err := index.NewSqliteCreateSmartCollection(db)("japan", "country:japan")
+		assert.NilError(t, err)
+
+		// act
+		notInCollection, err := nextUnreviewed(smart.ID, "hash-leeds")
+		assert.NilError(t, err)
+		afterKyoto, err := nextUnreviewed(smart.ID, "hash-kyoto")
+		assert.NilError(t, err)
+
+		// assert
+		assert.Equal(t, notInCollection.ID, "hash-kyoto")
+		assert.Equal(t, afterKyoto.ID, "hash-tokyo")
+	})
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+type localFSStore struct {
+	dir string
+}
+
+// NewLocalFSStore keeps files under dir, keys are slash separated paths
+// relative to dir. Files are written to a synced temp file and renamed into
+// place so readers never see a partial file
+func NewLocalFSStore(dir string) app.Store {
+	return localFSStore{dir: dir}
+}
+
+func (s localFSStore) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("%w: key %s is outside the store", app.ErrInvalidInput, key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+func (s localFSStore) Put(key string, r io.Reader, contentType string) error {
+	dstFilename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dstFilename), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dstFilename), ".*-"+filepath.Base(dstFilename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = io.Copy(tmpFile, r)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpFile.Name(), dstFilename)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dstFilename))
+}
+
+func (s localFSStore) Get(key string) (app.StoredFile, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return app.StoredFile{}, err
+	}
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return app.StoredFile{}, fmt.Errorf("%w: file %s", app.ErrNotFound, key)
+	}
+	if err != nil {
+		return app.StoredFile{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return app.StoredFile{}, err
+	}
+
+	return app.StoredFile{ReadSeekCloser: file, ModTime: info.ModTime()}, nil
+}
+
+func (s localFSStore) Stat(key string) (app.StoredObject, error) {
+	filename, err := s.path(key)
+	if err != nil {
+		return app.StoredObject{}, err
+	}
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return app.StoredObject{}, fmt.Errorf("%w: file %s", app.ErrNotFound, key)
+	}
+	if err != nil {
+		return app.StoredObject{}, err
+	}
+
+	return app.StoredObject{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List returns every file whose key starts with prefix, temp files are skipped
+func (s localFSStore) List(prefix string) ([]app.StoredObject, error) {
+	out := []app.StoredObject{}
+	err := filepath.WalkDir(s.dir, func(filename string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && filename == s.dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, filename)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out = append(out, app.StoredObject{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+
+	return out, err
+}
+
+// Delete removes a file, files that are already gone are ignored
+func (s localFSStore) Delete(key string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s localFSStore) PresignURL(key string, expires time.Duration) (string, error) {
+	return "", fmt.Errorf("%w: local stores can not presign urls", app.ErrNotSupported)
+}
+
+// syncDir flushes dir so a rename into it survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+type s3Store struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+// NewS3Store keeps files in bucket under prefix, any S3 compatible endpoint
+// can be used by configuring the client's BaseEndpoint
+func NewS3Store(client *s3.Client, bucket, prefix string) app.Store {
+	return s3Store{
+		client:   client,
+		uploader: manager.NewUploader(client),
+		bucket:   bucket,
+		prefix:   prefix,
+	}
+}
+
+// Put streams r to the bucket, large files are uploaded in parts
+func (s s3Store) Put(key string, r io.Reader, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+		Body:   r,
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	_, err := s.uploader.Upload(context.Background(), input)
+	return err
+}
+
+// Get opens an object, reads are streamed from the current offset so
+// seeking only costs a new request when reading resumes
+func (s s3Store) Get(key string) (app.StoredFile, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return app.StoredFile{}, err
+	}
+
+	return app.StoredFile{
+		ReadSeekCloser: &s3Object{
+			client: s.client,
+			bucket: s.bucket,
+			key:    s.prefix + key,
+			size:   info.Size,
+		},
+		ModTime: info.ModTime,
+	}, nil
+}
+
+func (s s3Store) Stat(key string) (app.StoredObject, error) {
+	head, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if isS3NotFound(err) {
+		return app.StoredObject{}, fmt.Errorf("%w: object %s", app.ErrNotFound, key)
+	}
+	if err != nil {
+		return app.StoredObject{}, err
+	}
+
+	return app.StoredObject{
+		Key:     key,
+		Size:    aws.Int64Value(head.ContentLength),
+		ModTime: aws.TimeValue(head.LastModified),
+		ETag:    strings.Trim(aws.StringValue(head.ETag), `"`),
+	}, nil
+}
+
+func (s s3Store) List(prefix string) ([]app.StoredObject, error) {
+	out := []app.StoredObject{}
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix + prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return out, err
+		}
+		for _, object := range page.Contents {
+			out = append(out, app.StoredObject{
+				Key:     strings.TrimPrefix(aws.StringValue(object.Key), s.prefix),
+				Size:    aws.Int64Value(object.Size),
+				ModTime: aws.TimeValue(object.LastModified),
+				ETag:    strings.Trim(aws.StringValue(object.ETag), `"`),
+			})
+		}
+	}
+
+	return out, nil
+}
+
+func (s s3Store) Delete(key string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	})
+	if isS3NotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s s3Store) PresignURL(key string, expires time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.client).PresignGetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.prefix + key),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func isS3NotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	var responseErr interface{ HTTPStatusCode() int }
+	return errors.As(err, &notFound) ||
+		errors.As(err, &noSuchKey) ||
+		(errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == 404)
+}
+
+type s3Object struct {
+	client *s3.Client
+	bucket string
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		res, err := o.client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(o.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+		})
+		if err != nil {
+			return 0, err
+		}
+		o.body = res.Body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3 object: negative position")
+	}
+	if offset != o.offset {
+		o.Close()
+		o.offset = offset
+	}
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
@@ -1,15 +1,15 @@
 package storage
 
 import (
-	"bytes"
-	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
 
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
 )
 
@@ -19,23 +19,26 @@ func NewNullDownloader() app.Downloader {
 	}
 }
 
+// NewLocalFSDownloader copies a local file to a new temp file, the temp file
+// keeps the name of the source so files already in the temp dir are not
+// copied onto themselves
 func NewLocalFSDownloader() app.Downloader {
 	return func(srcFilename string) (string, error) {
-		dstFilename := filepath.Join(os.TempDir(), filepath.Base(srcFilename))
-		dstFile, err := os.Create(dstFilename)
+		srcFile, err := os.Open(srcFilename)
 		if err != nil {
 			return "", err
 		}
-		defer dstFile.Close()
+		defer srcFile.Close()
 
-		srcFile, err := os.Open(srcFilename)
+		dstFile, err := os.CreateTemp("", "inari-*-"+filepath.Base(srcFilename))
 		if err != nil {
 			return "", err
 		}
-		defer srcFile.Close()
+		defer dstFile.Close()
 
 		_, err = io.Copy(dstFile, srcFile)
 		if err != nil {
+			os.Remove(dstFile.Name())
 			return "", err
 		}
 
@@ -49,16 +52,23 @@ func NewNullUploader() app.Uploader {
 	}
 }
 
-func NewLocalFSUploader(dstRootDir string) app.Uploader {
-	return func(srcFilename, dstFilename string) error {
-		dstFilename = filepath.Join(dstRootDir, dstFilename)
-
-		if _, err := os.Stat(dstFilename); !os.IsNotExist(err) {
+// NewStoreUploader copies local files into store. Uploading to a key that
+// already has the same content does nothing, a key with different content is
+// an app.ErrConflict. Uploads are checked against the source once written
+func NewStoreUploader(store app.Store) app.Uploader {
+	return func(srcFilename, key string) error {
+		srcHash, err := fileHash(srcFilename)
+		if err != nil {
+			return err
+		}
+		matches, err := storedHashMatches(store, key, srcHash)
+		if err == nil {
+			if !matches {
+				return fmt.Errorf("%w: %s already exists with different content", app.ErrConflict, key)
+			}
 			return nil
 		}
-
-		err := os.MkdirAll(filepath.Dir(dstFilename), os.ModePerm)
-		if err != nil {
+		if !errors.Is(err, app.ErrNotFound) {
 			return err
 		}
 
@@ -67,38 +77,82 @@ func NewLocalFSUploader(dstRootDir string) app.Uploader {
 			return err
 		}
 		defer srcFile.Close()
-
-		dstFile, err := os.Create(dstFilename)
+		err = store.Put(key, srcFile, mime.TypeByExtension(path.Ext(key)))
 		if err != nil {
 			return err
 		}
-		defer dstFile.Close()
 
-		_, err = io.Copy(dstFile, srcFile)
+		matches, err = storedHashMatches(store, key, srcHash)
 		if err != nil {
 			return err
 		}
-
+		if !matches {
+			return fmt.Errorf("failed to verify %s: stored content does not match %s", key, srcHash)
+		}
 		return nil
 	}
 }
 
-func NewUploader(bucket string, uploader *manager.Uploader, s3Client *s3.Client) app.UploaderB {
-	return func(sourceData []byte, mediaStoreFilename string, contentType string) error {
-		file := bytes.NewReader(sourceData)
-
-		_, err := uploader.Upload(
-			context.Background(),
-			&s3.PutObjectInput{
-				Bucket:      aws.String(bucket),
-				Key:         aws.String(mediaStoreFilename),
-				Body:        file,
-				ContentType: aws.String(contentType),
-			})
+// NewStoreDownloader copies files out of store to a temp file, the temp file
+// keeps the extension of the key
+func NewStoreDownloader(store app.Store) app.Downloader {
+	return func(key string) (string, error) {
+		src, err := store.Get(key)
 		if err != nil {
-			return err
+			return "", err
 		}
+		defer src.Close()
 
-		return nil
+		dstFile, err := os.CreateTemp("", "inari-*-"+path.Base(key))
+		if err != nil {
+			return "", err
+		}
+		defer dstFile.Close()
+
+		_, err = io.Copy(dstFile, src)
+		if err != nil {
+			os.Remove(dstFile.Name())
+			return "", err
+		}
+
+		return dstFile.Name(), nil
+	}
+}
+
+// storedHashMatches reports whether a stored file has the md5 hash, an S3
+// ETag is the md5 of simple uploads, otherwise the file is read back
+func storedHashMatches(store app.Store, key, hash string) (bool, error) {
+	info, err := store.Stat(key)
+	if err != nil {
+		return false, err
+	}
+	if info.ETag == hash {
+		return true, nil
+	}
+
+	f, err := store.Get(key)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	storedHash, err := readerHash(f)
+	return storedHash == hash, err
+}
+
+// fileHash is the md5 of a file, the same hash media is identified by
+func fileHash(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readerHash(f)
+}
+
+func readerHash(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
 	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
@@ -0,0 +1,246 @@
+package storage_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
+	"gotest.tools/v3/assert"
+)
+
+func writeFile(t *testing.T, filename, content string) string {
+	t.Helper()
+	assert.NilError(t, os.MkdirAll(filepath.Dir(filename), 0o700))
+	assert.NilError(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func readStored(t *testing.T, store app.Store, key string) string {
+	t.Helper()
+	f, err := store.Get(key)
+	assert.NilError(t, err)
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	assert.NilError(t, err)
+	return string(content)
+}
+
+// testStores are every Store backend, the s3 store runs against an in-process fake
+var testStores = []struct {
+	name     string
+	newStore func(t *testing.T) app.Store
+}{
+	{
+		name: "local",
+		newStore: func(t *testing.T) app.Store {
+			return storage.NewLocalFSStore(filepath.Join(t.TempDir(), "media"))
+		},
+	},
+	{
+		name: "s3",
+		newStore: func(t *testing.T) app.Store {
+			return storage.NewS3Store(newFakeS3Client(t), "inari", "media/")
+		},
+	},
+}
+
+func TestStore(t *testing.T) {
+	for _, backend := range testStores {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("it puts, reads and lists files", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+
+				// act
+				assert.NilError(t, store.Put("2023/20230410_090000_hash-tokyo.jpg", strings.NewReader("tokyo"), "image/jpeg"))
+				assert.NilError(t, store.Put("2023/20230420_090000_hash-kyoto.jpg", strings.NewReader("kyoto!"), "image/jpeg"))
+				assert.NilError(t, store.Put("2024/20240101_090000_hash-leeds.jpg", strings.NewReader("leeds"), "image/jpeg"))
+
+				// assert
+				assert.Equal(t, readStored(t, store, "2023/20230410_090000_hash-tokyo.jpg"), "tokyo")
+				info, err := store.Stat("2023/20230420_090000_hash-kyoto.jpg")
+				assert.NilError(t, err)
+				assert.Equal(t, info.Key, "2023/20230420_090000_hash-kyoto.jpg")
+				assert.Equal(t, info.Size, int64(6))
+				listed, err := store.List("2023/")
+				assert.NilError(t, err)
+				keys := []string{}
+				for _, object := range listed {
+					keys = append(keys, object.Key)
+				}
+				assert.DeepEqual(t, keys, []string{"2023/20230410_090000_hash-tokyo.jpg", "2023/20230420_090000_hash-kyoto.jpg"})
+			})
+
+			t.Run("stored files can be seeked", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+				assert.NilError(t, store.Put("numbers.txt", strings.NewReader("0123456789"), "text/plain"))
+				f, err := store.Get("numbers.txt")
+				assert.NilError(t, err)
+				defer f.Close()
+
+				// act
+				_, err = f.Seek(4, io.SeekStart)
+				assert.NilError(t, err)
+				content, err := io.ReadAll(f)
+				assert.NilError(t, err)
+
+				// assert
+				assert.Equal(t, string(content), "456789")
+			})
+
+			t.Run("deleted files are not found", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+				assert.NilError(t, store.Put("tokyo.jpg", strings.NewReader("tokyo"), "image/jpeg"))
+
+				// act
+				err := store.Delete("tokyo.jpg")
+				assert.NilError(t, err)
+
+				// assert
+				_, err = store.Get("tokyo.jpg")
+				assert.Assert(t, errors.Is(err, app.ErrNotFound))
+				_, err = store.Stat("tokyo.jpg")
+				assert.Assert(t, errors.Is(err, app.ErrNotFound))
+				assert.NilError(t, store.Delete("tokyo.jpg"), "deleting a missing file is not an error")
+				listed, err := store.List("")
+				assert.NilError(t, err)
+				assert.Equal(t, len(listed), 0)
+			})
+		})
+	}
+
+	t.Run("s3 urls are presigned", func(t *testing.T) {
+		store := storage.NewS3Store(newFakeS3Client(t), "inari", "media/")
+
+		url, err := store.PresignURL("2023/tokyo.jpg", 15*time.Minute)
+
+		assert.NilError(t, err)
+		assert.Assert(t, strings.Contains(url, "/inari/media/2023/tokyo.jpg?"), url)
+		assert.Assert(t, strings.Contains(url, "X-Amz-Expires=900"), url)
+	})
+
+	t.Run("local files can not be presigned", func(t *testing.T) {
+		store := storage.NewLocalFSStore(t.TempDir())
+
+		_, err := store.PresignURL("2023/tokyo.jpg", 15*time.Minute)
+
+		assert.Assert(t, errors.Is(err, app.ErrNotSupported))
+	})
+
+	t.Run("local keys can not leave the store", func(t *testing.T) {
+		store := storage.NewLocalFSStore(t.TempDir())
+
+		err := store.Put("../tokyo.jpg", strings.NewReader("tokyo"), "image/jpeg")
+
+		assert.Assert(t, errors.Is(err, app.ErrInvalidInput))
+	})
+}
+
+func TestStoreUploader(t *testing.T) {
+	for _, backend := range testStores {
+		t.Run(backend.name, func(t *testing.T) {
+			t.Run("it copies files into the store", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+				src := writeFile(t, filepath.Join(t.TempDir(), "photo.jpg"), "photo")
+				upload := storage.NewStoreUploader(store)
+
+				// act
+				err := upload(src, "2023/04/apple-iphone-12/hash.jpg")
+
+				// assert
+				assert.NilError(t, err)
+				assert.Equal(t, readStored(t, store, "2023/04/apple-iphone-12/hash.jpg"), "photo")
+			})
+
+			t.Run("uploading the same content again does nothing", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+				assert.NilError(t, store.Put("2023/hash.jpg", strings.NewReader("photo"), "image/jpeg"))
+				src := writeFile(t, filepath.Join(t.TempDir(), "photo.jpg"), "photo")
+				upload := storage.NewStoreUploader(store)
+
+				// act
+				err := upload(src, "2023/hash.jpg")
+
+				// assert
+				assert.NilError(t, err)
+			})
+
+			t.Run("different content at the key is a conflict", func(t *testing.T) {
+				// arrange
+				store := backend.newStore(t)
+				assert.NilError(t, store.Put("2023/hash.jpg", strings.NewReader("something else"), "image/jpeg"))
+				src := writeFile(t, filepath.Join(t.TempDir(), "photo.jpg"), "photo")
+				upload := storage.NewStoreUploader(store)
+
+				// act
+				err := upload(src, "2023/hash.jpg")
+
+				// assert
+				assert.Assert(t, errors.Is(err, app.ErrConflict))
+				assert.Equal(t, readStored(t, store, "2023/hash.jpg"), "something else")
+			})
+		})
+	}
+
+	t.Run("local uploads leave no temp files", func(t *testing.T) {
+		dir := t.TempDir()
+		src := writeFile(t, filepath.Join(t.TempDir(), "photo.jpg"), "photo")
+
+		err := storage.NewStoreUploader(storage.NewLocalFSStore(dir))(src, "2023/hash.jpg")
+		assert.NilError(t, err)
+
+		entries, err := os.ReadDir(filepath.Join(dir, "2023"))
+		assert.NilError(t, err)
+		assert.Equal(t, len(entries), 1)
+	})
+}
+
+func TestStoreDownloader(t *testing.T) {
+	// arrange
+	store := storage.NewLocalFSStore(t.TempDir())
+	assert.NilError(t, store.Put("2023/hash.jpg", strings.NewReader("photo"), "image/jpeg"))
+	download := storage.NewStoreDownloader(store)
+
+	// act
+	tmpFilename, err := download("2023/hash.jpg")
+	assert.NilError(t, err)
+	defer os.Remove(tmpFilename)
+	_, missing := download("2023/missing.jpg")
+
+	// assert
+	content, err := os.ReadFile(tmpFilename)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "photo")
+	assert.Equal(t, filepath.Ext(tmpFilename), ".jpg")
+	assert.Assert(t, errors.Is(missing, app.ErrNotFound))
+}
+
+func TestLocalFSDownloader(t *testing.T) {
+	// arrange
+	src := writeFile(t, filepath.Join(os.TempDir(), "inari-downloader-test.jpg"), "photo")
+	t.Cleanup(func() { os.Remove(src) })
+
+	// act
+	tmpFilename, err := storage.NewLocalFSDownloader()(src)
+	assert.NilError(t, err)
+	defer os.Remove(tmpFilename)
+
+	// assert
+	assert.Assert(t, tmpFilename != src, "files in the temp dir are not copied onto themselves")
+	content, err := os.ReadFile(tmpFilename)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "photo")
+	content, err = os.ReadFile(src)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), "photo")
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+)
+
+type webDAVSource struct {
+	client *http.Client
+	root   url.URL
+}
+
+// NewWebDAVSource imports from a WebDAV server, keys are slash separated
+// paths relative to root. Credentials in root are sent as basic auth
+func NewWebDAVSource(root url.URL, client *http.Client) app.ImportSource {
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+	root.RawPath = ""
+	return webDAVSource{client: client, root: root}
+}
+
+type webDAVMultistatus struct {
+	Responses []webDAVResponse `xml:"DAV: response"`
+}
+
+type webDAVResponse struct {
+	Href     string           `xml:"DAV: href"`
+	Propstat []webDAVPropstat `xml:"DAV: propstat"`
+}
+
+type webDAVPropstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   struct {
+		ContentLength string `xml:"DAV: getcontentlength"`
+		LastModified  string `xml:"DAV: getlastmodified"`
+		ETag          string `xml:"DAV: getetag"`
+		ResourceType  struct {
+			Collection *struct{} `xml:"DAV: collection"`
+		} `xml:"DAV: resourcetype"`
+	} `xml:"DAV: prop"`
+}
+
+const webDAVPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop>
+<resourcetype/><getcontentlength/><getlastmodified/><getetag/>
+</prop></propfind>`
+
+func (s webDAVSource) url(key string) string {
+	u := s.root
+	u.User = nil
+	u.Path = s.root.Path + key
+	return u.String()
+}
+
+func (s webDAVSource) do(method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if s.root.User != nil {
+		password, _ := s.root.User.Password()
+		req.SetBasicAuth(s.root.User.Username(), password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", app.ErrNotFound, key)
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("webdav %s %s: %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+// List walks the collections under prefix one level at a time, as many
+// servers refuse infinite depth PROPFIND requests
+func (s webDAVSource) List(prefix string) ([]app.StoredObject, error) {
+	out := []app.StoredObject{}
+	dirs := []string{prefix[:strings.LastIndex(prefix, "/")+1]}
+	seen := map[string]bool{}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		responses, err := s.propfind(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range responses {
+			key, err := s.key(r.Href)
+			if err != nil {
+				return nil, err
+			}
+			for _, ps := range r.Propstat {
+				if !strings.Contains(ps.Status, " 200 ") {
+					continue
+				}
+				if ps.Prop.ResourceType.Collection != nil {
+					dirKey := strings.TrimSuffix(key, "/") + "/"
+					if key != "" && dirKey != dir {
+						dirs = append(dirs, dirKey)
+					}
+					continue
+				}
+				if !strings.HasPrefix(key, prefix) || strings.HasPrefix(path.Base(key), ".") {
+					continue
+				}
+				size, _ := strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+				modTime, _ := http.ParseTime(ps.Prop.LastModified)
+				out = append(out, app.StoredObject{
+					Key:     key,
+					Size:    size,
+					ModTime: modTime.UTC(),
+					ETag:    strings.Trim(strings.TrimPrefix(ps.Prop.ETag, "W/"), `"`),
+				})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+
+	return out, nil
+}
+
+func (s webDAVSource) propfind(dir string) ([]webDAVResponse, error) {
+	resp, err := s.do("PROPFIND", dir, strings.NewReader(webDAVPropfind), http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	multistatus := webDAVMultistatus{}
+	err = xml.NewDecoder(resp.Body).Decode(&multistatus)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webdav listing of %q: %w", dir, err)
+	}
+	return multistatus.Responses, nil
+}
+
+// key converts an href, which may be a full URL or an escaped path, to a key
+func (s webDAVSource) key(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid webdav href %q: %w", href, err)
+	}
+	if u.Path+"/" == s.root.Path {
+		return "", nil
+	}
+	if !strings.HasPrefix(u.Path, s.root.Path) {
+		return "", fmt.Errorf("webdav href %q is outside %s", href, s.root.Path)
+	}
+	return strings.TrimPrefix(u.Path, s.root.Path), nil
+}
+
+// Get downloads the file to a temp file that is removed when it is closed
+func (s webDAVSource) Get(key string) (app.StoredFile, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return app.StoredFile{}, err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.CreateTemp("", "inari-webdav-*")
+	if err != nil {
+		return app.StoredFile{}, err
+	}
+	tmp := &tempFile{File: f}
+	_, err = io.Copy(f, resp.Body)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return app.StoredFile{}, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+
+	return app.StoredFile{ReadSeekCloser: tmp, ModTime: modTime.UTC()}, nil
+}
+
+// tempFile is removed when it is closed
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,120 @@
+package storage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/storage"
+	"golang.org/x/net/webdav"
+	"gotest.tools/v3/assert"
+)
+
+// newFakeWebDAV serves files from memory at /dav/, requests need basic auth
+func newFakeWebDAV(t *testing.T, files map[string]string) string {
+	t.Helper()
+	ctx := context.Background()
+	fs := webdav.NewMemFS()
+	for name, content := range files {
+		assert.NilError(t, mkdirAll(ctx, fs, path.Dir(name)))
+		f, err := fs.OpenFile(ctx, name, os.O_CREATE|os.O_WRONLY, 0o600)
+		assert.NilError(t, err)
+		_, err = f.Write([]byte(content))
+		assert.NilError(t, err)
+		assert.NilError(t, f.Close())
+	}
+	handler := &webdav.Handler{Prefix: "/dav", FileSystem: fs, LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "inari" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func mkdirAll(ctx context.Context, fs webdav.FileSystem, dir string) error {
+	if dir == "/" {
+		return nil
+	}
+	err := mkdirAll(ctx, fs, path.Dir(dir))
+	if err != nil {
+		return err
+	}
+	err = fs.Mkdir(ctx, dir, 0o700)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+func TestWebDAVSource(t *testing.T) {
+	// arrange
+	serverURL := newFakeWebDAV(t, map[string]string{
+		"/photos/2023/tokyo tower.jpg": "tokyo",
+		"/photos/2023/04/kyoto.jpg":    "kyoto!",
+		"/photos/2024/leeds.jpg":       "leeds",
+		"/other/hull.jpg":              "hull",
+	})
+	root, err := url.Parse(serverURL + "/dav/photos/")
+	assert.NilError(t, err)
+	root.User = url.UserPassword("inari", "secret")
+	source := storage.NewWebDAVSource(*root, http.DefaultClient)
+
+	t.Run("it lists every file under the root", func(t *testing.T) {
+		listed, err := source.List("")
+
+		assert.NilError(t, err)
+		keys := []string{}
+		for _, object := range listed {
+			keys = append(keys, object.Key)
+		}
+		assert.DeepEqual(t, keys, []string{"2023/04/kyoto.jpg", "2023/tokyo tower.jpg", "2024/leeds.jpg"})
+		assert.Equal(t, listed[0].Size, int64(6))
+		assert.Assert(t, !listed[0].ModTime.IsZero())
+	})
+
+	t.Run("it lists files under a prefix", func(t *testing.T) {
+		listed, err := source.List("2024/")
+
+		assert.NilError(t, err)
+		assert.Equal(t, len(listed), 1)
+		assert.Equal(t, listed[0].Key, "2024/leeds.jpg")
+	})
+
+	t.Run("it reads files", func(t *testing.T) {
+		f, err := source.Get("2023/tokyo tower.jpg")
+		assert.NilError(t, err)
+		defer f.Close()
+		content, err := io.ReadAll(f)
+
+		assert.NilError(t, err)
+		assert.Equal(t, string(content), "tokyo")
+	})
+
+	t.Run("missing files are not found", func(t *testing.T) {
+		_, err := source.Get("2023/missing.jpg")
+
+		assert.Assert(t, errors.Is(err, app.ErrNotFound))
+	})
+
+	t.Run("bad credentials are an error", func(t *testing.T) {
+		badRoot := *root
+		badRoot.User = url.UserPassword("inari", "wrong")
+
+		_, err := storage.NewWebDAVSource(badRoot, http.DefaultClient).List("")
+
+		assert.ErrorContains(t, err, "401")
+	})
+}
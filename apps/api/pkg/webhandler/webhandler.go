@@ -1,16 +1,21 @@
 package webhandler
 
 import (
+	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
 	appconfig "github.com/j4y_funabashi/inari/apps/api/pkg/app_config"
@@ -55,15 +60,17 @@ func parseCollectionType(r *http.Request) app.CollectionType {
 type CreateCollectionRequest struct {
 	Title string             `json:"title,omitempty"`
 	Type  app.CollectionType `json:"type,omitempty"`
+	Query string             `json:"query,omitempty"`
 }
 
 type CreateCollectionResponse struct {
 	ID    string             `json:"id,omitempty"`
 	Title string             `json:"title,omitempty"`
 	Type  app.CollectionType `json:"type,omitempty"`
+	Query string             `json:"query,omitempty"`
 }
 
-func NewCreateCollectionHandler(createCollection app.CreateCollection) httprouter.Handle {
+func NewCreateCollectionHandler(createCollection app.CreateCollection, createSmartCollection app.CreateSmartCollection) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		// parse request
 		createRequest := CreateCollectionRequest{}
@@ -78,7 +85,16 @@ func NewCreateCollectionHandler(createCollection app.CreateCollection) httproute
 			return
 		}
 
-		newCollection, err := createCollection(createRequest.Title, createRequest.Type)
+		var newCollection app.Collection
+		if createRequest.Type == app.CollectionTypeSmart {
+			newCollection, err = createSmartCollection(createRequest.Title, createRequest.Query)
+		} else {
+			newCollection, err = createCollection(createRequest.Title, createRequest.Type)
+		}
+		if errors.Is(err, app.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -89,6 +105,7 @@ func NewCreateCollectionHandler(createCollection app.CreateCollection) httproute
 			ID:    newCollection.ID,
 			Title: newCollection.Title,
 			Type:  newCollection.Type,
+			Query: newCollection.Query,
 		}
 		w.Header().Set(ContentType, ContentTypeJSON)
 		w.WriteHeader(http.StatusCreated)
@@ -100,10 +117,24 @@ func NewCreateCollectionHandler(createCollection app.CreateCollection) httproute
 	}
 }
 
-func NewCollectionDetailHandler(queryCollectionDetail app.CollectionDetailQuery, logger app.Logger) httprouter.Handle {
+func NewCollectionDetailHandler(queryCollectionPage app.CollectionPageQuery, logger app.Logger) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		collectionID := ps.ByName("collectionid")
-		out, err := queryCollectionDetail(collectionID)
+		page, err := parseCollectionPage(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := queryCollectionPage(collectionID, page)
+		if errors.Is(err, app.ErrInvalidInput) || errors.Is(err, app.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to query collection detail",
 				"err", err)
@@ -116,6 +147,262 @@ func NewCollectionDetailHandler(queryCollectionDetail app.CollectionDetailQuery,
 	}
 }
 
+// parseCollectionPage reads ?cursor=&limit=&sort=&include=thumbnails_only
+func parseCollectionPage(r *http.Request) (app.CollectionPage, error) {
+	params := r.URL.Query()
+	page := app.CollectionPage{
+		Cursor:         params.Get("cursor"),
+		Sort:           app.MediaSort(params.Get("sort")),
+		ThumbnailsOnly: params.Get("include") == "thumbnails_only",
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		page.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return page, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+
+	return page, nil
+}
+
+func newAlbumMediaHandler(updateAlbumMedia app.AlbumMediaUpdater, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		collectionID := ps.ByName("collectionid")
+		mediaID := ps.ByName("mediaid")
+		err := updateAlbumMedia(collectionID, mediaID)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to update album media",
+				"err", err,
+				"collectionID", collectionID,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func newReorderAlbumHandler(reorderAlbum app.ReorderAlbum, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		collectionID := ps.ByName("collectionid")
+		mediaIDs := []string{}
+		err := json.NewDecoder(r.Body).Decode(&mediaIDs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = reorderAlbum(collectionID, mediaIDs)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to reorder album",
+				"err", err,
+				"collectionID", collectionID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func newUpdateAlbumHandler(updateAlbum app.UpdateAlbum, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		collectionID := ps.ByName("collectionid")
+		update := app.AlbumUpdate{}
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := updateAlbum(collectionID, update)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to update album",
+				"err", err,
+				"collectionID", collectionID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+type TriageRequest struct {
+	MediaIDs []string        `json:"media_ids"`
+	State    app.TriageState `json:"state"`
+}
+
+func newTriageHandler(setTriageState app.SetTriageState, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		triageRequest := TriageRequest{}
+		err := json.NewDecoder(r.Body).Decode(&triageRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = setTriageState(triageRequest.MediaIDs, triageRequest.State)
+		if errors.Is(err, app.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to set media triage state",
+				"err", err,
+				"state", triageRequest.State)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// newBulkMediaHandler responds with a per media report, 422 when the operation was rolled back
+func newBulkMediaHandler(bulkMediaOperation app.BulkMediaOperation, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		bulkRequest := app.BulkRequest{}
+		err := json.NewDecoder(r.Body).Decode(&bulkRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := bulkMediaOperation(bulkRequest)
+		if errors.Is(err, app.ErrInvalidInput) || errors.Is(err, app.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to apply bulk media operation",
+				"err", err,
+				"operation", bulkRequest.Operation)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		if out.Applied {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newNextUnreviewedHandler(nextUnreviewed app.NextUnreviewedQuery, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		collectionID := ps.ByName("collectionid")
+		out, err := nextUnreviewed(collectionID, r.URL.Query().Get("from"))
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to query next unreviewed media",
+				"err", err,
+				"collectionID", collectionID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newSearchMediaHandler(searchMedia app.SearchMedia, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query := r.URL.Query().Get("q")
+		out, err := searchMedia(query)
+		if err != nil {
+			logger.Error("failed to search media",
+				"err", err,
+				"query", query)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newListMediaHandler(listMedia app.MediaLister, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query, err := parseMediaQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := listMedia(query)
+		if errors.Is(err, app.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to list media",
+				"err", err,
+				"query", query.Query)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func parseMediaQuery(r *http.Request) (app.MediaQuery, error) {
+	params := r.URL.Query()
+	query := app.MediaQuery{
+		Query: params.Get("q"),
+		Sort:  app.MediaSort(params.Get("sort")),
+	}
+
+	var err error
+	if limit := params.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return query, fmt.Errorf("invalid limit: %s", limit)
+		}
+	}
+	if offset := params.Get("offset"); offset != "" {
+		query.Offset, err = strconv.Atoi(offset)
+		if err != nil {
+			return query, fmt.Errorf("invalid offset: %s", offset)
+		}
+	}
+
+	return query, nil
+}
+
 func newDeleteMediaHandler(deleteMedia app.DeleteMedia, logger app.Logger) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		mediaID := ps.ByName("mediaid")
@@ -162,6 +449,14 @@ func newUpdateMediaHashtagHandler(updateMediaHashtag app.UpdateMediaTextProperty
 			panic(err)
 		}
 		err = updateMediaHashtag(mediaID, string(newHashtag))
+		if errors.Is(err, app.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 		if err != nil {
 			logger.Error("failed to update media hashtag",
 				"err", err)
@@ -173,6 +468,396 @@ func newUpdateMediaHashtagHandler(updateMediaHashtag app.UpdateMediaTextProperty
 	}
 }
 
+func newRemoveMediaHashtagHandler(removeMediaHashtag app.UpdateMediaTextProperty, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		err := removeMediaHashtag(ps.ByName("mediaid"), ps.ByName("tag"))
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to remove media hashtag",
+				"err", err)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func newListHashtagsHandler(listHashtags app.HashtagLister, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		out, err := listHashtags()
+		if err != nil {
+			logger.Error("failed to list hashtags",
+				"err", err)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+type RenameHashtagRequest struct {
+	Title string `json:"title"`
+}
+
+// newRenameHashtagHandler renames a hashtag, renaming to an existing hashtag merges them
+func newRenameHashtagHandler(renameHashtag app.RenameHashtag, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		renameRequest := RenameHashtagRequest{}
+		err := json.NewDecoder(r.Body).Decode(&renameRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := renameHashtag(ps.ByName("tag"), renameRequest.Title)
+		if errors.Is(err, app.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to rename hashtag",
+				"err", err,
+				"tag", ps.ByName("tag"))
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// newFocusPointHandler sets the focus point from a {"x","y"} body on PUT and
+// clears it on DELETE, responding with the recreated thumbnails
+func newFocusPointHandler(updateFocusPoint app.UpdateFocusPoint, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		var focus *app.FocusPoint
+		if r.Method != http.MethodDelete {
+			focus = &app.FocusPoint{}
+			err := json.NewDecoder(r.Body).Decode(focus)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		out, err := updateFocusPoint(mediaID, focus)
+		if errors.Is(err, app.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to update focus point",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+type EditMediaRequest struct {
+	Edits []app.Edit `json:"edits"`
+}
+
+// newEditMediaHandler adds edits to a media, responding with the recreated thumbnails
+func newEditMediaHandler(editMedia app.EditMedia, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		editRequest := EditMediaRequest{}
+		err := json.NewDecoder(r.Body).Decode(&editRequest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		out, err := editMedia(mediaID, editRequest.Edits)
+		if errors.Is(err, app.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to edit media",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newRevertEditsHandler(revertEdits app.RevertEdits, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		out, err := revertEdits(mediaID)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to revert media edits",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newNormaliseHashtagsHandler(normaliseHashtags app.NormaliseHashtags, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		out, err := normaliseHashtags()
+		if err != nil {
+			logger.Error("failed to normalise hashtags",
+				"err", err)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func newListTrashHandler(listTrash app.TrashLister, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		out, err := listTrash()
+		if err != nil {
+			logger.Error("failed to list trash",
+				"err", err)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// newMediaDetailHandler serves a media, ?collection=&sort= set the
+// collection it is viewed in to include the previous and next media
+func newMediaDetailHandler(mediaDetail app.MediaDetailQuery, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		params := r.URL.Query()
+		out, err := mediaDetail(mediaID, app.MediaContext{
+			CollectionID: params.Get("collection"),
+			Sort:         app.MediaSort(params.Get("sort")),
+		})
+		if errors.Is(err, app.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to fetch media detail",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// NewOriginalMediaHandler streams a media's original file, range requests
+// let video players seek and the media hash is used as the ETag
+func NewOriginalMediaHandler(queryMediaDetail app.QueryMediaDetail, openOriginal app.FileOpener, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		media, err := queryMediaDetail(mediaID)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to fetch media detail",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		file, err := openOriginal(media.FilePath)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to open original",
+				"err", err,
+				"mediaID", mediaID,
+				"filePath", media.FilePath)
+			panic(err)
+		}
+		defer file.Close()
+
+		filename := path.Base(media.FilePath)
+		w.Header().Set(ContentType, originalContentType(media))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+		w.Header().Set("ETag", `"`+media.Hash+`"`)
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		http.ServeContent(w, r, filename, file.ModTime, file)
+	}
+}
+
+// originalContentType falls back to the file extension for media indexed
+// without a mime type
+func originalContentType(media app.Media) string {
+	if media.MimeType != "" {
+		return media.MimeType
+	}
+	contentType := mime.TypeByExtension(path.Ext(media.FilePath))
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// NewCollectionZipHandler streams a zip of a collection's original files,
+// files are stored uncompressed as photos and videos are already compressed
+func NewCollectionZipHandler(collectionDetail app.CollectionDetailQuery, openOriginal app.FileOpener, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		collectionID := ps.ByName("collectionid")
+		detail, err := collectionDetail(collectionID)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to fetch collection detail",
+				"err", err,
+				"collectionID", collectionID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": collectionID + ".zip"}))
+		w.WriteHeader(http.StatusOK)
+
+		archive := zip.NewWriter(w)
+		for _, media := range detail.Media {
+			err = writeZipFile(archive, openOriginal, media)
+			if err != nil {
+				// the response has started so the client only sees a truncated zip
+				logger.Error("failed to add media to zip",
+					"err", err,
+					"collectionID", collectionID,
+					"mediaID", media.ID)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		err = archive.Close()
+		if err != nil {
+			logger.Error("failed to write zip",
+				"err", err,
+				"collectionID", collectionID)
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+func writeZipFile(archive *zip.Writer, openOriginal app.FileOpener, media app.Media) error {
+	file, err := openOriginal(media.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dst, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     path.Base(media.FilePath),
+		Method:   zip.Store,
+		Modified: file.ModTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// NewThumbnailHandler serves thumbnails, thumbnail keys never change content
+// so they are cached forever. Missing thumbnails are generated from the original
+func NewThumbnailHandler(openThumbnail app.FileOpener, generateThumbnail app.ThumbnailGenerator, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		key := ps.ByName("key")
+		file, err := openThumbnail(key)
+		if errors.Is(err, app.ErrNotFound) {
+			err = generateThumbnail(key)
+			if err == nil {
+				file, err = openThumbnail(key)
+			}
+		}
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to open thumbnail",
+				"err", err,
+				"key", key)
+			panic(err)
+		}
+		defer file.Close()
+
+		w.Header().Set("ETag", `"`+key+`"`)
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeContent(w, r, key, file.ModTime, file)
+	}
+}
+
+func newRestoreMediaHandler(restoreMedia app.RestoreMedia, logger app.Logger) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		mediaID := ps.ByName("mediaid")
+		err := restoreMedia(mediaID)
+		if errors.Is(err, app.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("failed to restore media",
+				"err", err,
+				"mediaID", mediaID)
+			panic(err)
+		}
+
+		w.Header().Set(ContentType, ContentTypeJSON)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func newExportMediaHandler(export app.Exporter, logger app.Logger) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		mediaID := ps.ByName("mediaid")
@@ -197,38 +882,97 @@ func NewWebHandler() http.Handler {
 
 	// deps
 	logger := slog.Default()
-	collectionDetail := appconfig.NewCollectionDetail(baseDir)
+	collectionPage := appconfig.NewCollectionPage(baseDir)
 	deleteMedia := appconfig.NewDeleteMedia(baseDir)
+	listTrash := appconfig.NewListTrash(baseDir)
+	restoreMedia := appconfig.NewRestoreMedia(baseDir)
 	exportMedia := appconfig.NewExportMedia(baseDir)
 	updateMediaCaption := appconfig.NewUpdateMediaCaption(baseDir)
 	updateMediaHashtag := appconfig.NewUpdateMediaHashtag(baseDir)
+	removeMediaHashtag := appconfig.NewRemoveMediaHashtag(baseDir)
+	listHashtags := appconfig.NewListHashtags(baseDir)
+	renameHashtag := appconfig.NewRenameHashtag(baseDir)
+	normaliseHashtags := appconfig.NewNormaliseHashtags(baseDir)
 	queryMediaDetail := appconfig.NewMediaDetail(baseDir)
-
-	// uploader
+	mediaDetailView := appconfig.NewMediaDetailView(baseDir)
+	collectionDetail := appconfig.NewCollectionDetail(baseDir)
+	openOriginal := appconfig.NewOpenOriginal(baseDir)
+	openThumbnail := appconfig.NewOpenThumbnail(baseDir)
+	generateThumbnail := appconfig.NewThumbnailGenerator(baseDir)
+	updateFocusPoint := appconfig.NewUpdateFocusPoint(baseDir)
+	editMedia := appconfig.NewEditMedia(baseDir)
+	revertEdits := appconfig.NewRevertEdits(baseDir)
+	searchMedia := appconfig.NewSearchMedia(baseDir)
+	listMedia := appconfig.NewMediaLister(baseDir)
+	addMediaToAlbum := appconfig.NewAddMediaToAlbum(baseDir)
+	removeMediaFromAlbum := appconfig.NewRemoveMediaFromAlbum(baseDir)
+	reorderAlbum := appconfig.NewReorderAlbum(baseDir)
+	updateAlbum := appconfig.NewUpdateAlbum(baseDir)
+	setTriageState := appconfig.NewSetTriageState(baseDir)
+	nextUnreviewed := appconfig.NewNextUnreviewed(baseDir)
+
+	// export stores
 	micropubBucket := "micropub.funabashi.co.uk"
 	mediaBucket := "media.funabashi.co.uk"
 	cfg, _ := config.LoadDefaultConfig(context.TODO())
 	s3Client := s3.NewFromConfig(cfg)
-	s3Uploader := manager.NewUploader(s3Client)
 
-	micropubUploader := storage.NewUploader(micropubBucket, s3Uploader, s3Client)
-	mediaUploader := storage.NewUploader(mediaBucket, s3Uploader, s3Client)
+	micropubStore := storage.NewS3Store(s3Client, micropubBucket, "")
+	mediaStore := storage.NewS3Store(s3Client, mediaBucket, "")
 
-	exporter := appconfig.NewExporter(logger, queryMediaDetail, mediaUploader, micropubUploader, baseDir, exportMedia)
+	exporter := appconfig.NewExporter(logger, queryMediaDetail, mediaStore, micropubStore, baseDir, exportMedia)
+	bulkMediaOperation := appconfig.NewBulkMediaOperation(baseDir, exporter)
 
 	// routes
 	router := httprouter.New()
 
 	// collections
-	router.POST(CollectionsPath, NewCreateCollectionHandler(inariApp.CreateCollection))
+	router.POST(CollectionsPath, NewCreateCollectionHandler(inariApp.CreateCollection, inariApp.CreateSmartCollection))
 	router.GET(CollectionsPath, NewListCollectionsHandler(inariApp.ListCollections, logger))
-	router.GET("/api/timeline/month/:collectionid", NewCollectionDetailHandler(collectionDetail, logger))
+	router.GET("/api/timeline/month/:collectionid", NewCollectionDetailHandler(collectionPage, logger))
+	router.GET("/api/timeline/month/:collectionid/next-unreviewed", newNextUnreviewedHandler(nextUnreviewed, logger))
+	router.GET("/api/timeline/month/:collectionid/zip", NewCollectionZipHandler(collectionDetail, openOriginal, logger))
+
+	// albums
+	router.PATCH("/api/collections/:collectionid", newUpdateAlbumHandler(updateAlbum, logger))
+	router.PUT("/api/collections/:collectionid/order", newReorderAlbumHandler(reorderAlbum, logger))
+	router.POST("/api/collections/:collectionid/media/:mediaid", newAlbumMediaHandler(addMediaToAlbum, logger))
+	router.DELETE("/api/collections/:collectionid/media/:mediaid", newAlbumMediaHandler(removeMediaFromAlbum, logger))
+
+	// triage
+	router.POST("/api/triage", newTriageHandler(setTriageState, logger))
+
+	// bulk
+	router.POST("/api/bulk", newBulkMediaHandler(bulkMediaOperation, logger))
+
+	// hashtags
+	router.GET("/api/hashtags", newListHashtagsHandler(listHashtags, logger))
+	router.PATCH("/api/hashtags/:tag", newRenameHashtagHandler(renameHashtag, logger))
+	router.POST("/api/hashtags/normalise", newNormaliseHashtagsHandler(normaliseHashtags, logger))
+
+	// trash
+	router.GET("/api/trash", newListTrashHandler(listTrash, logger))
+
+	// search
+	router.GET("/api/search", newSearchMediaHandler(searchMedia, logger))
 
 	// media
+	router.GET("/api/media", newListMediaHandler(listMedia, logger))
+	router.GET("/api/media/:mediaid", newMediaDetailHandler(mediaDetailView, logger))
+	router.GET("/api/media/:mediaid/original", NewOriginalMediaHandler(queryMediaDetail, openOriginal, logger))
 	router.DELETE("/api/media/:mediaid", newDeleteMediaHandler(deleteMedia, logger))
 	router.POST("/api/media/:mediaid/caption", newUpdateMediaCaptionHandler(updateMediaCaption, logger))
 	router.POST("/api/media/:mediaid/hashtag", newUpdateMediaHashtagHandler(updateMediaHashtag, logger))
+	router.DELETE("/api/media/:mediaid/hashtag/:tag", newRemoveMediaHashtagHandler(removeMediaHashtag, logger))
 	router.POST("/api/media/:mediaid/export", newExportMediaHandler(exporter, logger))
+	router.POST("/api/media/:mediaid/restore", newRestoreMediaHandler(restoreMedia, logger))
+	router.PUT("/api/media/:mediaid/focus", newFocusPointHandler(updateFocusPoint, logger))
+	router.DELETE("/api/media/:mediaid/focus", newFocusPointHandler(updateFocusPoint, logger))
+	router.POST("/api/media/:mediaid/edits", newEditMediaHandler(editMedia, logger))
+	router.DELETE("/api/media/:mediaid/edits", newRevertEditsHandler(revertEdits, logger))
+
+	// thumbnails
+	router.GET("/media/thumbnails/:key", NewThumbnailHandler(openThumbnail, generateThumbnail, logger))
 
 	return router
 }
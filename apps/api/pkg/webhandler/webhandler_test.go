@@ -1 +1,246 @@
 package webhandler_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/j4y_funabashi/inari/apps/api/pkg/app"
+	"github.com/j4y_funabashi/inari/apps/api/pkg/webhandler"
+	"github.com/julienschmidt/httprouter"
+	"gotest.tools/v3/assert"
+)
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+func newTestOpener(files map[string]string) app.FileOpener {
+	return func(filename string) (app.StoredFile, error) {
+		content, ok := files[filename]
+		if !ok {
+			return app.StoredFile{}, app.ErrNotFound
+		}
+		return app.StoredFile{
+			ReadSeekCloser: nopCloser{bytes.NewReader([]byte(content))},
+			ModTime:        time.Date(2023, time.April, 10, 9, 0, 0, 0, time.UTC),
+		}, nil
+	}
+}
+
+func TestOriginalMediaHandler(t *testing.T) {
+	media := map[string]app.Media{
+		"hash-tokyo": {
+			ID:            "hash-tokyo",
+			FilePath:      "2023/20230410_090000_hash-tokyo.mp4",
+			MediaMetadata: app.MediaMetadata{Hash: "hash-tokyo", MimeType: "video/mp4"},
+		},
+		"hash-kyoto": {
+			ID:            "hash-kyoto",
+			FilePath:      "2023/20230411_090000_hash-kyoto.JPG",
+			MediaMetadata: app.MediaMetadata{Hash: "hash-kyoto"},
+		},
+		"hash-leeds": {
+			ID:            "hash-leeds",
+			FilePath:      "2023/20230412_090000_hash-leeds",
+			MediaMetadata: app.MediaMetadata{Hash: "hash-leeds"},
+		},
+	}
+	queryMediaDetail := func(mediaID string) (app.Media, error) {
+		m, ok := media[mediaID]
+		if !ok {
+			return app.Media{}, app.ErrNotFound
+		}
+		return m, nil
+	}
+	router := httprouter.New()
+	router.GET("/api/media/:mediaid/original", webhandler.NewOriginalMediaHandler(
+		queryMediaDetail,
+		newTestOpener(map[string]string{
+			media["hash-tokyo"].FilePath: "0123456789",
+			media["hash-kyoto"].FilePath: "0123456789",
+			media["hash-leeds"].FilePath: "0123456789",
+		}),
+		app.NewNullLogger(),
+	))
+
+	testCases := []struct {
+		desc                string
+		mediaID             string
+		header              http.Header
+		expectedStatus      int
+		expectedBody        string
+		expectedContentType string
+	}{
+		{
+			desc:                "it streams the whole file",
+			mediaID:             "hash-tokyo",
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "0123456789",
+			expectedContentType: "video/mp4",
+		},
+		{
+			desc:                "it uses the extension when there is no mime type",
+			mediaID:             "hash-kyoto",
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "0123456789",
+			expectedContentType: "image/jpeg",
+		},
+		{
+			desc:                "it falls back to a binary content type",
+			mediaID:             "hash-leeds",
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "0123456789",
+			expectedContentType: "application/octet-stream",
+		},
+		{
+			desc:           "it serves ranges",
+			mediaID:        "hash-tokyo",
+			header:         http.Header{"Range": {"bytes=2-5"}},
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "2345",
+		},
+		{
+			desc:           "it is not modified when the etag matches",
+			mediaID:        "hash-tokyo",
+			header:         http.Header{"If-None-Match": {`"hash-tokyo"`}},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			desc:           "missing media is not found",
+			mediaID:        "missing",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			// arrange
+			req := httptest.NewRequest(http.MethodGet, "/api/media/"+tC.mediaID+"/original", nil)
+			for k, v := range tC.header {
+				req.Header[k] = v
+			}
+			res := httptest.NewRecorder()
+
+			// act
+			router.ServeHTTP(res, req)
+
+			// assert
+			assert.Equal(t, res.Code, tC.expectedStatus)
+			assert.Equal(t, res.Body.String(), tC.expectedBody)
+			if tC.expectedStatus == http.StatusOK {
+				assert.Equal(t, res.Header().Get("Content-Type"), tC.expectedContentType)
+				assert.Equal(t, res.Header().Get("ETag"), `"`+tC.mediaID+`"`)
+				assert.Equal(t, res.Header().Get("Content-Disposition"), "attachment; filename="+path.Base(media[tC.mediaID].FilePath))
+			}
+		})
+	}
+}
+
+func TestCollectionZipHandler(t *testing.T) {
+	// arrange
+	collectionDetail := func(collectionID string) (app.CollectionDetail, error) {
+		return app.CollectionDetail{Media: []app.Media{
+			{ID: "hash-tokyo", FilePath: "2023/tokyo.jpg"},
+			{ID: "hash-kyoto", FilePath: "2023/kyoto.jpg"},
+		}}, nil
+	}
+	router := httprouter.New()
+	router.GET("/api/timeline/month/:collectionid/zip", webhandler.NewCollectionZipHandler(
+		collectionDetail,
+		newTestOpener(map[string]string{"2023/tokyo.jpg": "tokyo", "2023/kyoto.jpg": "kyoto"}),
+		app.NewNullLogger(),
+	))
+	req := httptest.NewRequest(http.MethodGet, "/api/timeline/month/japan/zip", nil)
+	res := httptest.NewRecorder()
+
+	// act
+	router.ServeHTTP(res, req)
+
+	// assert
+	assert.Equal(t, res.Code, http.StatusOK)
+	assert.Equal(t, res.Header().Get("Content-Disposition"), `attachment; filename=japan.zip`)
+	archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	assert.NilError(t, err)
+	files := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.NilError(t, err)
+		content, err := io.ReadAll(r)
+		assert.NilError(t, err)
+		files[f.Name] = string(content)
+	}
+	assert.DeepEqual(t, files, map[string]string{"tokyo.jpg": "tokyo", "kyoto.jpg": "kyoto"})
+}
+
+func TestThumbnailHandler(t *testing.T) {
+	// arrange
+	thumbnails := map[string]string{"sqmd_tokyo.jpg": "medium"}
+	generated := []string{}
+	generateThumbnail := func(key string) error {
+		if key != "lg_tokyo.jpg" {
+			return app.ErrNotFound
+		}
+		generated = append(generated, key)
+		thumbnails[key] = "large"
+		return nil
+	}
+	router := httprouter.New()
+	router.GET("/media/thumbnails/:key", webhandler.NewThumbnailHandler(
+		newTestOpener(thumbnails),
+		generateThumbnail,
+		app.NewNullLogger(),
+	))
+
+	testCases := []struct {
+		desc           string
+		key            string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			desc:           "it serves existing thumbnails",
+			key:            "sqmd_tokyo.jpg",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "medium",
+		},
+		{
+			desc:           "it generates missing thumbnails",
+			key:            "lg_tokyo.jpg",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "large",
+		},
+		{
+			desc:           "unknown thumbnails are not found",
+			key:            "lg_missing.jpg",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "not found\n",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/media/thumbnails/"+tC.key, nil)
+			res := httptest.NewRecorder()
+
+			// act
+			router.ServeHTTP(res, req)
+
+			// assert
+			assert.Equal(t, res.Code, tC.expectedStatus)
+			assert.Equal(t, res.Body.String(), tC.expectedBody)
+			if tC.expectedStatus == http.StatusOK {
+				assert.Equal(t, res.Header().Get("Content-Type"), "image/jpeg")
+				assert.Equal(t, res.Header().Get("ETag"), `"`+tC.key+`"`)
+				assert.Equal(t, res.Header().Get("Cache-Control"), "public, max-age=31536000, immutable")
+			}
+		})
+	}
+	assert.DeepEqual(t, generated, []string{"lg_tokyo.jpg"})
+}
@@ -4,12 +4,22 @@ export interface Media {
     collections: Collection[]
     date: string
     is_exported: boolean
+    thumbnails_pending?: boolean
+    thumbnails_failed?: string
     location?: Location
     caption?: string
 }
 interface Thumbnails {
     medium: string
     large: string
+    sizes?: Record<string, ThumbnailSize>
+    blurhash?: string
+    dominant_colour?: string
+}
+interface ThumbnailSize {
+    width: number
+    height: number
+    sources: Record<string, string>
 }
 
 export interface Collection {
@@ -39,13 +49,23 @@ interface Coordinates {
 export interface CollectionDetail {
     collection_meta: Collection;
     media: Media[]
+    next_cursor?: string
 }
 
+// getCollectionDetail follows next_cursor until every page of the collection is loaded
 export const getCollectionDetail = async function(id: string): Promise<CollectionDetail> {
     const res = await fetch("/api/timeline/month/" + id)
     console.log(res)
 
-    return res.json()
+    const detail: CollectionDetail = await res.json()
+    while (detail.next_cursor) {
+        const nextRes = await fetch("/api/timeline/month/" + id + "?cursor=" + encodeURIComponent(detail.next_cursor))
+        const next: CollectionDetail = await nextRes.json()
+        detail.media.push(...next.media)
+        detail.next_cursor = next.next_cursor
+    }
+
+    return detail
 }
 export const mockGetCollectionDetail = function(id: string): Promise<CollectionDetail> {
     return new Promise((resolve, reject) => {
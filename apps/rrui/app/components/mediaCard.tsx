@@ -29,7 +29,7 @@ interface MediaCardProps {
 
 export const MediaCard = function({ m, displayType, handleDelete, handleShowCollectionList, saveCaption, saveHashtag, setCurrent, setNext, setPrev, setBack, showNav, showMeta, showEditButtons, exportMedia }: MediaCardProps) {
 
-    const srcPrefix = process.env.NODE_ENV === "production" ? "/thumbnails/" : ""
+    const srcPrefix = process.env.NODE_ENV === "production" ? "/media/thumbnails/" : ""
     const srcUrl = displayType === MediaCardDisplayType.large ? `${srcPrefix}${m.thumbnails.large}`
         : `${srcPrefix}${m.thumbnails.medium}`
 
@@ -61,7 +61,16 @@ export const MediaCard = function({ m, displayType, handleDelete, handleShowColl
                 </nav>
             }
             <a href="#" onClick={() => { setCurrent(m.id) }}>
-                <img src={srcUrl} className="" alt={caption} />
+                {m.thumbnails_pending ?
+                    <div className="aspect-square bg-gray-700 text-gray-400 text-xs flex items-center justify-center" role="img" aria-label={caption}>
+                        processing
+                    </div>
+                    : m.thumbnails_failed && !m.thumbnails.large ?
+                    <div className="aspect-square bg-gray-700 text-red-400 text-xs flex items-center justify-center" role="img" aria-label={caption} title={m.thumbnails_failed}>
+                        thumbnails failed
+                    </div>
+                    : <img src={srcUrl} className="" alt={caption} style={{ backgroundColor: m.thumbnails.dominant_colour }} />
+                }
             </a>
 
             <ExportedIcon m={m} />